@@ -3,6 +3,22 @@ import { Loader } from "lucide-react";
 import Link from "next/link";
 import { useParams, useRouter, useSearchParams } from "next/navigation";
 import React, { useEffect, useRef, useState } from "react";
+import { API_URL, apiHeaders } from "../../api";
+
+type Citation = {
+  fileID: string;
+  path: string;
+  startLine: number;
+  endLine: number;
+  quote?: string;
+};
+
+type ContentPart = {
+  type: "text" | "image_file" | "refusal";
+  text?: string;
+  fileID?: string;
+  url?: string;
+};
 
 type QueryResponse = {
   message: string;
@@ -10,6 +26,37 @@ type QueryResponse = {
   reponame: string;
   threadID: string;
   response: string;
+  parts: ContentPart[];
+  citations: Citation[];
+};
+
+type Message = {
+  sender: string;
+  text: string;
+  images?: string[];
+  citations?: Citation[];
+};
+
+const AuthImage = ({ src }: { src: string }) => {
+  const [objectURL, setObjectURL] = useState<string>();
+
+  useEffect(() => {
+    let url: string | undefined;
+    fetch(src, { headers: apiHeaders() })
+      .then((res) => (res.ok ? res.blob() : Promise.reject(res.statusText)))
+      .then((blob) => {
+        url = URL.createObjectURL(blob);
+        setObjectURL(url);
+      })
+      .catch((err) => console.error("Error fetching image", err));
+    return () => {
+      if (url) URL.revokeObjectURL(url);
+    };
+  }, [src]);
+
+  if (!objectURL) return null;
+  // eslint-disable-next-line @next/next/no-img-element
+  return <img src={objectURL} alt="assistant output" className="mt-2 rounded" />;
 };
 
 const RepoPage = () => {
@@ -20,9 +67,11 @@ const RepoPage = () => {
   const router = useRouter()
 
   const tid = searchParams.get("tid");
+  // Limits a new conversation to part of a monorepo, e.g. ?path=services/billing
+  const path = searchParams.get("path");
 
   const params = useParams();
-  const [messages, setMessages] = useState([
+  const [messages, setMessages] = useState<Message[]>([
     { sender: "bot", text: "What would you like to know?" },
   ]);
 
@@ -43,6 +92,21 @@ const RepoPage = () => {
     };
   }, []);
 
+  useEffect(() => {
+    fetch(`${API_URL}/api/v1/repos/${githubUser}/${repoName}/overview`, {
+      headers: apiHeaders(),
+    })
+      .then((res) => (res.ok ? res.json() : null))
+      .then((overview: { purpose?: string } | null) => {
+        if (!overview?.purpose) return;
+        setMessages((prevMessages) => [
+          { sender: "bot", text: `${overview.purpose} What would you like to know?` },
+          ...prevMessages.slice(1),
+        ]);
+      })
+      .catch((err) => console.error("Error fetching overview", err));
+  }, [githubUser, repoName]);
+
   useEffect(() => {
     if (scrollRef.current) {
       scrollRef.current.scrollTop = scrollRef.current.scrollHeight;
@@ -71,17 +135,25 @@ const RepoPage = () => {
     if (inputRef.current) {
       inputRef.current.value = "";
     }
-    const response = await fetch("http://localhost:8080/api/v1/query", {
+    const response = await fetch(`${API_URL}/api/v1/query`, {
       method: "POST",
-      headers: { "Content-Type": "application/json" },
-      body: JSON.stringify({ question, tid, githubUser, repoName }),
+      headers: apiHeaders(),
+      body: JSON.stringify({
+        question,
+        tid,
+        githubUser,
+        repoName,
+        paths: path ? [path] : undefined,
+      }),
     });
 
     if (response.ok) {
       const data: QueryResponse = await response.json();
 
       if (!tid) {
-        const newURL = `?tid=${data.threadID}`;
+        const newURL = path
+          ? `?tid=${data.threadID}&path=${encodeURIComponent(path)}`
+          : `?tid=${data.threadID}`;
         router.push(newURL) // Update URL
       }
 
@@ -90,6 +162,10 @@ const RepoPage = () => {
         {
           sender: "bot",
           text: data.response,
+          images: (data.parts ?? [])
+            .filter((p) => p.type === "image_file" && p.url)
+            .map((p) => `${API_URL}${p.url}`),
+          citations: data.citations,
         },
       ]);
       setIsDisabled(false);
@@ -114,11 +190,16 @@ const RepoPage = () => {
           talking with:{" "}
           <Link
             className="text-[#b2b937] hover:underline hover:underline-offset-4"
-            href={`https://github.com/${githubUser}/${repoName}`}
+            href={
+              path
+                ? `https://github.com/${githubUser}/${repoName}/tree/HEAD/${path}`
+                : `https://github.com/${githubUser}/${repoName}`
+            }
             target="_blank"
             rel="noopener noreferrer"
           >
             github.com/{githubUser}/{repoName}
+            {path && `/${path}`}
           </Link>
         </span>
         <div className="h-full w-full border-2 border-[#242600] rounded-md max-h-[500px] overflow-hidden">
@@ -145,6 +226,20 @@ const RepoPage = () => {
                     } top-1/2 transform  -translate-y-1/2  w-3 h-3  border-2 `}
                   ></div>
                   {message.text}
+                  {message.images?.map((src) => (
+                    <AuthImage key={src} src={src} />
+                  ))}
+                  {message.citations && message.citations.some((c) => c.path) && (
+                    <ul className="mt-2 text-xs font-mono text-[#b2b937]">
+                      {message.citations
+                        .filter((c) => c.path)
+                        .map((c, i) => (
+                          <li key={i}>
+                            {c.path}:{c.startLine}-{c.endLine}
+                          </li>
+                        ))}
+                    </ul>
+                  )}
                 </div>
               </div>
             ))}
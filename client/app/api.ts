@@ -0,0 +1,7 @@
+// Requests go to the app's own /api/v1 route, which adds the API key on the
+// server; see app/api/v1/[...path]/route.ts.
+export const API_URL = "";
+
+export const apiHeaders = (): Record<string, string> => ({
+  "Content-Type": "application/json",
+});
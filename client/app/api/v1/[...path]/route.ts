@@ -0,0 +1,45 @@
+import { NextRequest } from "next/server";
+
+// The browser talks to this route, which forwards to the repotalk server
+// with the tenant's API key added here, so the key never reaches the client.
+const API_URL = process.env.REPOTALK_API_URL ?? "http://localhost:8080";
+
+// Only the endpoints the UI uses are forwarded.
+const FORWARDED = new Set(["crawl", "query", "repos", "files"]);
+
+async function forward(
+  request: NextRequest,
+  { params }: { params: Promise<{ path: string[] }> }
+) {
+  const { path } = await params;
+  if (!FORWARDED.has(path[0])) {
+    return new Response("Not found", { status: 404 });
+  }
+
+  const headers = new Headers();
+  const contentType = request.headers.get("content-type");
+  if (contentType) {
+    headers.set("Content-Type", contentType);
+  }
+  const key = process.env.REPOTALK_API_KEY;
+  if (key) {
+    headers.set("Authorization", `Bearer ${key}`);
+  }
+
+  const url = `${API_URL}/api/v1/${path.map(encodeURIComponent).join("/")}${request.nextUrl.search}`;
+  const response = await fetch(url, {
+    method: request.method,
+    headers,
+    body: request.method === "GET" ? undefined : await request.arrayBuffer(),
+    cache: "no-store",
+  });
+
+  return new Response(response.body, {
+    status: response.status,
+    headers: {
+      "Content-Type": response.headers.get("content-type") ?? "application/octet-stream",
+    },
+  });
+}
+
+export { forward as GET, forward as POST };
@@ -4,6 +4,7 @@ import Image from "next/image";
 import Link from "next/link";
 import { useRouter } from "next/navigation";
 import { useEffect, useRef, useState } from "react";
+import { API_URL, apiHeaders } from "./api";
 
 export default function Home() {
   const inputRef = useRef<HTMLInputElement>(null);
@@ -31,9 +32,9 @@ export default function Home() {
     const formData = new FormData(event.target as HTMLFormElement);
     const githubUrl = formData.get("githubUrl");
 
-    const response = await fetch("http://localhost:8080/api/v1/crawl", {
+    const response = await fetch(`${API_URL}/api/v1/crawl`, {
       method: "POST",
-      headers: { "Content-Type": "application/json" },
+      headers: apiHeaders(),
       body: JSON.stringify({ githubUrl }),
     });
 
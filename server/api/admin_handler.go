@@ -0,0 +1,122 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gastrader/repotalk/instructions"
+	"github.com/gastrader/repotalk/store"
+	"github.com/gastrader/repotalk/types"
+)
+
+type AdminHandler struct {
+	tenants  *store.TenantStore
+	versions *instructions.Registry
+	token    string
+}
+
+// NewAdminHandler serves tenant, key and instructions management. An empty
+// token disables the admin API.
+func NewAdminHandler(tenants *store.TenantStore, versions *instructions.Registry, token string) *AdminHandler {
+	return &AdminHandler{
+		tenants:  tenants,
+		versions: versions,
+		token:    token,
+	}
+}
+
+func (ah *AdminHandler) authorized(r *http.Request) bool {
+	if ah.token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(apiKey(r)), []byte(ah.token)) == 1
+}
+
+// TenantsHandler serves:
+//
+//	GET  /api/v1/admin/tenants
+//	POST /api/v1/admin/tenants
+//	POST /api/v1/admin/tenants/{id}/keys
+func (ah *AdminHandler) TenantsHandler(w http.ResponseWriter, r *http.Request) {
+	if !ah.authorized(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/tenants"), "/")
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		tenants := []types.TenantResponse{}
+		for _, tenant := range ah.tenants.List() {
+			tenants = append(tenants, tenantResponse(tenant))
+		}
+		writeJSON(w, http.StatusOK, tenants)
+
+	case rest == "" && r.Method == http.MethodPost:
+		var req types.CreateTenantRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			http.Error(w, "Invalid JSON payload: name is required", http.StatusBadRequest)
+			return
+		}
+		tenant, err := ah.tenants.Create(req.Name, req.OpenAIKey)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error creating tenant: %v", err), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, tenantResponse(tenant))
+
+	case strings.HasSuffix(rest, "/keys") && r.Method == http.MethodPost:
+		tenantID := strings.TrimSuffix(rest, "/keys")
+		key, plaintext, err := ah.tenants.IssueKey(tenantID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error issuing key: %v", err), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusCreated, types.IssueKeyResponse{ID: key.ID, TenantID: key.TenantID, Key: plaintext})
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// KeysHandler serves DELETE /api/v1/admin/keys/{id}.
+func (ah *AdminHandler) KeysHandler(w http.ResponseWriter, r *http.Request) {
+	if !ah.authorized(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	keyID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/keys"), "/")
+	if err := ah.tenants.RevokeKey(keyID); err != nil {
+		http.Error(w, fmt.Sprintf("Error revoking key: %v", err), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// InstructionsHandler serves GET /api/v1/admin/instructions, the version
+// history of every instructions file.
+func (ah *AdminHandler) InstructionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !ah.authorized(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ah.versions.History())
+}
+
+func tenantResponse(tenant types.Tenant) types.TenantResponse {
+	return types.TenantResponse{ID: tenant.ID, Name: tenant.Name, CreatedAt: tenant.CreatedAt}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gastrader/repotalk/store"
+	"github.com/gastrader/repotalk/types"
+)
+
+type principalKey struct{}
+
+type principal struct {
+	tenant types.Tenant
+	key    types.APIKey
+}
+
+// RequireAPIKey authenticates requests with an "Authorization: Bearer <key>"
+// or "X-API-Key: <key>" header and makes the caller's tenant available to
+// the wrapped handler.
+func RequireAPIKey(tenants *store.TenantStore, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant, key, ok := tenants.Authenticate(apiKey(r))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="repotalk"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey{}, principal{tenant: tenant, key: key})
+		next(w, r.WithContext(ctx))
+	}
+}
+
+func apiKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+func principalFrom(r *http.Request) (principal, bool) {
+	p, ok := r.Context().Value(principalKey{}).(principal)
+	return p, ok
+}
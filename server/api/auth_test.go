@@ -0,0 +1,74 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gastrader/repotalk/store"
+)
+
+func TestRequireAPIKey(t *testing.T) {
+	tenants, err := store.NewTenantStore(filepath.Join(t.TempDir(), "tenants.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant, err := tenants.Create("acme", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, plaintext, err := tenants.IssueKey(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, revokedText, err := tenants.IssueKey(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tenants.RevokeKey(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		wantCode int
+	}{
+		{"bearer", "Authorization", "Bearer " + plaintext, http.StatusOK},
+		{"x-api-key", "X-API-Key", plaintext, http.StatusOK},
+		{"no key", "", "", http.StatusUnauthorized},
+		{"wrong scheme", "Authorization", "Basic " + plaintext, http.StatusUnauthorized},
+		{"wrong secret", "Authorization", "Bearer " + plaintext[:len(plaintext)-1] + "x", http.StatusUnauthorized},
+		{"too short", "Authorization", "Bearer rt_", http.StatusUnauthorized},
+		{"revoked", "Authorization", "Bearer " + revokedText, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got principal
+			h := RequireAPIKey(tenants, func(w http.ResponseWriter, r *http.Request) {
+				got, _ = principalFrom(r)
+			})
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/repos", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusUnauthorized {
+				if w.Header().Get("WWW-Authenticate") == "" {
+					t.Error("no WWW-Authenticate header")
+				}
+				return
+			}
+			if got.tenant.ID != tenant.ID || got.key.ID != key.ID {
+				t.Errorf("principal = %s/%s, want %s/%s", got.tenant.ID, got.key.ID, tenant.ID, key.ID)
+			}
+		})
+	}
+}
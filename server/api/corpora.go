@@ -0,0 +1,160 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gastrader/repotalk/assistant"
+	"github.com/gastrader/repotalk/types"
+	"github.com/gastrader/repotalk/utils"
+)
+
+// maxCorpora is the most files a thread message can attach.
+const maxCorpora = 10
+
+const maxLabelLength = 64
+
+// corpusRepo is a corpus attached to a thread with the crawl behind it.
+type corpusRepo struct {
+	types.Corpus
+	repo types.RepoRecord
+}
+
+// resolveCorpora looks up the crawled repositories refs name for a new
+// thread. It returns the status to respond with when they are invalid.
+func (rh *RepoHandler) resolveCorpora(tenantID string, refs []types.CorpusRef) ([]corpusRepo, int, error) {
+	if len(refs) > maxCorpora {
+		return nil, http.StatusBadRequest, fmt.Errorf("At most %d corpora can be attached", maxCorpora)
+	}
+
+	corpora := make([]corpusRepo, 0, len(refs))
+	labels := make(map[string]bool)
+	files := make(map[string]string)
+	for _, ref := range refs {
+		if ref.GithubUser == "" || ref.RepoName == "" {
+			return nil, http.StatusBadRequest, fmt.Errorf("githubUser and repoName are required for each corpus")
+		}
+		label := strings.TrimSpace(ref.Label)
+		if label == "" {
+			label = ref.GithubUser + "/" + ref.RepoName
+		}
+		if len(label) > maxLabelLength {
+			return nil, http.StatusBadRequest, fmt.Errorf("Corpus label '%s' is longer than %d characters", label, maxLabelLength)
+		}
+		if labels[label] {
+			return nil, http.StatusBadRequest, fmt.Errorf("Corpus label '%s' is used twice", label)
+		}
+		labels[label] = true
+
+		repo, ok := rh.repos.Get(tenantID, ref.GithubUser, ref.RepoName)
+		if !ok {
+			return nil, http.StatusNotFound, fmt.Errorf("Repository %s/%s has not been crawled", ref.GithubUser, ref.RepoName)
+		}
+		// Citations are told apart by file, so each bundle is attached once.
+		if other, ok := files[repo.FileID]; ok {
+			return nil, http.StatusBadRequest, fmt.Errorf("Corpora '%s' and '%s' are the same crawl", other, label)
+		}
+		files[repo.FileID] = label
+
+		corpora = append(corpora, corpusRepo{
+			Corpus: types.Corpus{
+				Label:      label,
+				Username:   repo.Username,
+				Reponame:   repo.Reponame,
+				FileID:     repo.FileID,
+				Root:       repo.RepoDir,
+				BundlePath: repo.BundlePath,
+			},
+			repo: repo,
+		})
+	}
+	return corpora, http.StatusOK, nil
+}
+
+// threadCorpora returns the crawls behind the corpora of an existing thread.
+// The corpora keep the uploads they were attached with, which may since
+// have been replaced by a new crawl.
+func (rh *RepoHandler) threadCorpora(tenantID string, attached []types.Corpus) ([]corpusRepo, error) {
+	corpora := make([]corpusRepo, 0, len(attached))
+	for _, c := range attached {
+		repo, ok := rh.repos.Get(tenantID, c.Username, c.Reponame)
+		if !ok {
+			return nil, fmt.Errorf("repository %s/%s is no longer crawled", c.Username, c.Reponame)
+		}
+		corpora = append(corpora, corpusRepo{Corpus: c, repo: repo})
+	}
+	return corpora, nil
+}
+
+func (rh *RepoHandler) createCorporaThread(ws workspace, corpora []corpusRepo, persona string) (types.ThreadID, error) {
+	attached := make([]types.Corpus, len(corpora))
+	for i, c := range corpora {
+		attached[i] = c.Corpus
+	}
+	threadID, err := assistant.CreateCorporaThread(ws.client, attached)
+	if err != nil {
+		return "", err
+	}
+
+	err = rh.threads.Bind(types.ThreadBinding{
+		ThreadID:  threadID,
+		TenantID:  ws.tenant.ID,
+		Username:  attached[0].Username,
+		Reponame:  attached[0].Reponame,
+		FileID:    attached[0].FileID,
+		CreatedAt: time.Now().Unix(),
+		Persona:   persona,
+		Corpora:   attached,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not bind thread to its corpora: %v", err)
+	}
+	return threadID, nil
+}
+
+// corporaInstructions returns the instruction files of every corpus.
+func corporaInstructions(corpora []corpusRepo) []string {
+	var files []string
+	for _, c := range corpora {
+		files = append(files, c.repo.InstructionFiles...)
+	}
+	return files
+}
+
+// corporaCitations resolves each citation against the bundle of the corpus
+// whose file it quotes and labels it with that corpus. Citations of a
+// corpus crawled again since the thread started keep their file ID only.
+func corporaCitations(corpora []corpusRepo, annotations []types.Annotation) []types.Citation {
+	byFile := make(map[string][]types.Annotation)
+	for _, ann := range annotations {
+		byFile[ann.FileID] = append(byFile[ann.FileID], ann)
+	}
+
+	citations := []types.Citation{}
+	for _, c := range corpora {
+		// The bundle on disk is rewritten by every crawl; once it no longer
+		// holds the upload the thread was created with, its lines would
+		// point into a different version.
+		bundlePath := c.BundlePath
+		if bundlePath == "" {
+			// Bound before bundle paths were recorded.
+			bundlePath = c.repo.BundlePath
+		}
+		if c.FileID != c.repo.FileID {
+			bundlePath = ""
+		}
+		for _, citation := range utils.ResolveCitations(bundlePath, c.Root, byFile[c.FileID]) {
+			citation.Corpus = c.Label
+			citations = append(citations, citation)
+		}
+		delete(byFile, c.FileID)
+	}
+	// Files outside the corpora, such as uploads to the thread, keep their
+	// file ID only.
+	for _, anns := range byFile {
+		citations = append(citations, utils.ResolveCitations("", "", anns)...)
+	}
+	return citations
+}
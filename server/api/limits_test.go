@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gastrader/repotalk/limits"
+	"github.com/gastrader/repotalk/types"
+)
+
+func TestCheckLimitsChargesNeitherWhenOneRefuses(t *testing.T) {
+	dir := t.TempDir()
+	clientLimit, err := limits.NewLimiter(filepath.Join(dir, "clients.json"), limits.Limits{RequestsPerMinute: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoLimit, err := limits.NewLimiter(filepath.Join(dir, "repos.json"), limits.Limits{RequestsPerMinute: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh := &RepoHandler{clientLimit: clientLimit, repoLimit: repoLimit}
+	ws := workspace{tenant: types.Tenant{ID: "t1"}, key: types.APIKey{ID: "k1"}}
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/query", nil)
+
+	codes := []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests}
+	for i, want := range codes {
+		w := httptest.NewRecorder()
+		if rh.checkLimits(w, r, ws, "acme", "app") {
+			w.WriteHeader(http.StatusOK)
+		}
+		if w.Code != want {
+			t.Fatalf("request %d: status = %d, want %d", i, w.Code, want)
+		}
+		if want == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("request %d: no Retry-After header", i)
+		}
+	}
+
+	// Only the request let through counts against the client, so it can
+	// still query other repositories.
+	status := clientLimit.Check(clientKey(ws, r), time.Now())
+	if status.RemainingRequests != 4 {
+		t.Errorf("client has %d requests left, want 4", status.RemainingRequests)
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gastrader/repotalk/config"
+)
+
+func TestCORS(t *testing.T) {
+	listed := config.CORS{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		MaxAge:         600,
+	}
+	anyOrigin := config.CORS{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}}
+	anyWithCredentials := config.CORS{AllowedOrigins: []string{"*"}, AllowCredentials: true}
+
+	tests := []struct {
+		name            string
+		cfg             config.CORS
+		method          string
+		origin          string
+		preflight       bool
+		wantCode        int
+		wantAllowOrigin string
+		wantCredentials string
+		wantMaxAge      string
+	}{
+		{"no origin", listed, http.MethodGet, "", false, http.StatusOK, "", "", ""},
+		{"allowed origin", listed, http.MethodGet, "https://app.example.com", false, http.StatusOK, "https://app.example.com", "", ""},
+		{"origin case-insensitive", listed, http.MethodGet, "https://APP.example.com", false, http.StatusOK, "https://APP.example.com", "", ""},
+		{"disallowed origin", listed, http.MethodGet, "https://evil.example.com", false, http.StatusOK, "", "", ""},
+		{"allowed preflight passed on", listed, http.MethodOptions, "https://app.example.com", true, http.StatusOK, "https://app.example.com", "", "600"},
+		{"disallowed preflight", listed, http.MethodOptions, "https://evil.example.com", true, http.StatusForbidden, "", "", ""},
+		{"plain OPTIONS is not a preflight", listed, http.MethodOptions, "https://app.example.com", false, http.StatusOK, "https://app.example.com", "", ""},
+		{"wildcard", anyOrigin, http.MethodGet, "https://other.example.com", false, http.StatusOK, "*", "", ""},
+		{"wildcard with credentials echoes origin", anyWithCredentials, http.MethodGet, "https://other.example.com", false, http.StatusOK, "https://other.example.com", "true", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			r := httptest.NewRequest(tt.method, "/api/v1/repos", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			w := httptest.NewRecorder()
+			CORS(tt.cfg, next).ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			h := w.Header()
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.wantAllowOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantAllowOrigin)
+			}
+			if got := h.Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Errorf("Allow-Credentials = %q, want %q", got, tt.wantCredentials)
+			}
+			if got := h.Get("Access-Control-Max-Age"); got != tt.wantMaxAge {
+				t.Errorf("Max-Age = %q, want %q", got, tt.wantMaxAge)
+			}
+			if tt.origin != "" && h.Get("Vary") != "Origin" {
+				t.Errorf("Vary = %q, want Origin first", h.Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestCORSPreflightHeaders(t *testing.T) {
+	cfg := config.CORS{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{"GET", "POST", "DELETE"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+	}
+	route := AllowMethods(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("preflight reached the route handler")
+	}, http.MethodPost)
+	r := httptest.NewRequest(http.MethodOptions, "/api/v1/query", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	w := httptest.NewRecorder()
+	CORS(cfg, route).ServeHTTP(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Allow-Origin = %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != "POST, OPTIONS" {
+		t.Errorf("Allow-Methods = %q, want the route's", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type, Authorization" {
+		t.Errorf("Allow-Headers = %q", got)
+	}
+}
+
+func TestAllowMethods(t *testing.T) {
+	h := AllowMethods(func(w http.ResponseWriter, r *http.Request) {}, http.MethodGet, http.MethodPost)
+	for method, want := range map[string]int{
+		http.MethodGet:    http.StatusOK,
+		http.MethodPost:   http.StatusOK,
+		http.MethodDelete: http.StatusMethodNotAllowed,
+	} {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(method, "/", nil))
+		if w.Code != want {
+			t.Errorf("%s: status = %d, want %d", method, w.Code, want)
+		}
+		if want == http.StatusMethodNotAllowed && w.Header().Get("Allow") != "GET, POST" {
+			t.Errorf("%s: Allow = %q", method, w.Header().Get("Allow"))
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gastrader/repotalk/assistant"
+	"github.com/gastrader/repotalk/overview"
+	"github.com/gastrader/repotalk/types"
+)
+
+// repoOverview returns the repository's overview for the crawled content,
+// reusing the one stored for the same commit and paths (or bundle) and
+// otherwise generating it in a thread of its own with fileID attached, so
+// the prompt stays out of the threads users ask in. A reply that cannot be
+// parsed is kept as the purpose but not stored, so the next crawl tries
+// again. r is nil for refreshes, which no client is charged for.
+func (rh *RepoHandler) repoOverview(ws workspace, r *http.Request, username, reponame, bundlePath, commit string, paths []string, fileID string, opts assistant.RunOptions) (types.RepoOverview, bool, error) {
+	key, err := overview.Key(commit, paths, bundlePath)
+	if err != nil {
+		return types.RepoOverview{}, false, err
+	}
+	if ov, ok := rh.overviews.Get(ws.tenant.ID, username, reponame, key); ok {
+		fmt.Printf("Reusing overview of %s/%s at %s\n", username, reponame, key)
+		return ov, true, nil
+	}
+
+	threadID, err := assistant.CreateRepoThread(ws.client, username, reponame, fileID)
+	if err != nil {
+		return types.RepoOverview{}, false, err
+	}
+	defer func() {
+		if err := assistant.DeleteThread(ws.client, threadID); err != nil {
+			log.Printf("Warning: Failed to delete overview thread of %s/%s: %v\n", username, reponame, err)
+		}
+	}()
+
+	res, err := assistant.RunThreadMsg(ws.client, ws.assistantID, threadID, overview.Prompt, opts)
+	if err != nil {
+		rh.recordFailedUsage(ws, r, username, reponame, res.Usage)
+		return types.RepoOverview{}, false, err
+	}
+	usage := rh.recordUsage(ws, r, username, reponame, res.Usage)
+
+	ov, parseErr := overview.Parse(res.Text)
+	if parseErr != nil {
+		log.Printf("Warning: Failed to parse overview of %s/%s: %v\n", username, reponame, parseErr)
+		ov = types.RepoOverview{Purpose: res.Text}
+	}
+	if ov.Languages, err = overview.Languages(bundlePath); err != nil {
+		log.Printf("Warning: Failed to count languages of %s/%s: %v\n", username, reponame, err)
+	}
+	ov.Commit = commit
+	ov.Key = key
+	ov.GeneratedAt = time.Now().Unix()
+	ov.Usage = usage
+
+	if parseErr == nil {
+		if err := rh.overviews.Put(ws.tenant.ID, username, reponame, ov); err != nil {
+			log.Printf("Warning: Failed to save overview of %s/%s: %v\n", username, reponame, err)
+		}
+	}
+	return ov, false, nil
+}
+
+func (rh *RepoHandler) overviewHandler(w http.ResponseWriter, repo types.RepoRecord) {
+	key, err := overview.Key(repo.Commit, repo.Paths, repo.BundlePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading bundle: %v", err), http.StatusInternalServerError)
+		return
+	}
+	ov, ok := rh.overviews.Get(repo.TenantID, repo.Username, repo.Reponame, key)
+	if !ok {
+		http.Error(w, "No overview has been generated yet; crawl the repository again", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(ov); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
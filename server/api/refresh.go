@@ -0,0 +1,245 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gastrader/repotalk/assistant"
+	"github.com/gastrader/repotalk/history"
+	"github.com/gastrader/repotalk/redact"
+	"github.com/gastrader/repotalk/sources"
+	"github.com/gastrader/repotalk/symbols"
+	"github.com/gastrader/repotalk/types"
+)
+
+// refreshJob re-crawls one repository at the head of a pushed branch.
+type refreshJob struct {
+	tenantID string
+	username string
+	reponame string
+	branch   string
+	commit   string
+}
+
+func (job refreshJob) key() string {
+	return fmt.Sprintf("%s:%s/%s", job.tenantID, job.username, job.reponame)
+}
+
+// refreshQueue runs refreshes one at a time. A repository is queued at most
+// once; pushes that arrive while it waits are folded into that refresh.
+type refreshQueue struct {
+	mu      sync.Mutex
+	pending map[string]bool
+	jobs    chan refreshJob
+}
+
+func newRefreshQueue() *refreshQueue {
+	return &refreshQueue{
+		pending: make(map[string]bool),
+		jobs:    make(chan refreshJob, 256),
+	}
+}
+
+// enqueue reports whether job was queued; it is not if the repository is
+// already waiting or the queue is full.
+func (q *refreshQueue) enqueue(job refreshJob) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.pending[job.key()] {
+		return false
+	}
+	select {
+	case q.jobs <- job:
+		q.pending[job.key()] = true
+		return true
+	default:
+		log.Printf("Warning: Refresh queue is full, dropping %s\n", job.key())
+		return false
+	}
+}
+
+func (q *refreshQueue) done(job refreshJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.pending, job.key())
+}
+
+// repoLocks serializes crawls and refreshes of each repository, which share
+// its checkout directory, bundle and record.
+type repoLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newRepoLocks() *repoLocks {
+	return &repoLocks{locks: make(map[string]*sync.Mutex)}
+}
+
+// lock blocks until no other crawl or refresh of the repository is running
+// and returns the function that releases it.
+func (l *repoLocks) lock(tenantID, username, reponame string) func() {
+	key := fmt.Sprintf("%s:%s/%s", tenantID, username, reponame)
+	l.mu.Lock()
+	m, ok := l.locks[key]
+	if !ok {
+		m = &sync.Mutex{}
+		l.locks[key] = m
+	}
+	l.mu.Unlock()
+
+	m.Lock()
+	return m.Unlock
+}
+
+func (rh *RepoHandler) runRefreshes() {
+	for job := range rh.refreshes.jobs {
+		// Cleared before the refresh starts so a push during it queues another.
+		rh.refreshes.done(job)
+		if err := rh.refresh(job); err != nil {
+			log.Printf("Warning: Failed to refresh %s: %v\n", job.key(), err)
+		}
+	}
+}
+
+// refresh re-bundles a repository, uploads the new bundle if it changed and
+// regenerates its overview. The bundle is built beside the current one and
+// only moved over it once uploaded, so a refresh that fails, including one
+// blocked by redaction, leaves the record and the files it points at as
+// they were. The replaced upload is deleted, so existing threads lose their
+// files and new threads use the new bundle.
+func (rh *RepoHandler) refresh(job refreshJob) error {
+	defer rh.locks.lock(job.tenantID, job.username, job.reponame)()
+
+	rec, ok := rh.repos.Get(job.tenantID, job.username, job.reponame)
+	if !ok {
+		return fmt.Errorf("repository is no longer crawled")
+	}
+	if job.commit != "" && rec.Commit == job.commit {
+		fmt.Printf("%s is already at %s\n", job.key(), job.commit)
+		return nil
+	}
+
+	tenant, ok := rh.tenants.Get(job.tenantID)
+	if !ok {
+		return fmt.Errorf("tenant no longer exists")
+	}
+	ws, err := rh.workspaces.forTenant(tenant, types.APIKey{})
+	if err != nil {
+		return err
+	}
+
+	src, err := rh.sourceOf(rec, job.branch)
+	if err != nil {
+		return err
+	}
+
+	staged := stagedBundlePath(rec.BundlePath)
+	defer func() {
+		if err := os.RemoveAll(filepath.Dir(staged)); err != nil {
+			log.Printf("Warning: Failed to delete directory '%s': %v\n", filepath.Dir(staged), err)
+		}
+	}()
+	co, err := rh.bundleSource(src, ws.repoDir(rec.Username, rec.Reponame), staged)
+	if err != nil {
+		return err
+	}
+	fileID, hash, reused, err := uploadBundle(ws, staged, rec)
+	if err != nil {
+		return err
+	}
+	if err := promoteBundle(staged, rec.BundlePath); err != nil {
+		if !reused {
+			deleteUploads(ws, fileID)
+		}
+		return fmt.Errorf("Error replacing bundle: %v", err)
+	}
+
+	rec.InstructionFiles = instructionFiles(rec.BundlePath)
+	for _, file := range rec.InstructionFiles {
+		if _, err := rh.versions.Track(file, nil); err != nil {
+			log.Printf("Warning: Failed to track instructions '%s': %v\n", file, err)
+		}
+	}
+
+	rec.RepoDir = co.dir
+	rec.Branch = co.branch
+	rec.Commit = co.commit
+	rec.License = co.license
+	rec.FileID = fileID
+	rec.BundleHash = hash
+	if !reused {
+		rec.Scopes = nil
+	}
+	rec.CrawledAt = time.Now().Unix()
+	replaced, ok, err := rh.repos.Replace(rec)
+	if err != nil {
+		return err
+	}
+	if ok {
+		deleteReplaced(ws, replaced, rec)
+	}
+	fmt.Printf("Refreshed %s at %s %s\n", job.key(), co.branch, co.commit)
+
+	additional, _ := rh.layerInstructions(rec.InstructionFiles, "")
+	if _, _, err := rh.repoOverview(ws, nil, rec.Username, rec.Reponame, rec.BundlePath, rec.Commit, rec.Paths, rec.FileID, assistant.RunOptions{
+		Model:                  rec.Model,
+		AdditionalInstructions: additional,
+	}); err != nil {
+		return fmt.Errorf("Error generating overview: %v", err)
+	}
+	return nil
+}
+
+// stagedBundlePath is where a refresh builds the bundle that replaces the
+// one at bundlePath.
+func stagedBundlePath(bundlePath string) string {
+	return filepath.Join(filepath.Dir(bundlePath), ".refresh", filepath.Base(bundlePath))
+}
+
+// promoteBundle moves a staged bundle and the files built with it over the
+// bundle at bundlePath, removing those the staged bundle has no counterpart
+// of. The bundle itself moves last, so a failure leaves it as it was.
+func promoteBundle(staged, bundlePath string) error {
+	for _, path := range []func(string) string{symbols.Path, symbols.ReferencesPath, history.Path, redact.ReportPath, repoInstructionsPath} {
+		from, to := path(staged), path(bundlePath)
+		err := os.Rename(from, to)
+		if os.IsNotExist(err) {
+			err = os.Remove(to)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(staged, bundlePath)
+}
+
+// sourceOf returns the source a repository was crawled from, at branch and
+// limited to the paths it was crawled with. URLs the policy no longer
+// allows return a *policy.Violation.
+// Uploads are not kept and cannot be fetched again.
+func (rh *RepoHandler) sourceOf(rec types.RepoRecord, branch string) (crawlSource, error) {
+	switch {
+	case strings.HasPrefix(rec.URL, "file://"):
+		if err := rh.cfg.Policy.CheckUnhosted(); err != nil {
+			return crawlSource{}, err
+		}
+		local, err := sources.ResolveLocal(rh.cfg.Sources.LocalRoots, strings.TrimPrefix(rec.URL, "file://"))
+		if err != nil {
+			return crawlSource{}, err
+		}
+		return crawlSource{url: rec.URL, local: local, paths: rec.Paths}, nil
+	case sources.CheckRemote(rec.URL) == nil:
+		if err := rh.cfg.Policy.CheckOrigin(rec.URL); err != nil {
+			return crawlSource{}, err
+		}
+		return crawlSource{url: rec.URL, github: rec.URL, branch: branch, paths: rec.Paths}, nil
+	}
+	return crawlSource{}, fmt.Errorf("'%s' cannot be fetched again", rec.URL)
+}
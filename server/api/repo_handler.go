@@ -2,137 +2,267 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gastrader/repotalk/assistant"
+	"github.com/gastrader/repotalk/billing"
+	"github.com/gastrader/repotalk/config"
+	"github.com/gastrader/repotalk/graph"
+	"github.com/gastrader/repotalk/history"
+	"github.com/gastrader/repotalk/instructions"
+	"github.com/gastrader/repotalk/limits"
+	"github.com/gastrader/repotalk/overview"
+	"github.com/gastrader/repotalk/policy"
+	"github.com/gastrader/repotalk/redact"
+	"github.com/gastrader/repotalk/sources"
+	"github.com/gastrader/repotalk/store"
+	"github.com/gastrader/repotalk/symbols"
 	"github.com/gastrader/repotalk/types"
 	"github.com/gastrader/repotalk/utils"
 	"github.com/sashabaranov/go-openai"
 )
 
 type RepoHandler struct {
-	oaiClient   *openai.Client
-	assistantID types.AsstID
+	cfg         config.Config
+	workspaces  *workspaces
+	repos       *store.RepoStore
+	tenants     *store.TenantStore
+	overviews   *store.OverviewStore
+	threads     *store.ThreadStore
+	usage       *store.UsageStore
+	queryLog    *store.QueryLog
+	versions    *instructions.Registry
+	prices      billing.PriceTable
+	clientLimit *limits.Limiter
+	repoLimit   *limits.Limiter
+	refreshes   *refreshQueue
+	locks       *repoLocks
+	// clone fetches a remote repository into a directory, at a branch if
+	// one is given.
+	clone func(url, branch, dir string) error
 }
 
-func NewRepoHandler(oai *openai.Client, as types.AsstID) *RepoHandler {
-	return &RepoHandler{
-		oaiClient:   oai,
-		assistantID: as,
+type Stores struct {
+	Repos        *store.RepoStore
+	Tenants      *store.TenantStore
+	Overviews    *store.OverviewStore
+	Threads      *store.ThreadStore
+	Usage        *store.UsageStore
+	QueryLog     *store.QueryLog
+	Instructions *instructions.Registry
+}
+
+// NewRepoHandler serves the repository API. Each tenant gets its own
+// assistant, created from cfg and instructions on first use, on its own
+// OpenAI key if it has one and on oai otherwise.
+func NewRepoHandler(oai *openai.Client, cfg config.Config, instructions string, stores Stores, prices billing.PriceTable, clientLimit, repoLimit *limits.Limiter) *RepoHandler {
+	rh := &RepoHandler{
+		cfg:         cfg,
+		workspaces:  newWorkspaces(oai, cfg, instructions),
+		repos:       stores.Repos,
+		tenants:     stores.Tenants,
+		overviews:   stores.Overviews,
+		threads:     stores.Threads,
+		usage:       stores.Usage,
+		queryLog:    stores.QueryLog,
+		versions:    stores.Instructions,
+		prices:      prices,
+		clientLimit: clientLimit,
+		repoLimit:   repoLimit,
+		refreshes:   newRefreshQueue(),
+		locks:       newRepoLocks(),
+		clone:       cloneGitHubRepo,
 	}
+	go rh.runRefreshes()
+	return rh
 }
 
 func (rh *RepoHandler) CrawlHandler(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
-
-	if r.Method == http.MethodOptions {
+	req, src, status, err := rh.decodeCrawl(w, r)
+	if src.upload != "" {
+		defer os.Remove(src.upload)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), status)
 		return
 	}
 
-	var req types.CrawlRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	username, reponame, err := src.repoName(req.Name)
 	if err != nil {
-		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if req.GithubURL == "" {
-		http.Error(w, "GitHub URL is required", http.StatusBadRequest)
+	if src.paths, err = utils.CleanScope(req.Paths); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid paths: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	username, reponame, err := parseGitHubURL(req.GithubURL)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid GitHub URL: %v", err), http.StatusBadRequest)
+	// Checked before anything is cloned. Local paths and uploads have no
+	// origin and are only admitted past an allow-list with allowUploads.
+	originErr := rh.cfg.Policy.CheckUnhosted()
+	if src.github != "" {
+		originErr = rh.cfg.Policy.CheckOrigin(src.github)
+	}
+	if originErr != nil {
+		writePolicyError(w, originErr)
 		return
 	}
 
-	repoDir := fmt.Sprintf("./repos/%s/%s", username, reponame)
-	parentRepoDir := fmt.Sprintf("./repos/%s", username)
-	bundleDir := fmt.Sprintf("./bundles/%s/%s/bundle.txt", username, reponame)
+	if req.Model != "" && !rh.cfg.ModelAllowed(req.Model) {
+		http.Error(w, fmt.Sprintf("Model '%s' is not allowed", req.Model), http.StatusBadRequest)
+		return
+	}
 
-	if _, err := os.Stat(bundleDir); err == nil {
-		fmt.Println("Bundled file already exists. Skipping git clone and bundling.")
-	} else if os.IsNotExist(err) {
+	if _, ok := rh.cfg.Persona(req.Persona); req.Persona != "" && !ok {
+		http.Error(w, fmt.Sprintf("Unknown persona '%s'", req.Persona), http.StatusBadRequest)
+		return
+	}
 
-		err = os.MkdirAll(repoDir, os.ModePerm)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Error creating directory: %v", err), http.StatusInternalServerError)
-			return
-		}
+	ws, err := rh.workspaces.resolve(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading workspace: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-		err = cloneGitHubRepo(req.GithubURL, repoDir)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Error cloning repository: %v", err), http.StatusInternalServerError)
-			return
-		}
+	if !rh.checkLimits(w, r, ws, username, reponame) {
+		return
+	}
 
-		_, err = utils.EnsureDir(filepath.Dir(bundleDir))
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Error checking directory: %v", err), http.StatusInternalServerError)
-			return
-		}
+	// Held for the rest of the crawl; a refresh of the same repository would
+	// clear the checkout and rewrite the bundle under it.
+	defer rh.locks.lock(ws.tenant.ID, username, reponame)()
 
-		files, err := utils.ListFiles(repoDir)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Error listing directory: %v", err), http.StatusInternalServerError)
-			return
-		}
+	repoDir := ws.repoDir(username, reponame)
+	bundleDir := ws.bundlePath(username, reponame)
+	prev, _ := rh.repos.Get(ws.tenant.ID, username, reponame)
+	branch, commit, license := prev.Branch, prev.Commit, prev.License
 
-		if len(files) == 0 {
-			http.Error(w, fmt.Sprintf("No valid files: %v", err), http.StatusInternalServerError)
+	sameScope := strings.Join(prev.Paths, "\n") == strings.Join(src.paths, "\n")
+	var redactions types.RedactionReport
+	if _, err := os.Stat(bundleDir); err == nil && src.github != "" && (src.branch == "" || src.branch == branch) && sameScope && rh.redactedUnder(bundleDir) && !rh.cfg.Policy.ChecksCheckout() {
+		fmt.Println("Bundled file already exists. Skipping git clone and bundling.")
+		redactions, _ = redact.Load(bundleDir)
+		redactions.Policy = rh.cfg.Redaction.Policy
+	} else if err == nil || os.IsNotExist(err) {
+		co, err := rh.bundleSource(src, repoDir, bundleDir)
+		var blocked *redact.BlockedError
+		if errors.As(err, &blocked) {
+			writeJSON(w, http.StatusUnprocessableEntity, blocked.Report)
 			return
 		}
-
-		err = utils.BundleToFile(files, bundleDir)
-		if err != nil {
-			log.Fatalf("Failed to bundle files: %v\n", err)
+		if writePolicyError(w, err) {
+			return
 		}
-
-		err = os.RemoveAll(parentRepoDir)
 		if err != nil {
-			log.Printf("Warning: Failed to delete directory '%s': %v\n", repoDir, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		// Bundle headers carry paths under the directory that was read, which
+		// for local sources is the source itself.
+		repoDir, branch, commit, license = co.dir, co.branch, co.commit, co.license
+		redactions = co.redactions
 	} else {
 		// Handle other errors (e.g., permission issues)
 		http.Error(w, fmt.Sprintf("Error checking for bundled file: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	fileID, _, err := assistant.UploadFileByName(rh.oaiClient, string(rh.assistantID), bundleDir, false)
+	fileID, hash, reused, err := uploadBundle(ws, bundleDir, prev)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error uploading file: %v", err), http.StatusInternalServerError)
 		return
 	}
+	// Parts of an unchanged bundle uploaded for scoped threads stay valid.
+	var scopes map[string]string
+	if reused {
+		scopes = prev.Scopes
+	}
+
+	instructionFiles, err := saveCrawlInstructions(bundleDir, req.Instructions)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error saving instructions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for _, file := range instructionFiles {
+		if _, err := rh.versions.Track(file, nil); err != nil {
+			log.Printf("Warning: Failed to track instructions '%s': %v\n", file, err)
+		}
+	}
+
+	rec := types.RepoRecord{
+		TenantID:   ws.tenant.ID,
+		Username:   username,
+		Reponame:   reponame,
+		URL:        src.url,
+		RepoDir:    repoDir,
+		BundlePath: bundleDir,
+		FileID:     fileID,
+		Model:      req.Model,
+		Branch:     branch,
+		Commit:     commit,
+		CrawledAt:  time.Now().Unix(),
+		Paths:      src.paths,
+		Scopes:     scopes,
+		License:    license,
+		BundleHash: hash,
 
-	threadID, err := assistant.CreateThread(rh.oaiClient)
+		InstructionFiles: instructionFiles,
+	}
+	replaced, ok, err := rh.repos.Replace(rec)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error saving repository: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if ok {
+		deleteReplaced(ws, replaced, rec)
+	}
+
+	threadID, err := rh.createRepoThread(ws, username, reponame, fileID, req.Persona, nil)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error creating thread: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	message := fmt.Sprintf("Uploaded file '%s'. Please analyze its contents.", filepath.Base(bundleDir))
-	res, err := assistant.RunThreadMsg(rh.oaiClient, rh.assistantID, threadID, message)
+	additional, version := rh.layerInstructions(instructionFiles, req.Persona)
+	ov, cached, err := rh.repoOverview(ws, r, username, reponame, bundleDir, commit, src.paths, fileID, assistant.RunOptions{
+		Model:                  req.Model,
+		AdditionalInstructions: additional,
+	})
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error starting thread: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Error generating overview: %v", err), http.StatusInternalServerError)
 		return
 	}
-	fmt.Println("the res is:", res)
 
 	response := types.CrawlResponse{
 		Message:  "Crawl initiated successfully",
-		URL:      req.GithubURL,
+		URL:      src.url,
 		Username: username,
 		Reponame: reponame,
-		Response: res,
+		Response: overview.Render(ov),
 		ThreadID: string(threadID),
 		FileID:   fileID,
+		Model:    ov.Usage.Model,
+
+		Instructions:   version,
+		Overview:       &ov,
+		OverviewCached: cached,
+		Redactions:     &redactions,
+		License:        license,
+	}
+	if !cached {
+		response.Usage = ov.Usage
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -143,15 +273,350 @@ func (rh *RepoHandler) CrawlHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func enableCORS(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// crawlSource is where a crawl's files come from: exactly one of github,
+// local and upload is set.
+type crawlSource struct {
+	// url is recorded as the repository's URL.
+	url    string
+	github string
+	// branch is cloned instead of the default branch when set.
+	branch string
+	// local is a resolved path under one of the allowed roots.
+	local string
+	// upload is a temporary copy of an uploaded archive or git bundle, and
+	// uploadName its original file name.
+	upload     string
+	uploadName string
+	// paths limits the crawl to these directories of a monorepo.
+	paths []string
+}
+
+// decodeCrawl reads a crawl request from a JSON body or, for archive and git
+// bundle uploads, a multipart form with an "archive" file.
+func (rh *RepoHandler) decodeCrawl(w http.ResponseWriter, r *http.Request) (types.CrawlRequest, crawlSource, int, error) {
+	var req types.CrawlRequest
+	var src crawlSource
+
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, src, http.StatusBadRequest, fmt.Errorf("Invalid JSON payload")
+		}
+		switch {
+		case req.GithubURL != "" && req.LocalPath != "":
+			return req, src, http.StatusBadRequest, fmt.Errorf("Only one of GitHub URL and local path may be given")
+		case req.GithubURL != "":
+			if err := sources.CheckRemote(req.GithubURL); err != nil {
+				return req, src, http.StatusBadRequest, fmt.Errorf("Invalid GitHub URL: %v", err)
+			}
+			src.github = req.GithubURL
+			src.url = req.GithubURL
+			src.branch = req.Branch
+		case req.LocalPath != "":
+			local, err := sources.ResolveLocal(rh.cfg.Sources.LocalRoots, req.LocalPath)
+			if err != nil {
+				return req, src, http.StatusForbidden, fmt.Errorf("Local path not allowed: %v", err)
+			}
+			src.local = local
+			src.url = "file://" + filepath.ToSlash(local)
+		default:
+			return req, src, http.StatusBadRequest, fmt.Errorf("GitHub URL is required")
+		}
+		return req, src, http.StatusOK, nil
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, rh.cfg.Sources.MaxUploadBytes)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return req, src, http.StatusBadRequest, fmt.Errorf("Invalid upload: %v", err)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	req.Name = r.FormValue("name")
+	req.Model = r.FormValue("model")
+	req.Instructions = r.FormValue("instructions")
+	req.Persona = r.FormValue("persona")
+	req.Paths = r.MultipartForm.Value["paths"]
+
+	file, header, err := r.FormFile("archive")
+	if err != nil {
+		return req, src, http.StatusBadRequest, fmt.Errorf("An archive file is required: %v", err)
+	}
+	defer file.Close()
+
+	if !sources.IsArchive(header.Filename) && !sources.IsGitBundle(header.Filename) {
+		return req, src, http.StatusBadRequest, fmt.Errorf("Unsupported upload '%s': expected .zip, .tar.gz or .bundle", header.Filename)
+	}
+
+	tmp, err := os.CreateTemp("", "repotalk-upload-*")
+	if err != nil {
+		return req, src, http.StatusInternalServerError, fmt.Errorf("Error storing upload: %v", err)
+	}
+	defer tmp.Close()
+	src.upload = tmp.Name()
+	src.uploadName = header.Filename
+	src.url = "upload://" + filepath.Base(header.Filename)
+
+	if _, err := io.Copy(tmp, file); err != nil {
+		return req, src, http.StatusInternalServerError, fmt.Errorf("Error storing upload: %v", err)
+	}
+	return req, src, http.StatusOK, nil
+}
+
+// repoName names the repository a crawl is stored under: name ("owner/repo")
+// when given, otherwise the GitHub URL or a name derived from the path or
+// file.
+func (src crawlSource) repoName(name string) (string, string, error) {
+	var username, reponame string
+	switch {
+	case name != "":
+		parts := strings.Split(name, "/")
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("Invalid name '%s': expected owner/repo", name)
+		}
+		username, reponame = parts[0], parts[1]
+	case src.github != "":
+		var err error
+		username, reponame, err = parseGitHubURL(src.github)
+		if err != nil {
+			return "", "", fmt.Errorf("Invalid GitHub URL: %v", err)
+		}
+	case src.local != "":
+		username, reponame = "local", sources.Stem(src.local)
+	default:
+		username, reponame = "upload", sources.Stem(src.uploadName)
+	}
+
+	for _, part := range []string{username, reponame} {
+		if !validNamePart(part) {
+			return "", "", fmt.Errorf("Invalid repository name '%s/%s'", username, reponame)
+		}
+	}
+	return username, reponame, nil
+}
+
+// validNamePart reports whether s is safe to use as a path segment.
+func validNamePart(s string) bool {
+	if s == "" || s == "." || s == ".." {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// checkout is the directory a crawl read its files from and, for git
+// sources, the branch and commit it was at.
+type checkout struct {
+	dir    string
+	branch string
+	commit string
+	// redactions reports the secrets replaced in the bundle.
+	redactions types.RedactionReport
+	// license is the license detected in the checkout.
+	license string
+}
+
+// bundleSource checks out src, checks it against the crawl policy, bundles
+// its files to bundlePath and keeps its REPOTALK.md. Checkouts under repoDir are removed afterwards; local
+// directories are read in place.
+func (rh *RepoHandler) bundleSource(src crawlSource, repoDir, bundlePath string) (checkout, error) {
+	srcDir := repoDir
+	extracted := false
+	if err := os.RemoveAll(repoDir); err != nil {
+		return checkout{}, fmt.Errorf("Error clearing directory: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(repoDir); err != nil {
+			log.Printf("Warning: Failed to delete directory '%s': %v\n", repoDir, err)
+		}
+		// Only succeeds once no other checkout of the owner is in progress.
+		os.Remove(filepath.Dir(repoDir))
+	}()
+
+	switch {
+	case src.github != "":
+		if err := os.MkdirAll(repoDir, os.ModePerm); err != nil {
+			return checkout{}, fmt.Errorf("Error creating directory: %v", err)
+		}
+		if err := rh.clone(src.github, src.branch, repoDir); err != nil {
+			return checkout{}, fmt.Errorf("Error cloning repository: %v", err)
+		}
+	case src.local != "":
+		info, err := os.Stat(src.local)
+		if err != nil {
+			return checkout{}, fmt.Errorf("Error reading local path: %v", err)
+		}
+		if info.IsDir() {
+			srcDir = src.local
+		} else if !sources.IsGitBundle(src.local) {
+			return checkout{}, fmt.Errorf("Local path must be a directory or a git bundle")
+		} else if err := sources.CloneBundle(src.local, repoDir); err != nil {
+			return checkout{}, err
+		}
+	case sources.IsGitBundle(src.uploadName):
+		if err := sources.CloneBundle(src.upload, repoDir); err != nil {
+			return checkout{}, err
+		}
+	default:
+		// Archives carry no git metadata; reading history from repoDir
+		// would find whatever repository the server runs in.
+		extracted = true
+		limits := sources.Limits{
+			MaxBytes: rh.cfg.Sources.MaxExtractedBytes,
+			MaxFiles: rh.cfg.Sources.MaxArchiveFiles,
+		}
+		if err := sources.Extract(src.upload, src.uploadName, repoDir, limits); err != nil {
+			return checkout{}, fmt.Errorf("Error extracting archive: %v", err)
+		}
+	}
+
+	if _, err := utils.EnsureDir(filepath.Dir(bundlePath)); err != nil {
+		return checkout{}, fmt.Errorf("Error checking directory: %v", err)
+	}
+
+	files, err := utils.ListFiles(srcDir)
+	if err != nil {
+		return checkout{}, fmt.Errorf("Error listing directory: %v", err)
+	}
+	files = utils.ScopeFiles(srcDir, files, src.paths)
+	if len(files) == 0 && len(src.paths) > 0 {
+		return checkout{}, fmt.Errorf("No valid files under %s", strings.Join(src.paths, ", "))
+	}
+	if len(files) == 0 {
+		return checkout{}, fmt.Errorf("No valid files")
+	}
+
+	license, err := rh.cfg.Policy.CheckCheckout(srcDir, files)
+	if err != nil {
+		return checkout{}, err
+	}
+
+	if err := utils.BundleToFile(files, bundlePath); err != nil {
+		return checkout{}, fmt.Errorf("Failed to bundle files: %v", err)
+	}
+
+	table := symbols.Extract(srcDir, files)
+	if err := symbols.Save(bundlePath, table); err != nil {
+		return checkout{}, fmt.Errorf("Error saving symbols: %v", err)
+	}
+	if err := symbols.SaveReferences(bundlePath, symbols.References(srcDir, files, table)); err != nil {
+		return checkout{}, fmt.Errorf("Error saving references: %v", err)
+	}
+	if err := utils.AppendBundleSection(bundlePath, symbols.IndexName, symbols.Index(table)); err != nil {
+		return checkout{}, fmt.Errorf("Error indexing symbols: %v", err)
+	}
+	if err := utils.AppendBundleSection(bundlePath, graph.SummaryName, graph.Summary(graph.Build(table))); err != nil {
+		return checkout{}, fmt.Errorf("Error summarizing imports: %v", err)
+	}
+
+	if err := rh.saveHistory(srcDir, files, src.paths, bundlePath, !extracted); err != nil {
+		return checkout{}, fmt.Errorf("Error saving history: %v", err)
+	}
+
+	// Last, so generated sections are covered too.
+	report, err := rh.redactBundle(srcDir, bundlePath)
+	if err != nil {
+		return checkout{}, err
+	}
+
+	if err := saveRepoInstructions(srcDir, bundlePath); err != nil {
+		return checkout{}, fmt.Errorf("Error saving repository instructions: %v", err)
+	}
+
+	co := checkout{dir: srcDir, redactions: report, license: license}
+	if !extracted {
+		// git would walk up from an extracted archive to the enclosing
+		// repository and report its HEAD.
+		co.branch, co.commit = gitHead(srcDir)
+	}
+	return co, nil
+}
+
+// redactBundle replaces the secrets in a bundle according to the redaction
+// policy and keeps the report next to it. Under the strict policy a bundle
+// with secrets is deleted and a *redact.BlockedError returned.
+func (rh *RepoHandler) redactBundle(srcDir, bundlePath string) (types.RedactionReport, error) {
+	policy := rh.cfg.Redaction.Policy
+	if policy == redact.PolicyOff {
+		return types.RedactionReport{Policy: policy}, redact.Remove(bundlePath)
+	}
+
+	report, err := redact.Bundle(bundlePath, srcDir)
+	if err != nil {
+		return report, fmt.Errorf("Error redacting bundle: %v", err)
+	}
+	report.Policy = policy
+	if report.Redacted > 0 {
+		fmt.Printf("Redacted %s from %s\n", redact.Summary(report), srcDir)
+	}
+
+	if policy == redact.PolicyStrict && report.Redacted > 0 {
+		report.Blocked = true
+		if err := os.Remove(bundlePath); err != nil {
+			log.Printf("Warning: Failed to delete bundle '%s': %v\n", bundlePath, err)
+		}
+		if err := redact.Remove(bundlePath); err != nil {
+			log.Printf("Warning: Failed to delete redaction report: %v\n", err)
+		}
+		return report, &redact.BlockedError{Report: report}
+	}
+	if err := redact.Save(bundlePath, report); err != nil {
+		return report, fmt.Errorf("Error saving redaction report: %v", err)
+	}
+	return report, nil
+}
+
+// writePolicyError responds 403 with the violated rule if err is a
+// *policy.Violation, and reports whether it did.
+func writePolicyError(w http.ResponseWriter, err error) bool {
+	var violation *policy.Violation
+	if !errors.As(err, &violation) {
+		return false
+	}
+	writeJSON(w, http.StatusForbidden, violation)
+	return true
+}
+
+// redactedUnder reports whether an existing bundle was made under the
+// current redaction policy and can be reused as it is.
+func (rh *RepoHandler) redactedUnder(bundlePath string) bool {
+	if rh.cfg.Redaction.Policy == redact.PolicyOff {
+		return true
+	}
+	report, err := redact.Load(bundlePath)
+	return err == nil && report.Policy == rh.cfg.Redaction.Policy
+}
+
+// saveHistory extracts the checkout's git history before it is deleted,
+// keeps it next to the bundle for scoped questions and appends it to the
+// bundle. Sources without history, or crawls with history disabled, drop
+// any history kept from an earlier crawl.
+func (rh *RepoHandler) saveHistory(srcDir string, files, paths []string, bundlePath string, isGit bool) error {
+	if !isGit || rh.cfg.History.Commits == 0 {
+		return history.Remove(bundlePath)
+	}
+	hist, err := history.Collect(srcDir, files, history.Options{
+		Commits: rh.cfg.History.Commits,
+		Blame:   rh.cfg.History.Blame,
+		Paths:   paths,
+	})
+	if err != nil {
+		log.Printf("Warning: No git history for '%s': %v\n", srcDir, err)
+		return history.Remove(bundlePath)
+	}
+
+	if err := history.Save(bundlePath, hist); err != nil {
+		return err
+	}
+	return utils.AppendBundleSection(bundlePath, history.SectionName, history.Render(hist))
 }
 
 func parseGitHubURL(githubURL string) (string, string, error) {
-	// Example URL: https://github.com/username/reponame
-	parts := strings.Split(githubURL, "/")
+	// Example URL: https://github.com/username/reponame or
+	// git@github.com:username/reponame.git
+	parts := strings.FieldsFunc(strings.TrimSuffix(githubURL, ".git"), func(r rune) bool { return r == '/' || r == ':' })
 	if len(parts) < 2 {
 		return "", "", fmt.Errorf("invalid GitHub URL")
 	}
@@ -162,8 +627,15 @@ func parseGitHubURL(githubURL string) (string, string, error) {
 	return username, repoName, nil
 }
 
-func cloneGitHubRepo(githubURL, repoDir string) error {
-	cmd := exec.Command("git", "clone", githubURL, repoDir)
+// cloneGitHubRepo clones a remote accepted by sources.CheckRemote. Local
+// transports are disabled so a URL cannot reach repositories on the server.
+func cloneGitHubRepo(githubURL, branch, repoDir string) error {
+	args := []string{"-c", "protocol.file.allow=never", "clone"}
+	if branch != "" {
+		args = append(args, "--branch", branch)
+	}
+	args = append(args, "--", githubURL, repoDir)
+	cmd := exec.Command("git", args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	err := cmd.Run()
@@ -175,26 +647,104 @@ func cloneGitHubRepo(githubURL, repoDir string) error {
 }
 
 func (rh *RepoHandler) QueryHandler(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+	var req types.ThreadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
 
-	if r.Method == http.MethodOptions {
+	if len(req.Corpora) == 0 && (req.GithubUser == "" || req.RepoName == "") {
+		http.Error(w, "githubUser and repoName, or corpora, are required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Corpora) > 0 && req.ThreadID != "" {
+		http.Error(w, "Corpora can only be attached when a thread is started", http.StatusBadRequest)
 		return
 	}
 
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	paths, err := utils.CleanScope(req.Paths)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid paths: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(paths) > 0 && len(req.Corpora) > 0 {
+		http.Error(w, "Paths cannot be combined with corpora", http.StatusBadRequest)
 		return
 	}
 
-	var req types.ThreadRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+	if req.Model != "" && !rh.cfg.ModelAllowed(req.Model) {
+		http.Error(w, fmt.Sprintf("Model '%s' is not allowed", req.Model), http.StatusBadRequest)
 		return
 	}
 
+	if _, ok := rh.cfg.Persona(req.Persona); req.Persona != "" && !ok {
+		http.Error(w, fmt.Sprintf("Unknown persona '%s'", req.Persona), http.StatusBadRequest)
+		return
+	}
+
+	ws, err := rh.workspaces.resolve(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading workspace: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var corpora []corpusRepo
+	if len(req.Corpora) > 0 {
+		var status int
+		corpora, status, err = rh.resolveCorpora(ws.tenant.ID, req.Corpora)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		req.GithubUser, req.RepoName = corpora[0].Username, corpora[0].Reponame
+	}
+
+	repo, ok := rh.repos.Get(ws.tenant.ID, req.GithubUser, req.RepoName)
+	if !ok {
+		http.Error(w, "Repository has not been crawled", http.StatusNotFound)
+		return
+	}
+
+	if !rh.checkLimits(w, r, ws, repo.Username, repo.Reponame) {
+		return
+	}
+
+	var scope string
+	if req.Commits != nil {
+		hist, err := history.Load(repo.BundlePath)
+		if err != nil {
+			http.Error(w, "No git history for this repository; crawl it again", http.StatusNotFound)
+			return
+		}
+		commits, err := history.Range(hist, *req.Commits)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid commit range: %v", err), http.StatusBadRequest)
+			return
+		}
+		if len(commits) == 0 {
+			http.Error(w, "No crawled commits in this range", http.StatusBadRequest)
+			return
+		}
+		scope = history.Scope(*req.Commits, commits)
+	}
+
 	var threadID types.ThreadID
+	persona := req.Persona
 	if req.ThreadID == "" {
-		newThreadID, err := assistant.CreateThread(rh.oaiClient)
+		var newThreadID types.ThreadID
+		switch {
+		case len(corpora) > 0:
+			newThreadID, err = rh.createCorporaThread(ws, corpora, persona)
+		case len(paths) > 0:
+			fileID, status, scopeErr := rh.scopedFile(ws, repo, paths)
+			if scopeErr != nil {
+				http.Error(w, scopeErr.Error(), status)
+				return
+			}
+			newThreadID, err = rh.createRepoThread(ws, repo.Username, repo.Reponame, fileID, persona, paths)
+		default:
+			newThreadID, err = rh.createRepoThread(ws, repo.Username, repo.Reponame, repo.FileID, persona, nil)
+		}
 		fmt.Println("creating new thread", newThreadID)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Error creating thread: %v", err), http.StatusInternalServerError)
@@ -202,21 +752,109 @@ func (rh *RepoHandler) QueryHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		threadID = newThreadID
 	} else {
-		threadID = types.ThreadID(req.ThreadID)
+		binding, ok := rh.threads.Get(types.ThreadID(req.ThreadID))
+		if !ok || binding.TenantID != ws.tenant.ID {
+			http.Error(w, "Thread not found", http.StatusNotFound)
+			return
+		}
+		if !store.BoundTo(binding, ws.tenant.ID, req.GithubUser, req.RepoName) {
+			http.Error(w, "Thread does not belong to this repository", http.StatusForbidden)
+			return
+		}
+		threadID = binding.ThreadID
+
+		if len(paths) > 0 && strings.Join(paths, ",") != strings.Join(binding.Paths, ",") {
+			http.Error(w, "A thread's paths are set when it is started", http.StatusBadRequest)
+			return
+		}
+		paths = binding.Paths
+
+		if len(binding.Corpora) > 0 {
+			if corpora, err = rh.threadCorpora(ws.tenant.ID, binding.Corpora); err != nil {
+				http.Error(w, fmt.Sprintf("Error loading corpora: %v", err), http.StatusNotFound)
+				return
+			}
+		}
+
+		if persona == "" {
+			persona = binding.Persona
+		} else if persona != binding.Persona {
+			if err := rh.threads.SetPersona(threadID, persona); err != nil {
+				http.Error(w, fmt.Sprintf("Error updating persona: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
 	}
 
-	res, err := assistant.RunThreadMsg(rh.oaiClient, rh.assistantID, threadID, req.Question)
+	// A per-query model wins over the model chosen when the repo was crawled.
+	model := req.Model
+	if model == "" {
+		model = repo.Model
+	}
+
+	instructionFiles := repo.InstructionFiles
+	if len(corpora) > 0 {
+		instructionFiles = corporaInstructions(corpora)
+	}
+	additional, version := rh.layerInstructions(instructionFiles, persona)
+	if len(paths) > 0 {
+		additional = strings.TrimSpace(additional + "\n\n" + scopeInstructions(paths))
+	}
+	if scope != "" {
+		additional = strings.TrimSpace(additional + "\n\n" + scope)
+	}
+	res, err := assistant.RunThreadMsg(ws.client, ws.assistantID, threadID, req.Question, assistant.RunOptions{
+		Model:                  model,
+		AdditionalInstructions: additional,
+	})
 	if err != nil {
+		rh.recordFailedUsage(ws, r, repo.Username, repo.Reponame, res.Usage)
 		http.Error(w, fmt.Sprintf("Error sending message to thread: %v", err), http.StatusInternalServerError)
 		return
 	}
+	res.Usage = rh.recordUsage(ws, r, repo.Username, repo.Reponame, res.Usage)
+
+	parts, err := rh.exposeOutputs(threadID, res.Parts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error recording thread outputs: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	bundlePath := repo.BundlePath
+	if len(paths) > 0 {
+		bundlePath = utils.ScopePath(repo.BundlePath, paths)
+	}
+	citations := utils.ResolveCitations(bundlePath, repo.RepoDir, res.Annotations)
+	if len(corpora) > 0 {
+		citations = corporaCitations(corpora, res.Annotations)
+	}
 
 	response := types.QueryResponse{
-		Message:  "Query initiated successfully",
-		Username: req.GithubUser,
-		Reponame: req.RepoName,
-		Response: res,
-		ThreadID: string(threadID),
+		Message:   "Query initiated successfully",
+		Username:  req.GithubUser,
+		Reponame:  req.RepoName,
+		Response:  res.Text,
+		ThreadID:  string(threadID),
+		Parts:     parts,
+		Citations: citations,
+		Model:     res.Usage.Model,
+		Usage:     res.Usage,
+
+		Instructions: version,
+	}
+
+	err = rh.queryLog.Append(types.QueryLogEntry{
+		At:           time.Now().Unix(),
+		TenantID:     ws.tenant.ID,
+		Username:     repo.Username,
+		Reponame:     repo.Reponame,
+		ThreadID:     threadID,
+		Model:        res.Usage.Model,
+		Instructions: version,
+		Usage:        res.Usage,
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to log query: %v\n", err)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -226,3 +864,296 @@ func (rh *RepoHandler) QueryHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 	}
 }
+
+func (rh *RepoHandler) createRepoThread(ws workspace, username, reponame, fileID, persona string, paths []string) (types.ThreadID, error) {
+	threadID, err := assistant.CreateRepoThread(ws.client, username, reponame, fileID)
+	if err != nil {
+		return "", err
+	}
+
+	err = rh.threads.Bind(types.ThreadBinding{
+		ThreadID:  threadID,
+		TenantID:  ws.tenant.ID,
+		Username:  username,
+		Reponame:  reponame,
+		FileID:    fileID,
+		CreatedAt: time.Now().Unix(),
+		Persona:   persona,
+		Paths:     paths,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not bind thread to %s/%s: %v", username, reponame, err)
+	}
+	return threadID, nil
+}
+
+// exposeOutputs points image parts at the file proxy and allows the thread to
+// download them.
+func (rh *RepoHandler) exposeOutputs(threadID types.ThreadID, parts []types.ContentPart) ([]types.ContentPart, error) {
+	var fileIDs []string
+	for i, part := range parts {
+		if part.Type != "image_file" {
+			continue
+		}
+		fileIDs = append(fileIDs, part.FileID)
+		parts[i].URL = fmt.Sprintf("/api/v1/files/%s/content?tid=%s", part.FileID, threadID)
+	}
+	if len(fileIDs) == 0 {
+		return parts, nil
+	}
+	return parts, rh.threads.AddOutputs(threadID, fileIDs...)
+}
+
+func (rh *RepoHandler) FileHandler(w http.ResponseWriter, r *http.Request) {
+	// Example path: /api/v1/files/file-abc123/content
+	fileID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/files/"), "/content")
+	if fileID == "" || strings.Contains(fileID, "/") {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	ws, err := rh.workspaces.resolve(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading workspace: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	binding, ok := rh.threads.Get(types.ThreadID(r.URL.Query().Get("tid")))
+	if !ok || binding.TenantID != ws.tenant.ID {
+		http.Error(w, "Thread not found", http.StatusNotFound)
+		return
+	}
+
+	allowed := false
+	for _, id := range binding.OutputIDs {
+		if id == fileID {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		http.Error(w, "File does not belong to this thread", http.StatusForbidden)
+		return
+	}
+
+	content, err := ws.client.GetFileContent(r.Context(), fileID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching file: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer content.Close()
+
+	if contentType := content.Header().Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if _, err := io.Copy(w, content); err != nil {
+		log.Printf("Error streaming file '%s': %v\n", fileID, err)
+	}
+}
+
+// recordUsage prices a completed run and charges it to the repository's usage
+// and to the client's and repository's token budgets. A failure to record is
+// logged rather than discarding an answer already paid for.
+func (rh *RepoHandler) recordUsage(ws workspace, r *http.Request, username, reponame string, usage types.Usage) types.Usage {
+	now := time.Now()
+	usage = rh.prices.Cost(usage)
+	if err := rh.usage.Record(ws.tenant.ID, username, reponame, usage, now); err != nil {
+		log.Printf("Warning: Failed to record usage for %s/%s: %v\n", username, reponame, err)
+	}
+	// Refreshes run for no client.
+	if r != nil {
+		if err := rh.clientLimit.Spend(clientKey(ws, r), usage.TotalTokens, now); err != nil {
+			log.Printf("Warning: Failed to charge client budget: %v\n", err)
+		}
+	}
+	if err := rh.repoLimit.Spend(repoLimitKey(ws, username, reponame), usage.TotalTokens, now); err != nil {
+		log.Printf("Warning: Failed to charge budget for %s/%s: %v\n", username, reponame, err)
+	}
+	return usage
+}
+
+// recordFailedUsage records the tokens used by a run that failed or timed
+// out, which are billed all the same.
+func (rh *RepoHandler) recordFailedUsage(ws workspace, r *http.Request, username, reponame string, usage types.Usage) {
+	if usage.TotalTokens > 0 {
+		rh.recordUsage(ws, r, username, reponame, usage)
+	}
+}
+
+// checkLimits applies the client and repository rate limits and budgets,
+// writing a 429 response and returning false when either is exhausted.
+func (rh *RepoHandler) checkLimits(w http.ResponseWriter, r *http.Request, ws workspace, username, reponame string) bool {
+	now := time.Now()
+	checks := []struct {
+		limiter *limits.Limiter
+		key     string
+	}{
+		{rh.clientLimit, clientKey(ws, r)},
+		{rh.repoLimit, repoLimitKey(ws, username, reponame)},
+	}
+
+	// Both limits are checked before either counts the request, so a
+	// request refused by one is not charged to the other.
+	for _, check := range checks {
+		status := check.limiter.Check(check.key, now)
+		if status.Allowed {
+			continue
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(status.RetryAfter))
+		w.WriteHeader(http.StatusTooManyRequests)
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			log.Printf("Failed to encode limit response: %v\n", err)
+		}
+		return false
+	}
+	for _, check := range checks {
+		if _, err := check.limiter.Take(check.key, now); err != nil {
+			log.Printf("Warning: Failed to persist limits for '%s': %v\n", check.key, err)
+		}
+	}
+	return true
+}
+
+// clientKey identifies the caller by API key, falling back to remote IP.
+func clientKey(ws workspace, r *http.Request) string {
+	if ws.key.ID != "" {
+		return "key:" + ws.key.ID
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func repoLimitKey(ws workspace, username, reponame string) string {
+	return "repo:" + ws.tenant.ID + ":" + strings.ToLower(username+"/"+reponame)
+}
+
+func (rh *RepoHandler) UsageHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to := query.Get("from"), query.Get("to")
+	for _, day := range []string{from, to} {
+		if day == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid date '%s', expected YYYY-MM-DD", day), http.StatusBadRequest)
+			return
+		}
+	}
+
+	p, ok := principalFrom(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	report := rh.usage.Report(p.tenant.ID, query.Get("githubUser"), query.Get("repoName"), from, to)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// layerInstructions builds the run-level instructions for a repository's
+// instruction files and a thread's persona, and reports the version of every
+// layer used. Files are read on each run so edits apply without a restart.
+func (rh *RepoHandler) layerInstructions(instructionFiles []string, persona string) (string, types.InstructionsVersion) {
+	version := types.InstructionsVersion{Persona: persona}
+	if global, ok := rh.versions.Current(rh.cfg.InstructionsFile); ok {
+		version.Global = global.Hash
+	}
+
+	var repoInstructions []string
+	for _, file := range instructionFiles {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			log.Printf("Warning: Failed to read instructions '%s': %v\n", file, err)
+			continue
+		}
+		repoInstructions = append(repoInstructions, string(content))
+		version.Repo = append(version.Repo, instructions.Hash(string(content)))
+	}
+
+	personaText, _ := rh.cfg.Persona(persona)
+	return instructions.Layer(strings.Join(repoInstructions, "\n\n"), personaText), version
+}
+
+// PlanAssistants prints, without applying, the changes needed to bring each
+// tenant's assistant in line with the configuration.
+func (rh *RepoHandler) PlanAssistants(tenants []types.Tenant) {
+	rh.workspaces.plan(tenants)
+}
+
+// SetInstructions applies new global instructions to every tenant assistant.
+func (rh *RepoHandler) SetInstructions(content string) {
+	rh.workspaces.setInstructions(content)
+}
+
+// saveRepoInstructions copies the cloned repository's REPOTALK.md next to its
+// bundle so it outlives the checkout, removing a stale copy if it is gone.
+func saveRepoInstructions(repoDir, bundlePath string) error {
+	dst := repoInstructionsPath(bundlePath)
+	content, err := instructions.ReadRepoFile(repoDir)
+	if err != nil {
+		return err
+	}
+	if content == "" {
+		if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(dst, []byte(content), 0644)
+}
+
+// saveCrawlInstructions stores instructions supplied with a crawl request and
+// returns every instruction file kept for the repository.
+func saveCrawlInstructions(bundlePath, text string) ([]string, error) {
+	if strings.TrimSpace(text) != "" {
+		if err := os.WriteFile(requestInstructionsPath(bundlePath), []byte(text), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return instructionFiles(bundlePath), nil
+}
+
+// instructionFiles returns the instruction files kept next to a bundle: the
+// repository's REPOTALK.md and those of the last crawl request.
+func instructionFiles(bundlePath string) []string {
+	var files []string
+	for _, file := range []string{repoInstructionsPath(bundlePath), requestInstructionsPath(bundlePath)} {
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+func repoInstructionsPath(bundlePath string) string {
+	return filepath.Join(filepath.Dir(bundlePath), instructions.RepoFile)
+}
+
+func requestInstructionsPath(bundlePath string) string {
+	return filepath.Join(filepath.Dir(bundlePath), "instructions.md")
+}
+
+// gitHead returns the checked-out branch and commit of the git work tree at
+// dir, or empty strings if dir is not one.
+func gitHead(dir string) (string, string) {
+	branch, err := exec.Command("git", "-C", dir, "rev-parse", "--abbrev-ref", "HEAD").Output()
+	if err != nil {
+		return "", ""
+	}
+	commit, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", ""
+	}
+	return strings.TrimSpace(string(branch)), strings.TrimSpace(string(commit))
+}
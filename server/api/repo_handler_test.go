@@ -0,0 +1,95 @@
+package api
+
+import (
+	"archive/zip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gastrader/repotalk/config"
+)
+
+// git runs a git command in dir and returns its trimmed output.
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	args = append([]string{"-C", dir, "-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// initRepo creates a git repository with one commit on main and returns its
+// directory and HEAD.
+func initRepo(t *testing.T, dir string) string {
+	t.Helper()
+	git(t, dir, "init", "-q", "-b", "main")
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, dir, "add", "-A")
+	git(t, dir, "commit", "-q", "-m", "initial")
+	return git(t, dir, "rev-parse", "HEAD")
+}
+
+func writeZipUpload(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "app.zip")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	zw := zip.NewWriter(file)
+	w, err := zw.Create("app/handler.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("package app\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBundleSourceCommit(t *testing.T) {
+	// The server's own directories sit inside a git work tree, as when it
+	// runs from a clone of its repository.
+	work := t.TempDir()
+	head := initRepo(t, work)
+	local := filepath.Join(t.TempDir(), "local")
+	if err := os.Mkdir(local, 0755); err != nil {
+		t.Fatal(err)
+	}
+	localHead := initRepo(t, local)
+
+	tests := []struct {
+		name       string
+		src        crawlSource
+		wantBranch string
+		wantCommit string
+	}{
+		{"archive upload", crawlSource{upload: writeZipUpload(t, t.TempDir()), uploadName: "app.zip"}, "", ""},
+		{"local work tree", crawlSource{local: local}, "main", localHead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rh := &RepoHandler{cfg: config.Default()}
+			repoDir := filepath.Join(work, "repos", "upload", "app")
+			bundlePath := filepath.Join(work, "bundles", "upload", "app", "bundle.txt")
+
+			co, err := rh.bundleSource(tt.src, repoDir, bundlePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if co.branch != tt.wantBranch || co.commit != tt.wantCommit {
+				t.Errorf("checkout at %q %q, want %q %q (enclosing HEAD %s)", co.branch, co.commit, tt.wantBranch, tt.wantCommit, head)
+			}
+		})
+	}
+}
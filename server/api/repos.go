@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gastrader/repotalk/graph"
+	"github.com/gastrader/repotalk/history"
+	"github.com/gastrader/repotalk/redact"
+	"github.com/gastrader/repotalk/symbols"
+	"github.com/gastrader/repotalk/types"
+)
+
+// ReposHandler serves GET /api/v1/repos/{user}/{repo}/{resource} for the
+// caller's crawled repositories.
+func (rh *RepoHandler) ReposHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/repos/"), "/"), "/")
+	if len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+	username, reponame, resource := parts[0], parts[1], parts[2]
+
+	p, ok := principalFrom(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	repo, ok := rh.repos.Get(p.tenant.ID, username, reponame)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Repository %s/%s has not been crawled", username, reponame), http.StatusNotFound)
+		return
+	}
+
+	switch resource {
+	case "overview":
+		rh.overviewHandler(w, repo)
+	case "graph":
+		rh.graphHandler(w, r, repo)
+	case "symbols":
+		rh.symbolsHandler(w, r, repo)
+	case "history":
+		rh.historyHandler(w, r, repo)
+	case "redactions":
+		report, err := redact.Load(repo.BundlePath)
+		if err != nil {
+			http.Error(w, "No redaction report for this repository", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// graphHandler serves the repository's import graph as JSON, or as Graphviz
+// DOT with ?format=dot. ?node= narrows it to a package's direct dependencies
+// and dependents, and ?external=false drops external dependencies.
+func (rh *RepoHandler) graphHandler(w http.ResponseWriter, r *http.Request, repo types.RepoRecord) {
+	table, err := symbols.Load(repo.BundlePath)
+	if err != nil {
+		http.Error(w, "No symbol data for this repository; crawl it again", http.StatusNotFound)
+		return
+	}
+	g := graph.Build(table)
+
+	query := r.URL.Query()
+	var ids []string
+	if node := query.Get("node"); node != "" {
+		var ok bool
+		if ids, ok = graph.Neighbourhood(g, node); !ok {
+			http.Error(w, fmt.Sprintf("Unknown node '%s'", node), http.StatusNotFound)
+			return
+		}
+	}
+	g = graph.Filter(g, query.Get("external") == "false", ids...)
+
+	if query.Get("format") == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, graph.DOT(g))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(g); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// symbolsHandler serves ?name= lookups: where a symbol is defined and where
+// it is used, from the index built at crawl time.
+func (rh *RepoHandler) symbolsHandler(w http.ResponseWriter, r *http.Request, repo types.RepoRecord) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	table, err := symbols.Load(repo.BundlePath)
+	if err != nil {
+		http.Error(w, "No symbol data for this repository; crawl it again", http.StatusNotFound)
+		return
+	}
+	index, err := symbols.LoadReferences(repo.BundlePath)
+	if err != nil {
+		http.Error(w, "No reference data for this repository; crawl it again", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, symbols.Lookup(table, index, name))
+}
+
+// historyHandler serves the git history extracted at crawl time. ?from= and
+// ?to= narrow the commits to a range, as for scoped questions.
+func (rh *RepoHandler) historyHandler(w http.ResponseWriter, r *http.Request, repo types.RepoRecord) {
+	hist, err := history.Load(repo.BundlePath)
+	if err != nil {
+		http.Error(w, "No git history for this repository; crawl it again", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	if from, to := query.Get("from"), query.Get("to"); from != "" || to != "" {
+		hist.Commits, err = history.Range(hist, types.CommitRange{From: from, To: to})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid commit range: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, hist)
+}
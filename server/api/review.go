@@ -0,0 +1,215 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gastrader/repotalk/assistant"
+	"github.com/gastrader/repotalk/redact"
+	"github.com/gastrader/repotalk/review"
+	"github.com/gastrader/repotalk/sources"
+	"github.com/gastrader/repotalk/types"
+)
+
+// ReviewHandler reviews the changes between two refs of a crawled
+// repository. The diff is computed from a fresh checkout of the crawl's
+// source and reviewed in a new thread with the repository bundle attached.
+func (rh *RepoHandler) ReviewHandler(w http.ResponseWriter, r *http.Request) {
+	var req types.ReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.GithubUser == "" || req.RepoName == "" {
+		http.Error(w, "githubUser and repoName are required", http.StatusBadRequest)
+		return
+	}
+	if req.Base == "" || req.Head == "" {
+		http.Error(w, "base and head are required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Model != "" && !rh.cfg.ModelAllowed(req.Model) {
+		http.Error(w, fmt.Sprintf("Model '%s' is not allowed", req.Model), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := rh.cfg.Persona(req.Persona); req.Persona != "" && !ok {
+		http.Error(w, fmt.Sprintf("Unknown persona '%s'", req.Persona), http.StatusBadRequest)
+		return
+	}
+
+	ws, err := rh.workspaces.resolve(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading workspace: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	repo, ok := rh.repos.Get(ws.tenant.ID, req.GithubUser, req.RepoName)
+	if !ok {
+		http.Error(w, "Repository has not been crawled", http.StatusNotFound)
+		return
+	}
+
+	if !rh.checkLimits(w, r, ws, repo.Username, repo.Reponame) {
+		return
+	}
+
+	src, err := rh.sourceOf(repo, "")
+	if writePolicyError(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Repository cannot be reviewed: %v", err), http.StatusBadRequest)
+		return
+	}
+	dir, cleanup, err := reviewCheckout(src)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching repository: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer cleanup()
+
+	// Only fetch into clones made for this review, never into a local
+	// working copy.
+	fetch := src.github != ""
+	base, err := review.Resolve(dir, req.Base, fetch)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error resolving base: %v", err), http.StatusBadRequest)
+		return
+	}
+	head, err := review.Resolve(dir, req.Head, fetch)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error resolving head: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	diff, files, err := review.Diff(dir, base, head, src.paths)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error computing diff: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if len(files) == 0 {
+		http.Error(w, "No changes between base and head", http.StatusBadRequest)
+		return
+	}
+	if len(diff) > review.MaxDiffBytes {
+		http.Error(w, fmt.Sprintf("Diff is too large to review (%d bytes, limit %d)", len(diff), review.MaxDiffBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// The diff bypasses the bundle, so it is redacted here under the same
+	// policy.
+	var redactions *types.RedactionReport
+	if policy := rh.cfg.Redaction.Policy; policy != redact.PolicyOff {
+		redacted, report := redact.Diff(diff)
+		report.Policy = policy
+		if policy == redact.PolicyStrict && report.Redacted > 0 {
+			report.Blocked = true
+			writeJSON(w, http.StatusUnprocessableEntity, report)
+			return
+		}
+		diff, redactions = redacted, &report
+	}
+
+	threadID, err := rh.createRepoThread(ws, repo.Username, repo.Reponame, repo.FileID, req.Persona, nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error creating thread: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	model := req.Model
+	if model == "" {
+		model = repo.Model
+	}
+
+	additional, version := rh.layerInstructions(repo.InstructionFiles, req.Persona)
+	res, err := assistant.RunThreadMsg(ws.client, ws.assistantID, threadID, review.Prompt+"```diff\n"+diff+"```", assistant.RunOptions{
+		Model:                  model,
+		AdditionalInstructions: additional,
+	})
+	if err != nil {
+		rh.recordFailedUsage(ws, r, repo.Username, repo.Reponame, res.Usage)
+		http.Error(w, fmt.Sprintf("Error sending message to thread: %v", err), http.StatusInternalServerError)
+		return
+	}
+	res.Usage = rh.recordUsage(ws, r, repo.Username, repo.Reponame, res.Usage)
+
+	summary, findings, err := review.Parse(res.Text)
+	if err != nil {
+		log.Printf("Warning: Failed to parse review of %s/%s: %v\n", repo.Username, repo.Reponame, err)
+		summary, findings = res.Text, []types.ReviewFinding{}
+	}
+
+	err = rh.queryLog.Append(types.QueryLogEntry{
+		At:           time.Now().Unix(),
+		TenantID:     ws.tenant.ID,
+		Username:     repo.Username,
+		Reponame:     repo.Reponame,
+		ThreadID:     threadID,
+		Model:        res.Usage.Model,
+		Instructions: version,
+		Usage:        res.Usage,
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to log review: %v\n", err)
+	}
+
+	writeJSON(w, http.StatusOK, types.ReviewResponse{
+		Message:  "Review completed successfully",
+		Username: repo.Username,
+		Reponame: repo.Reponame,
+		Base:     base,
+		Head:     head,
+		Files:    files,
+		ThreadID: string(threadID),
+		Summary:  summary,
+		Findings: findings,
+		Model:    res.Usage.Model,
+		Usage:    res.Usage,
+
+		Instructions: version,
+		Redactions:   redactions,
+	})
+}
+
+// reviewCheckout returns a git checkout of src with its history. Local
+// directories are used in place; anything else is cloned into a temporary
+// directory that cleanup removes.
+func reviewCheckout(src crawlSource) (string, func(), error) {
+	if src.local != "" {
+		info, err := os.Stat(src.local)
+		if err != nil {
+			return "", nil, err
+		}
+		if info.IsDir() {
+			return src.local, func() {}, nil
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "repotalk-review-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Warning: Failed to delete directory '%s': %v\n", dir, err)
+		}
+	}
+
+	if src.github != "" {
+		err = cloneGitHubRepo(src.github, "", dir)
+	} else {
+		err = sources.CloneBundle(src.local, dir)
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return dir, cleanup, nil
+}
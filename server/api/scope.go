@@ -0,0 +1,53 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gastrader/repotalk/assistant"
+	"github.com/gastrader/repotalk/types"
+	"github.com/gastrader/repotalk/utils"
+)
+
+// scopedFile returns the uploaded file holding the part of the repository's
+// bundle under paths, writing and uploading it on first use. It returns the
+// status to respond with when nothing was crawled under paths.
+func (rh *RepoHandler) scopedFile(ws workspace, repo types.RepoRecord, paths []string) (string, int, error) {
+	key := strings.Join(paths, ",")
+	if fileID, ok := repo.Scopes[key]; ok {
+		return fileID, http.StatusOK, nil
+	}
+
+	// The bundle must not be rewritten while it is read.
+	defer rh.locks.lock(repo.TenantID, repo.Username, repo.Reponame)()
+
+	bundlePath, n, err := utils.ScopeBundle(repo.BundlePath, repo.RepoDir, paths)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("Error scoping bundle: %v", err)
+	}
+	if n == 0 {
+		return "", http.StatusNotFound, fmt.Errorf("No crawled files under %s", strings.Join(paths, ", "))
+	}
+	fileID, err := assistant.UploadFile(ws.client, bundlePath)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("Error uploading file: %v", err)
+	}
+
+	recorded, err := rh.repos.AddScope(repo, key, fileID)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("Error saving repository: %v", err)
+	}
+	if recorded != "" && recorded != fileID {
+		// Another query uploaded the same part first.
+		deleteUploads(ws, fileID)
+		return recorded, http.StatusOK, nil
+	}
+	return fileID, http.StatusOK, nil
+}
+
+// scopeInstructions tells the assistant which part of the repository a
+// scoped thread covers.
+func scopeInstructions(paths []string) string {
+	return fmt.Sprintf("Only the files under %s are attached to this conversation. Answer about that part of the repository and say so when a question needs code outside it.", strings.Join(paths, ", "))
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+
+	"github.com/gastrader/repotalk/assistant"
+	"github.com/gastrader/repotalk/types"
+)
+
+// uploadBundle uploads the bundle at bundlePath and returns its file ID and
+// content hash. If prev, the repository's record before this crawl, holds an
+// upload of the same content, that upload is returned instead.
+func uploadBundle(ws workspace, bundlePath string, prev types.RepoRecord) (string, string, bool, error) {
+	hash, err := fileHash(bundlePath)
+	if err != nil {
+		return "", "", false, err
+	}
+	if prev.FileID != "" && prev.BundleHash == hash {
+		return prev.FileID, hash, true, nil
+	}
+	fileID, err := assistant.UploadFile(ws.client, bundlePath)
+	return fileID, hash, false, err
+}
+
+// deleteReplaced deletes the uploads prev held that rec, its replacement, no
+// longer refers to. Threads started on them lose their files, so answers
+// come from the current bundle.
+func deleteReplaced(ws workspace, prev, rec types.RepoRecord) {
+	keep := map[string]bool{rec.FileID: true}
+	for _, fileID := range rec.Scopes {
+		keep[fileID] = true
+	}
+
+	var stale []string
+	if prev.FileID != "" && !keep[prev.FileID] {
+		stale = append(stale, prev.FileID)
+	}
+	for _, fileID := range prev.Scopes {
+		if !keep[fileID] {
+			stale = append(stale, fileID)
+		}
+	}
+	deleteUploads(ws, stale...)
+}
+
+func deleteUploads(ws workspace, fileIDs ...string) {
+	for _, fileID := range fileIDs {
+		if err := assistant.DeleteFile(ws.client, fileID); err != nil {
+			log.Printf("Warning: %v\n", err)
+		}
+	}
+}
+
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+const maxWebhookBytes = 5 << 20
+
+type githubPush struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		FullName      string `json:"full_name"`
+		HTMLURL       string `json:"html_url"`
+		CloneURL      string `json:"clone_url"`
+		SSHURL        string `json:"ssh_url"`
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+}
+
+// GitHubWebhookHandler serves POST /api/v1/webhooks/github. Push events to a
+// crawled repository's branch queue a refresh of that repository.
+func (rh *RepoHandler) GitHubWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, ok := readSignedWebhook(w, r, rh.cfg.Webhooks.GitHubSecret, "X-Hub-Signature-256")
+	if !ok {
+		return
+	}
+
+	switch event := r.Header.Get("X-GitHub-Event"); event {
+	case "ping":
+		writeJSON(w, http.StatusOK, types.WebhookResponse{Message: "pong"})
+		return
+	case "push":
+	default:
+		writeJSON(w, http.StatusAccepted, types.WebhookResponse{Message: fmt.Sprintf("Ignored '%s' event", event)})
+		return
+	}
+
+	var push githubPush
+	if err := json.Unmarshal(body, &push); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if push.Deleted {
+		writeJSON(w, http.StatusAccepted, types.WebhookResponse{Message: "Ignored branch deletion"})
+		return
+	}
+
+	repo := push.Repository
+	urls := []string{repo.HTMLURL, repo.CloneURL, repo.SSHURL}
+	rh.queuePush(w, urls, push.Ref, push.After, repo.DefaultBranch)
+}
+
+// GitWebhookHandler serves POST /api/v1/webhooks/git for other git hosts and
+// post-receive hooks. The body is a types.GitPushEvent signed like GitHub's:
+// X-Repotalk-Signature is "sha256=" and the hex HMAC-SHA256 of the body.
+func (rh *RepoHandler) GitWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, ok := readSignedWebhook(w, r, rh.cfg.Webhooks.GitSecret, "X-Repotalk-Signature")
+	if !ok {
+		return
+	}
+
+	var push types.GitPushEvent
+	if err := json.Unmarshal(body, &push); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if push.URL == "" || push.Ref == "" {
+		http.Error(w, "url and ref are required", http.StatusBadRequest)
+		return
+	}
+
+	rh.queuePush(w, []string{push.URL}, push.Ref, push.Commit, push.DefaultBranch)
+}
+
+// queuePush queues a refresh of every crawled repository, across tenants,
+// that was cloned from one of urls at the pushed branch.
+func (rh *RepoHandler) queuePush(w http.ResponseWriter, urls []string, ref, commit, defaultBranch string) {
+	branch, ok := strings.CutPrefix(ref, "refs/heads/")
+	if !ok {
+		writeJSON(w, http.StatusAccepted, types.WebhookResponse{Message: fmt.Sprintf("Ignored push to '%s'", ref)})
+		return
+	}
+	if strings.Trim(commit, "0") == "" {
+		writeJSON(w, http.StatusAccepted, types.WebhookResponse{Message: "Ignored branch deletion"})
+		return
+	}
+
+	pushed := make(map[string]bool)
+	for _, u := range urls {
+		if u != "" {
+			pushed[normalizeRepoURL(u)] = true
+		}
+	}
+
+	queued := 0
+	for _, rec := range rh.repos.All() {
+		if !pushed[normalizeRepoURL(rec.URL)] {
+			continue
+		}
+		// Repositories crawled before branches were recorded follow the
+		// default branch.
+		tracked := rec.Branch
+		if tracked == "" {
+			tracked = defaultBranch
+		}
+		if tracked != branch {
+			continue
+		}
+
+		job := refreshJob{
+			tenantID: rec.TenantID,
+			username: rec.Username,
+			reponame: rec.Reponame,
+			branch:   branch,
+			commit:   commit,
+		}
+		if rh.refreshes.enqueue(job) {
+			queued++
+		}
+	}
+
+	writeJSON(w, http.StatusAccepted, types.WebhookResponse{
+		Message:   fmt.Sprintf("Queued %d refresh(es) of %s", queued, branch),
+		Refreshes: queued,
+	})
+}
+
+// readSignedWebhook reads the request body and checks its HMAC-SHA256
+// signature against secret. It writes the error response itself.
+func readSignedWebhook(w http.ResponseWriter, r *http.Request, secret, header string) ([]byte, bool) {
+	if secret == "" {
+		http.Error(w, "Webhook is not configured", http.StatusNotFound)
+		return nil, false
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBytes))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading payload: %v", err), http.StatusBadRequest)
+		return nil, false
+	}
+
+	if !validSignature(secret, body, r.Header.Get(header)) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return nil, false
+	}
+	return body, true
+}
+
+func validSignature(secret string, body []byte, signature string) bool {
+	given, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	givenMAC, err := hex.DecodeString(given)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(givenMAC, mac.Sum(nil))
+}
+
+// normalizeRepoURL reduces the HTTPS and SSH forms of a repository URL to
+// "host/owner/repo" so they compare equal.
+func normalizeRepoURL(u string) string {
+	u = strings.ToLower(strings.TrimSpace(u))
+	for _, prefix := range []string{"https://", "http://", "ssh://", "git://"} {
+		u = strings.TrimPrefix(u, prefix)
+	}
+	if at := strings.Index(u, "@"); at >= 0 {
+		u = u[at+1:]
+	}
+	u = strings.Replace(u, ":", "/", 1)
+	u = strings.TrimPrefix(u, "www.")
+	u = strings.TrimSuffix(u, "/")
+	return strings.TrimSuffix(u, ".git")
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gastrader/repotalk/config"
+	"github.com/gastrader/repotalk/store"
+	"github.com/gastrader/repotalk/types"
+)
+
+const testSecret = "s3cret"
+
+// newWebhookHandler returns a handler over repos whose refreshes are queued
+// but never run, so tests can inspect the queue.
+func newWebhookHandler(t *testing.T, repos ...types.RepoRecord) *RepoHandler {
+	t.Helper()
+	rs, err := store.NewRepoStore(filepath.Join(t.TempDir(), "repos.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range repos {
+		if err := rs.Put(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var cfg config.Config
+	cfg.Webhooks.GitHubSecret = testSecret
+	cfg.Webhooks.GitSecret = testSecret
+	return &RepoHandler{cfg: cfg, repos: rs, refreshes: newRefreshQueue()}
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func readPayload(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func postGitHub(rh *RepoHandler, event string, body []byte, signature string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/webhooks/github", bytes.NewReader(body))
+	r.Header.Set("X-GitHub-Event", event)
+	r.Header.Set("X-Hub-Signature-256", signature)
+	w := httptest.NewRecorder()
+	rh.GitHubWebhookHandler(w, r)
+	return w
+}
+
+func decodeWebhook(t *testing.T, w *httptest.ResponseRecorder) types.WebhookResponse {
+	t.Helper()
+	var res types.WebhookResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("cannot decode response %q: %v", w.Body.String(), err)
+	}
+	return res
+}
+
+func TestGitHubWebhook(t *testing.T) {
+	app := types.RepoRecord{TenantID: "t1", Username: "acme", Reponame: "app", URL: "https://github.com/acme/app", Branch: "main"}
+	appV1 := types.RepoRecord{TenantID: "t1", Username: "acme", Reponame: "app-v1", URL: "https://github.com/acme/app", Branch: "v1"}
+	other := types.RepoRecord{TenantID: "t2", Username: "acme", Reponame: "app", URL: "git@github.com:acme/app.git"}
+	push := readPayload(t, "github_push.json")
+	deleted := readPayload(t, "github_push_deleted.json")
+	empty := []byte(`{}`)
+
+	tests := []struct {
+		name      string
+		event     string
+		payload   []byte
+		signature string
+		wantCode  int
+		wantJobs  int
+	}{
+		{"valid signature", "push", push, sign(testSecret, push), http.StatusAccepted, 2},
+		{"bad signature", "push", push, sign("wrong", push), http.StatusUnauthorized, 0},
+		{"missing signature", "push", push, "", http.StatusUnauthorized, 0},
+		{"malformed signature", "push", push, "sha256=zz", http.StatusUnauthorized, 0},
+		{"ping", "ping", empty, sign(testSecret, empty), http.StatusOK, 0},
+		{"other event", "issues", empty, sign(testSecret, empty), http.StatusAccepted, 0},
+		{"branch deletion", "push", deleted, sign(testSecret, deleted), http.StatusAccepted, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rh := newWebhookHandler(t, app, appV1, other)
+			w := postGitHub(rh, tt.event, tt.payload, tt.signature)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if got := len(rh.refreshes.jobs); got != tt.wantJobs {
+				t.Errorf("queued %d refreshes, want %d", got, tt.wantJobs)
+			}
+		})
+	}
+}
+
+func TestGitHubWebhookQueuesTrackedBranch(t *testing.T) {
+	// v1 is not pushed, and the record without a branch follows the
+	// default branch.
+	rh := newWebhookHandler(t,
+		types.RepoRecord{TenantID: "t1", Username: "acme", Reponame: "app-v1", URL: "https://github.com/acme/app", Branch: "v1"},
+		types.RepoRecord{TenantID: "t2", Username: "acme", Reponame: "app", URL: "git@github.com:acme/app.git"},
+	)
+	push := readPayload(t, "github_push.json")
+	w := postGitHub(rh, "push", push, sign(testSecret, push))
+	if res := decodeWebhook(t, w); res.Refreshes != 1 {
+		t.Fatalf("refreshes = %d, want 1", res.Refreshes)
+	}
+
+	job := <-rh.refreshes.jobs
+	want := refreshJob{tenantID: "t2", username: "acme", reponame: "app", branch: "main", commit: "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c"}
+	if job != want {
+		t.Errorf("job = %+v, want %+v", job, want)
+	}
+}
+
+func TestGitHubWebhookBranchMismatch(t *testing.T) {
+	rh := newWebhookHandler(t, types.RepoRecord{TenantID: "t1", Username: "acme", Reponame: "app", URL: "https://github.com/acme/app", Branch: "develop"})
+	push := readPayload(t, "github_push.json")
+	w := postGitHub(rh, "push", push, sign(testSecret, push))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusAccepted)
+	}
+	if res := decodeWebhook(t, w); res.Refreshes != 0 {
+		t.Errorf("refreshes = %d, want 0", res.Refreshes)
+	}
+}
+
+func TestGitHubWebhookDedupesPendingRefreshes(t *testing.T) {
+	rh := newWebhookHandler(t, types.RepoRecord{TenantID: "t1", Username: "acme", Reponame: "app", URL: "https://github.com/acme/app", Branch: "main"})
+	push := readPayload(t, "github_push.json")
+
+	for i, want := range []int{1, 0, 0} {
+		w := postGitHub(rh, "push", push, sign(testSecret, push))
+		if res := decodeWebhook(t, w); res.Refreshes != want {
+			t.Errorf("push %d: refreshes = %d, want %d", i, res.Refreshes, want)
+		}
+	}
+	if got := len(rh.refreshes.jobs); got != 1 {
+		t.Fatalf("queued %d refreshes, want 1", got)
+	}
+
+	// Once the refresh starts, a new push queues another.
+	rh.refreshes.done(<-rh.refreshes.jobs)
+	w := postGitHub(rh, "push", push, sign(testSecret, push))
+	if res := decodeWebhook(t, w); res.Refreshes != 1 {
+		t.Errorf("push after refresh started: refreshes = %d, want 1", res.Refreshes)
+	}
+}
+
+func TestGitHubWebhookNotConfigured(t *testing.T) {
+	rh := newWebhookHandler(t)
+	rh.cfg.Webhooks.GitHubSecret = ""
+	push := readPayload(t, "github_push.json")
+	if w := postGitHub(rh, "push", push, sign("", push)); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestGitWebhook(t *testing.T) {
+	rec := types.RepoRecord{TenantID: "t1", Username: "acme", Reponame: "app", URL: "https://git.example.com/acme/app", Branch: "main"}
+	push := readPayload(t, "git_push.json")
+	noRef := []byte(`{"url": "https://git.example.com/acme/app"}`)
+	tag := []byte(`{"url": "https://git.example.com/acme/app", "ref": "refs/tags/v1", "commit": "abc"}`)
+
+	tests := []struct {
+		name      string
+		payload   []byte
+		signature string
+		wantCode  int
+		wantJobs  int
+	}{
+		{"valid signature", push, sign(testSecret, push), http.StatusAccepted, 1},
+		{"bad signature", push, sign("wrong", push), http.StatusUnauthorized, 0},
+		{"missing ref", noRef, sign(testSecret, noRef), http.StatusBadRequest, 0},
+		{"tag push", tag, sign(testSecret, tag), http.StatusAccepted, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rh := newWebhookHandler(t, rec)
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/webhooks/git", bytes.NewReader(tt.payload))
+			r.Header.Set("X-Repotalk-Signature", tt.signature)
+			w := httptest.NewRecorder()
+			rh.GitWebhookHandler(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if got := len(rh.refreshes.jobs); got != tt.wantJobs {
+				t.Errorf("queued %d refreshes, want %d", got, tt.wantJobs)
+			}
+		})
+	}
+}
+
+func TestNormalizeRepoURL(t *testing.T) {
+	want := "github.com/acme/app"
+	for _, u := range []string{
+		"https://github.com/acme/app",
+		"https://github.com/acme/app.git",
+		"https://www.github.com/acme/app/",
+		"git@github.com:acme/app.git",
+		"ssh://git@github.com/acme/app.git",
+		"HTTPS://GitHub.com/Acme/App",
+	} {
+		if got := normalizeRepoURL(u); got != want {
+			t.Errorf("normalizeRepoURL(%q) = %q, want %q", u, got, want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"github.com/gastrader/repotalk/assistant"
+	"github.com/gastrader/repotalk/config"
+	"github.com/gastrader/repotalk/types"
+	"github.com/sashabaranov/go-openai"
+)
+
+// workspace is a tenant's isolated view of the service: its OpenAI client,
+// its own assistant and its own repos and bundles directories.
+type workspace struct {
+	tenant      types.Tenant
+	key         types.APIKey
+	client      *openai.Client
+	assistantID types.AsstID
+	reposDir    string
+	bundlesDir  string
+}
+
+func (ws workspace) repoDir(username, reponame string) string {
+	return filepath.Join(ws.reposDir, ws.tenant.ID, username, reponame)
+}
+
+func (ws workspace) bundlePath(username, reponame string) string {
+	return filepath.Join(ws.bundlesDir, ws.tenant.ID, username, reponame, "bundle.txt")
+}
+
+type tenantAssistant struct {
+	id     types.AsstID
+	client *openai.Client
+}
+
+type workspaces struct {
+	mu           sync.Mutex
+	cfg          config.Config
+	sharedClient *openai.Client
+	instructions string
+	assistants   map[string]tenantAssistant
+}
+
+func newWorkspaces(shared *openai.Client, cfg config.Config, instructions string) *workspaces {
+	return &workspaces{
+		cfg:          cfg,
+		sharedClient: shared,
+		instructions: instructions,
+		assistants:   make(map[string]tenantAssistant),
+	}
+}
+
+// setInstructions applies new global instructions to every tenant assistant
+// created so far; assistants created later pick them up on creation.
+func (wss *workspaces) setInstructions(content string) {
+	wss.mu.Lock()
+	defer wss.mu.Unlock()
+
+	wss.instructions = content
+	for tenantID, asst := range wss.assistants {
+		if err := assistant.SetInstructions(asst.client, asst.id, content); err != nil {
+			fmt.Printf("Can't reload instructions for tenant '%s': %v\n", tenantID, err)
+		}
+	}
+}
+
+// asstConfig is the desired state of a tenant's assistant.
+func (wss *workspaces) asstConfig(tenant types.Tenant) types.AsstConfig {
+	asstConfig := wss.cfg.AsstConfig()
+	asstConfig.Name = fmt.Sprintf("%s-%s", asstConfig.Name, tenant.ID)
+	asstConfig.Instructions = wss.instructions
+	asstConfig.Metadata["tenant"] = tenant.ID
+	return asstConfig
+}
+
+// plan prints the changes reconciling each tenant's assistant would make.
+func (wss *workspaces) plan(tenants []types.Tenant) {
+	wss.mu.Lock()
+	defer wss.mu.Unlock()
+
+	for _, tenant := range tenants {
+		client := wss.sharedClient
+		if tenant.OpenAIKey != "" {
+			client = NewOpenAIClient(wss.cfg.Backend, tenant.OpenAIKey)
+		}
+		_, _, err := assistant.Reconcile(client, wss.asstConfig(tenant), assistant.ReconcileOptions{DryRun: true})
+		if err != nil {
+			fmt.Printf("Can't plan assistant for tenant '%s': %v\n", tenant.ID, err)
+		}
+	}
+}
+
+// resolve returns the workspace of the authenticated caller, creating the
+// tenant's assistant on first use.
+func (wss *workspaces) resolve(r *http.Request) (workspace, error) {
+	p, ok := principalFrom(r)
+	if !ok {
+		return workspace{}, fmt.Errorf("request is not authenticated")
+	}
+	return wss.forTenant(p.tenant, p.key)
+}
+
+// forTenant returns a tenant's workspace for work not tied to a request, such
+// as webhook refreshes, in which case key is empty.
+func (wss *workspaces) forTenant(tenant types.Tenant, key types.APIKey) (workspace, error) {
+	p := principal{tenant: tenant, key: key}
+
+	client := wss.sharedClient
+	if p.tenant.OpenAIKey != "" {
+		client = NewOpenAIClient(wss.cfg.Backend, p.tenant.OpenAIKey)
+	}
+
+	wss.mu.Lock()
+	defer wss.mu.Unlock()
+
+	asst, ok := wss.assistants[p.tenant.ID]
+	if !ok {
+		asstID, _, err := assistant.Reconcile(client, wss.asstConfig(p.tenant), assistant.ReconcileOptions{
+			DryRun: wss.cfg.Assistant.DryRun,
+		})
+		if err != nil {
+			return workspace{}, err
+		}
+		if asstID == "" {
+			return workspace{}, fmt.Errorf("assistant for tenant '%s' does not exist and dry run is enabled", p.tenant.ID)
+		}
+		asst = tenantAssistant{id: asstID, client: client}
+		wss.assistants[p.tenant.ID] = asst
+	}
+
+	return workspace{
+		tenant:      p.tenant,
+		key:         p.key,
+		client:      client,
+		assistantID: asst.id,
+		reposDir:    wss.cfg.ReposDir,
+		bundlesDir:  wss.cfg.BundlesDir,
+	}, nil
+}
+
+// NewOpenAIClient creates a client for the configured backend using apiKey.
+func NewOpenAIClient(backend config.Backend, apiKey string) *openai.Client {
+	oaiConfig := openai.DefaultConfig(apiKey)
+	if backend.BaseURL != "" {
+		oaiConfig.BaseURL = backend.BaseURL
+	}
+	return openai.NewClientWithConfig(oaiConfig)
+}
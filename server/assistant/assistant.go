@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
 
 	"github.com/gastrader/repotalk/types"
 	"github.com/sashabaranov/go-openai"
@@ -17,6 +18,9 @@ func CreateAssistant(client openai.Client, config types.AsstConfig) types.AsstID
 			{Type: "file_search"},
 		},
 	}
+	if len(config.Tools) > 0 {
+		AsstReq = assistantRequest(config)
+	}
 	AsstObj, err := client.CreateAssistant(context.Background(), AsstReq)
 	if err != nil {
 		log.Fatal("Could not create assistant: ", err)
@@ -25,51 +29,52 @@ func CreateAssistant(client openai.Client, config types.AsstConfig) types.AsstID
 	return types.AsstID(AsstObj.ID)
 }
 
+// LoadOrCreate reconciles the assistant named in config and exits on error.
 func LoadOrCreate(client openai.Client, config types.AsstConfig, recreate bool) types.AsstID {
-	existingAsst, err := findAsst(&client, config.Name)
+	asstID, plan, err := Reconcile(&client, config, ReconcileOptions{Recreate: recreate})
 	if err != nil {
-		log.Fatalf("Error finding assistant: %v", err)
+		log.Fatalf("Error reconciling assistant: %v", err)
 	}
-	if existingAsst != nil {
-		if recreate {
-			deleted := DeleteAsst(&client, types.AsstID(existingAsst.ID))
-			if deleted {
-				fmt.Println("Assistant deleted.")
-				asstID := CreateAssistant(client, config)
-				fmt.Println("Created assistant.")
-				return asstID
-			}
-			log.Fatal("error deleting asst")
-		}
+	if plan.Action == ActionNone {
 		fmt.Println("Assistant loaded")
-		return types.AsstID(existingAsst.ID)
 	}
-	asstID := CreateAssistant(client, config)
-	fmt.Println("Created assistant. ")
 	return asstID
 }
 
-func findAsst(client *openai.Client, name string) (*openai.Assistant, error) {
+// findAssts returns every assistant named name, most recently created first.
+func findAssts(client *openai.Client, name string) ([]openai.Assistant, error) {
 	assistants, err := listAssistants(client)
 	if err != nil {
 		return nil, err
 	}
+	var matches []openai.Assistant
 	for _, asst := range assistants {
 		if asst.Name != nil && *asst.Name == name {
-			return &asst, nil
+			matches = append(matches, asst)
 		}
 	}
-	return nil, nil
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].CreatedAt > matches[j].CreatedAt
+	})
+	return matches, nil
 }
 
 func listAssistants(client *openai.Client) ([]openai.Assistant, error) {
-	var limit *int = nil
-	var order, after, before *string
-	oaiAssts, err := client.ListAssistants(context.Background(), limit, order, after, before)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list assistants: %w", err)
+	limit := 100
+	order := "desc"
+	var after *string
+	var assistants []openai.Assistant
+	for {
+		page, err := client.ListAssistants(context.Background(), &limit, &order, after, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list assistants: %w", err)
+		}
+		assistants = append(assistants, page.Assistants...)
+		if !page.HasMore || page.LastID == nil {
+			return assistants, nil
+		}
+		after = page.LastID
 	}
-	return oaiAssts.Assistants, nil
 }
 
 func UploadInstructions(client *openai.Client, id types.AsstID, content string) {
@@ -81,6 +86,22 @@ func UploadInstructions(client *openai.Client, id types.AsstID, content string)
 	}
 }
 
+// SetInstructions replaces the assistant's instructions, keeping its model.
+func SetInstructions(client *openai.Client, id types.AsstID, content string) error {
+	asst, err := client.RetrieveAssistant(context.Background(), string(id))
+	if err != nil {
+		return fmt.Errorf("could not fetch assistant '%s': %v", id, err)
+	}
+	_, err = client.ModifyAssistant(context.Background(), string(id), openai.AssistantRequest{
+		Model:        asst.Model,
+		Instructions: &content,
+	})
+	if err != nil {
+		return fmt.Errorf("could not upload instructions to '%s': %v", id, err)
+	}
+	return nil
+}
+
 func DeleteAsst(client *openai.Client, id types.AsstID) bool {
 
 	res, err := client.DeleteAssistant(context.Background(), string(id))
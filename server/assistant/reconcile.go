@@ -0,0 +1,215 @@
+package assistant
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gastrader/repotalk/types"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	ActionNone     = "none"
+	ActionCreate   = "create"
+	ActionUpdate   = "update"
+	ActionRecreate = "recreate"
+)
+
+// Change is one field that differs between the remote assistant and its
+// configuration.
+type Change struct {
+	Field string
+	From  string
+	To    string
+}
+
+// Plan describes what Reconcile does, or would do in a dry run, to bring an
+// assistant in line with its configuration.
+type Plan struct {
+	Name        string
+	AssistantID types.AsstID
+	Action      string
+	Changes     []Change
+	// Duplicates lists other assistants with the same name. They are left
+	// untouched; only the most recently created one is reconciled.
+	Duplicates []types.AsstID
+}
+
+func (p Plan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "assistant '%s'", p.Name)
+	if p.AssistantID != "" {
+		fmt.Fprintf(&b, " (%s)", p.AssistantID)
+	}
+	fmt.Fprintf(&b, ": %s", p.Action)
+	for _, c := range p.Changes {
+		fmt.Fprintf(&b, "\n  %s: %s -> %s", c.Field, c.From, c.To)
+	}
+	for _, id := range p.Duplicates {
+		fmt.Fprintf(&b, "\n  duplicate left in place: %s", id)
+	}
+	return b.String()
+}
+
+type ReconcileOptions struct {
+	// DryRun computes and prints the plan without changing anything.
+	DryRun bool
+	// Recreate deletes and recreates an existing assistant even when it could
+	// be updated in place.
+	Recreate bool
+}
+
+// Reconcile finds the assistant named in config and makes its model, tools,
+// instructions and metadata match config, updating it in place where the API
+// allows and recreating it otherwise. In a dry run the returned ID is the
+// existing assistant's, or empty if it would be created.
+func Reconcile(client *openai.Client, config types.AsstConfig, opts ReconcileOptions) (types.AsstID, Plan, error) {
+	plan, existing, err := PlanReconcile(client, config, opts.Recreate)
+	if err != nil {
+		return "", plan, err
+	}
+	if opts.DryRun {
+		fmt.Printf("[dry run] %s\n", plan)
+		return plan.AssistantID, plan, nil
+	}
+
+	switch plan.Action {
+	case ActionNone:
+		return plan.AssistantID, plan, nil
+	case ActionUpdate:
+		_, err := client.ModifyAssistant(context.Background(), existing.ID, assistantRequest(config))
+		if err != nil {
+			return "", plan, fmt.Errorf("could not update assistant '%s': %v", config.Name, err)
+		}
+		fmt.Println(plan)
+		return plan.AssistantID, plan, nil
+	case ActionRecreate:
+		if !DeleteAsst(client, plan.AssistantID) {
+			return "", plan, fmt.Errorf("could not delete assistant '%s' before recreating it", plan.AssistantID)
+		}
+	}
+
+	asstObj, err := client.CreateAssistant(context.Background(), assistantRequest(config))
+	if err != nil {
+		return "", plan, fmt.Errorf("could not create assistant '%s': %v", config.Name, err)
+	}
+	plan.AssistantID = types.AsstID(asstObj.ID)
+	fmt.Println(plan)
+	return plan.AssistantID, plan, nil
+}
+
+// PlanReconcile compares the remote assistant named in config with config
+// without changing anything. It also returns the remote assistant, if any.
+func PlanReconcile(client *openai.Client, config types.AsstConfig, recreate bool) (Plan, *openai.Assistant, error) {
+	plan := Plan{Name: config.Name, Action: ActionCreate}
+
+	matches, err := findAssts(client, config.Name)
+	if err != nil {
+		return plan, nil, err
+	}
+	if len(matches) == 0 {
+		return plan, nil, nil
+	}
+	existing := matches[0]
+	for _, dup := range matches[1:] {
+		plan.Duplicates = append(plan.Duplicates, types.AsstID(dup.ID))
+	}
+	plan.AssistantID = types.AsstID(existing.ID)
+	plan.Changes = diffAssistant(existing, config)
+
+	switch {
+	case recreate:
+		plan.Action = ActionRecreate
+	case isLegacy(existing):
+		// v1 assistants with retrieval tools or attached files cannot be
+		// moved to file_search by a modify call.
+		plan.Action = ActionRecreate
+	case len(plan.Changes) > 0:
+		plan.Action = ActionUpdate
+	default:
+		plan.Action = ActionNone
+	}
+	return plan, &existing, nil
+}
+
+func diffAssistant(remote openai.Assistant, config types.AsstConfig) []Change {
+	var changes []Change
+	if remote.Model != config.Model {
+		changes = append(changes, Change{Field: "model", From: remote.Model, To: config.Model})
+	}
+
+	var remoteTools []string
+	for _, tool := range remote.Tools {
+		remoteTools = append(remoteTools, string(tool.Type))
+	}
+	if from, to := sortedList(remoteTools), sortedList(config.Tools); from != to {
+		changes = append(changes, Change{Field: "tools", From: from, To: to})
+	}
+
+	remoteInstructions := ""
+	if remote.Instructions != nil {
+		remoteInstructions = *remote.Instructions
+	}
+	if remoteInstructions != config.Instructions {
+		changes = append(changes, Change{
+			Field: "instructions",
+			From:  fmt.Sprintf("%d bytes", len(remoteInstructions)),
+			To:    fmt.Sprintf("%d bytes", len(config.Instructions)),
+		})
+	}
+
+	remoteMetadata := make(map[string]string, len(remote.Metadata))
+	for k, v := range remote.Metadata {
+		remoteMetadata[k] = fmt.Sprint(v)
+	}
+	if from, to := metadataString(remoteMetadata), metadataString(config.Metadata); from != to {
+		changes = append(changes, Change{Field: "metadata", From: from, To: to})
+	}
+	return changes
+}
+
+func isLegacy(asst openai.Assistant) bool {
+	if len(asst.FileIDs) > 0 {
+		return true
+	}
+	for _, tool := range asst.Tools {
+		if tool.Type == openai.AssistantToolTypeRetrieval {
+			return true
+		}
+	}
+	return false
+}
+
+func assistantRequest(config types.AsstConfig) openai.AssistantRequest {
+	tools := []openai.AssistantTool{}
+	for _, tool := range config.Tools {
+		tools = append(tools, openai.AssistantTool{Type: openai.AssistantToolType(tool)})
+	}
+	metadata := make(map[string]any, len(config.Metadata))
+	for k, v := range config.Metadata {
+		metadata[k] = v
+	}
+	return openai.AssistantRequest{
+		Model:        config.Model,
+		Name:         &config.Name,
+		Instructions: &config.Instructions,
+		Tools:        tools,
+		Metadata:     metadata,
+	}
+}
+
+func sortedList(items []string) string {
+	sorted := append([]string(nil), items...)
+	sort.Strings(sorted)
+	return "[" + strings.Join(sorted, ", ") + "]"
+}
+
+func metadataString(metadata map[string]string) string {
+	var pairs []string
+	for k, v := range metadata {
+		pairs = append(pairs, k+"="+v)
+	}
+	return sortedList(pairs)
+}
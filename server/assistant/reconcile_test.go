@@ -0,0 +1,139 @@
+package assistant
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gastrader/repotalk/types"
+	"github.com/sashabaranov/go-openai"
+)
+
+var testConfig = types.AsstConfig{
+	Name:         "repotalk",
+	Model:        "gpt-4o",
+	Tools:        []string{"file_search", "code_interpreter"},
+	Instructions: "Answer questions about the repository.",
+	Metadata:     map[string]string{"version": "2"},
+}
+
+func remoteAssistant(id string, createdAt int64) openai.Assistant {
+	name := testConfig.Name
+	instructions := testConfig.Instructions
+	return openai.Assistant{
+		ID:           id,
+		CreatedAt:    createdAt,
+		Name:         &name,
+		Model:        testConfig.Model,
+		Instructions: &instructions,
+		Tools:        []openai.AssistantTool{{Type: openai.AssistantToolTypeCodeInterpreter}, {Type: openai.AssistantToolTypeFileSearch}},
+		Metadata:     map[string]any{"version": "2"},
+	}
+}
+
+func TestDiffAssistant(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*openai.Assistant)
+		want   []Change
+	}{
+		{"in sync", func(*openai.Assistant) {}, nil},
+		{"tool order ignored", func(a *openai.Assistant) { a.Tools[0], a.Tools[1] = a.Tools[1], a.Tools[0] }, nil},
+		{"model", func(a *openai.Assistant) { a.Model = "gpt-4-turbo" }, []Change{{Field: "model", From: "gpt-4-turbo", To: "gpt-4o"}}},
+		{
+			name:   "tools",
+			modify: func(a *openai.Assistant) { a.Tools = a.Tools[1:] },
+			want:   []Change{{Field: "tools", From: "[file_search]", To: "[code_interpreter, file_search]"}},
+		},
+		{
+			name:   "instructions",
+			modify: func(a *openai.Assistant) { a.Instructions = nil },
+			want:   []Change{{Field: "instructions", From: "0 bytes", To: "38 bytes"}},
+		},
+		{
+			name:   "metadata",
+			modify: func(a *openai.Assistant) { a.Metadata = map[string]any{"version": "1"} },
+			want:   []Change{{Field: "metadata", From: "[version=1]", To: "[version=2]"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote := remoteAssistant("asst_1", 1)
+			tt.modify(&remote)
+			if got := diffAssistant(remote, testConfig); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffAssistant = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// listClient returns a client whose assistant list is assistants.
+func listClient(t *testing.T, assistants ...openai.Assistant) *openai.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/assistants" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(openai.AssistantsList{Assistants: assistants})
+	}))
+	t.Cleanup(srv.Close)
+	config := openai.DefaultConfig("test")
+	config.BaseURL = srv.URL
+	return openai.NewClientWithConfig(config)
+}
+
+func TestPlanReconcile(t *testing.T) {
+	outdated := remoteAssistant("asst_old", 1)
+	outdated.Model = "gpt-4-turbo"
+	legacy := remoteAssistant("asst_legacy", 1)
+	legacy.Tools = []openai.AssistantTool{{Type: openai.AssistantToolTypeRetrieval}}
+	withFiles := remoteAssistant("asst_files", 1)
+	withFiles.FileIDs = []string{"file-1"}
+	otherName := "other"
+	other := remoteAssistant("asst_other", 5)
+	other.Name = &otherName
+
+	tests := []struct {
+		name           string
+		remote         []openai.Assistant
+		recreate       bool
+		wantAction     string
+		wantID         types.AsstID
+		wantDuplicates []types.AsstID
+	}{
+		{"missing", []openai.Assistant{other}, false, ActionCreate, "", nil},
+		{"in sync", []openai.Assistant{remoteAssistant("asst_1", 1)}, false, ActionNone, "asst_1", nil},
+		{"in sync but forced", []openai.Assistant{remoteAssistant("asst_1", 1)}, true, ActionRecreate, "asst_1", nil},
+		{"outdated", []openai.Assistant{outdated}, false, ActionUpdate, "asst_old", nil},
+		{"retrieval tool", []openai.Assistant{legacy}, false, ActionRecreate, "asst_legacy", nil},
+		{"attached files", []openai.Assistant{withFiles}, false, ActionRecreate, "asst_files", nil},
+		{
+			name:           "newest duplicate reconciled",
+			remote:         []openai.Assistant{remoteAssistant("asst_a", 1), remoteAssistant("asst_c", 3), other, remoteAssistant("asst_b", 2)},
+			wantAction:     ActionNone,
+			wantID:         "asst_c",
+			wantDuplicates: []types.AsstID{"asst_b", "asst_a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, existing, err := PlanReconcile(listClient(t, tt.remote...), testConfig, tt.recreate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plan.Action != tt.wantAction || plan.AssistantID != tt.wantID {
+				t.Errorf("plan = %s %q, want %s %q", plan.Action, plan.AssistantID, tt.wantAction, tt.wantID)
+			}
+			if !reflect.DeepEqual(plan.Duplicates, tt.wantDuplicates) {
+				t.Errorf("duplicates = %v, want %v", plan.Duplicates, tt.wantDuplicates)
+			}
+			if (existing == nil) != (tt.wantID == "") {
+				t.Errorf("existing = %v, want one only when the assistant exists", existing)
+			}
+		})
+	}
+}
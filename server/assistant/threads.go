@@ -2,9 +2,15 @@ package assistant
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/gastrader/repotalk/types"
@@ -20,6 +26,83 @@ func CreateThread(client *openai.Client) (types.ThreadID, error) {
 	return types.ThreadID(thread.ID), nil
 }
 
+func CreateRepoThread(client *openai.Client, username, reponame, fileID string) (types.ThreadID, error) {
+	seed := fmt.Sprintf("Questions in this conversation are about the GitHub repository %s/%s. Its source code is in the attached file.", username, reponame)
+	request := openai.ThreadRequest{
+		Messages: []openai.ThreadMessage{
+			{
+				Role:    openai.ThreadMessageRoleUser,
+				Content: seed,
+				Attachments: []openai.ThreadAttachment{
+					{FileID: fileID, Tools: []openai.ThreadAttachmentTool{{Type: "file_search"}}},
+				},
+			},
+		},
+		Metadata: map[string]any{
+			"githubUser": username,
+			"repoName":   reponame,
+		},
+	}
+	thread, err := client.CreateThread(context.Background(), request)
+	if err != nil {
+		return "", fmt.Errorf("could not create thread for %s/%s: %v", username, reponame, err)
+	}
+	return types.ThreadID(thread.ID), nil
+}
+
+// CreateCorporaThread starts a thread comparing several repositories, each
+// attached under its label.
+func CreateCorporaThread(client *openai.Client, corpora []types.Corpus) (types.ThreadID, error) {
+	var b strings.Builder
+	b.WriteString("Questions in this conversation compare the following sources. Each is attached as a file whose sections are headed by file paths starting with the directory shown.\n")
+	attachments := make([]openai.ThreadAttachment, 0, len(corpora))
+	labels := make([]string, 0, len(corpora))
+	for _, c := range corpora {
+		fmt.Fprintf(&b, "- %q: the repository %s/%s, paths under %s\n", c.Label, c.Username, c.Reponame, filepath.ToSlash(c.Root))
+		attachments = append(attachments, openai.ThreadAttachment{
+			FileID: c.FileID,
+			Tools:  []openai.ThreadAttachmentTool{{Type: "file_search"}},
+		})
+		labels = append(labels, c.Label)
+	}
+	b.WriteString("Whenever you quote or describe code, say which source it comes from by its label.")
+
+	// Metadata values are limited to 512 characters; cut on a rune boundary
+	// so the value stays valid UTF-8.
+	corporaMeta := strings.Join(labels, ",")
+	if runes := []rune(corporaMeta); len(runes) > 512 {
+		corporaMeta = string(runes[:512])
+	}
+
+	request := openai.ThreadRequest{
+		Messages: []openai.ThreadMessage{
+			{
+				Role:        openai.ThreadMessageRoleUser,
+				Content:     b.String(),
+				Attachments: attachments,
+			},
+		},
+		Metadata: map[string]any{
+			"githubUser": corpora[0].Username,
+			"repoName":   corpora[0].Reponame,
+			"corpora":    corporaMeta,
+		},
+	}
+	thread, err := client.CreateThread(context.Background(), request)
+	if err != nil {
+		return "", fmt.Errorf("could not create thread for %s: %v", strings.Join(labels, ", "), err)
+	}
+	return types.ThreadID(thread.ID), nil
+}
+
+// DeleteThread deletes a thread and its messages.
+func DeleteThread(client *openai.Client, id types.ThreadID) error {
+	if _, err := client.DeleteThread(context.Background(), string(id)); err != nil {
+		return fmt.Errorf("failed to delete thread '%s': %v", id, err)
+	}
+	return nil
+}
+
 func GetThread(client *openai.Client, id types.ThreadID) (openai.Thread, error) {
 	thread, err := client.RetrieveThread(context.Background(), string(id))
 	if err != nil {
@@ -85,6 +168,130 @@ func GetFirstThreadMessage(client openai.Client, tid types.ThreadID) string {
 	return text
 }
 
+// GetRunReply returns the reply made of every message the run posted, in
+// order; a run can post several, such as code interpreter output followed
+// by an answer.
+func GetRunReply(client *openai.Client, tid types.ThreadID, runID string) (types.Reply, error) {
+	limit := 100
+	order := "asc"
+	var combined openai.Message
+	var after *string
+	for {
+		list, err := client.ListMessage(context.Background(), string(tid), &limit, &order, after, nil, &runID)
+		if err != nil {
+			return types.Reply{}, fmt.Errorf("could not retrieve messages: %v", err)
+		}
+		for _, msg := range list.Messages {
+			if msg.Role == openai.ChatMessageRoleAssistant {
+				combined.Content = append(combined.Content, msg.Content...)
+			}
+		}
+		if !list.HasMore || list.LastID == nil {
+			break
+		}
+		after = list.LastID
+	}
+	return GetReply(combined), nil
+}
+
+// GetReply returns the message text with file_search citation markers removed,
+// along with the annotations those markers referred to.
+func GetReply(msg openai.Message) types.Reply {
+	reply := types.Reply{Parts: GetParts(msg)}
+	for _, content := range msg.Content {
+		if content.Text != nil {
+			reply.Annotations = append(reply.Annotations, parseAnnotations(content.Text.Value, content.Text.Annotations)...)
+		}
+	}
+	for i := range reply.Parts {
+		for _, ann := range reply.Annotations {
+			if ann.Text != "" {
+				reply.Parts[i].Text = strings.ReplaceAll(reply.Parts[i].Text, ann.Text, "")
+			}
+		}
+	}
+
+	var texts []string
+	for _, part := range reply.Parts {
+		if part.Type == "text" || part.Type == "refusal" {
+			texts = append(texts, part.Text)
+		}
+	}
+	reply.Text = strings.Join(texts, "\n\n")
+	if reply.Text == "" {
+		reply.Text = "no message found"
+	}
+	return reply
+}
+
+type rawAnnotation struct {
+	Type         string `json:"type"`
+	Text         string `json:"text"`
+	StartIndex   int    `json:"start_index"`
+	FileCitation *struct {
+		FileID string `json:"file_id"`
+		Quote  string `json:"quote"`
+	} `json:"file_citation"`
+	FilePath *struct {
+		FileID string `json:"file_id"`
+	} `json:"file_path"`
+}
+
+// maxContextRunes bounds the answer text kept before each annotation.
+const maxContextRunes = 500
+
+// parseAnnotations reads the annotations of a text part, keeping with each
+// the paragraph of text that leads up to it.
+func parseAnnotations(text string, raw []any) []types.Annotation {
+	var annotations []types.Annotation
+	for _, item := range raw {
+		data, err := json.Marshal(item)
+		if err != nil {
+			continue
+		}
+		var ra rawAnnotation
+		if err := json.Unmarshal(data, &ra); err != nil {
+			continue
+		}
+
+		ann := types.Annotation{Type: ra.Type, Text: ra.Text, Context: annotationContext(text, ra.StartIndex, ra.Text)}
+		if ra.FileCitation != nil {
+			ann.FileID = ra.FileCitation.FileID
+			ann.Quote = ra.FileCitation.Quote
+		}
+		if ra.FilePath != nil {
+			ann.FileID = ra.FilePath.FileID
+		}
+		annotations = append(annotations, ann)
+	}
+	return annotations
+}
+
+// annotationContext returns the text of the paragraph before the marker
+// of an annotation. start is the marker's index in runes; the marker is
+// searched for if it is not there.
+func annotationContext(text string, start int, marker string) string {
+	runes := []rune(text)
+	m := []rune(marker)
+	if start < 0 || start+len(m) > len(runes) || string(runes[start:start+len(m)]) != marker {
+		i := strings.Index(text, marker)
+		if i < 0 {
+			return ""
+		}
+		start = len([]rune(text[:i]))
+	}
+
+	from := start - maxContextRunes
+	if from < 0 {
+		from = 0
+	}
+	context := string(runes[from:start])
+	if i := strings.LastIndex(context, "\n\n"); i >= 0 {
+		context = context[i+2:]
+	}
+	return context
+}
+
 func UserMsg(content string) openai.MessageRequest {
 	return openai.MessageRequest{
 		Role:    "user",
@@ -93,69 +300,215 @@ func UserMsg(content string) openai.MessageRequest {
 }
 
 func GetContent(msg openai.Message) string {
-	if len(msg.Content) > 0 {
-		firstContent := msg.Content[0]
-		if firstContent.Type == "text" && firstContent.Text != nil {
-			return firstContent.Text.Value
+	var texts []string
+	for _, part := range GetParts(msg) {
+		if part.Type == "text" || part.Type == "refusal" {
+			texts = append(texts, part.Text)
 		}
-		if firstContent.ImageFile != nil {
-			return "images not supported"
+	}
+	if len(texts) == 0 {
+		return "no message found"
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// GetParts returns every content part of a message in order.
+func GetParts(msg openai.Message) []types.ContentPart {
+	var parts []types.ContentPart
+	for _, content := range msg.Content {
+		switch {
+		case content.Type == "text" && content.Text != nil:
+			parts = append(parts, types.ContentPart{Type: "text", Text: content.Text.Value})
+		case content.Type == "image_file" && content.ImageFile != nil:
+			parts = append(parts, types.ContentPart{Type: "image_file", FileID: content.ImageFile.FileID})
+		case content.Type == "refusal":
+			text := "The assistant declined to answer."
+			if content.Text != nil && content.Text.Value != "" {
+				text = content.Text.Value
+			}
+			parts = append(parts, types.ContentPart{Type: "refusal", Text: text})
 		}
 	}
-	return "no message found"
+	return parts
 }
 
-func RunThreadMsg(client *openai.Client, asstID types.AsstID, threadID types.ThreadID, msg string) (string, error) {
+// DefaultRunTimeout is how long a run is waited for when RunOptions sets no
+// timeout.
+const DefaultRunTimeout = 10 * time.Minute
+
+// RunOptions overrides assistant settings for a single run. Zero values keep
+// the assistant's own settings.
+type RunOptions struct {
+	Model                  string
+	AdditionalInstructions string
+	// Timeout bounds how long the run is waited for before it is cancelled;
+	// zero means DefaultRunTimeout.
+	Timeout time.Duration
+}
+
+// RunThreadMsg posts msg to the thread and waits for the assistant's reply.
+// A run that fails, is cancelled, expires or times out returns an error
+// together with a Reply carrying the tokens it used, as those are billed.
+func RunThreadMsg(client *openai.Client, asstID types.AsstID, threadID types.ThreadID, msg string, opts RunOptions) (types.Reply, error) {
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultRunTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
 	userMsg := UserMsg(msg)
 
-	_, err := client.CreateMessage(context.Background(), string(threadID), userMsg)
+	_, err := client.CreateMessage(ctx, string(threadID), userMsg)
 	if err != nil {
-		return "", fmt.Errorf("could not attach message to thread: %v", err)
+		return types.Reply{}, fmt.Errorf("could not attach message to thread: %v", err)
 	}
 
 	runRequest := openai.RunRequest{
-		AssistantID: string(asstID),
+		AssistantID:            string(asstID),
+		Model:                  opts.Model,
+		AdditionalInstructions: opts.AdditionalInstructions,
 	}
-	run, err := client.CreateRun(context.Background(), string(threadID), runRequest)
+	run, err := client.CreateRun(ctx, string(threadID), runRequest)
 	if err != nil {
-		return "", fmt.Errorf("could not create run for thread: %v", err)
+		return types.Reply{}, fmt.Errorf("could not create run for thread: %v", err)
 	}
 
+	ticker := time.NewTicker(300 * time.Millisecond)
+	defer ticker.Stop()
 	for {
-		run, err := client.RetrieveRun(context.Background(), string(threadID), run.ID)
-		if err != nil {
+		select {
+		case <-ctx.Done():
+			return types.Reply{Usage: runUsage(cancelRun(client, threadID, run))}, fmt.Errorf("run did not finish within %v", timeout)
+		case <-ticker.C:
+		}
 
-			return "", fmt.Errorf("error while retrieving run: %v", err)
+		run, err = client.RetrieveRun(ctx, string(threadID), run.ID)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			return types.Reply{}, fmt.Errorf("error while retrieving run: %v", err)
 		}
 
 		switch run.Status {
-		case "completed":
-			return GetFirstThreadMessage(*client, threadID), nil
-		case "queued", "in_progress":
+		case openai.RunStatusCompleted:
+			reply, err := GetRunReply(client, threadID, run.ID)
+			if err != nil {
+				return types.Reply{Usage: runUsage(run)}, err
+			}
+			reply.Usage = runUsage(run)
+			return reply, nil
+		case openai.RunStatusQueued, openai.RunStatusInProgress, openai.RunStatusCancelling:
 		default:
-
-			return "", fmt.Errorf("error while run: %v", run.Status)
+			if run.LastError != nil {
+				return types.Reply{Usage: runUsage(run)}, fmt.Errorf("run %s: %s", run.Status, run.LastError.Message)
+			}
+			return types.Reply{Usage: runUsage(run)}, fmt.Errorf("run %s", run.Status)
 		}
-		time.Sleep(time.Millisecond * 300)
 	}
 }
 
+// cancelRun cancels a run that is taking too long and returns it as last
+// seen, with whatever usage it reports by then.
+func cancelRun(client *openai.Client, threadID types.ThreadID, run openai.Run) openai.Run {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := client.CancelRun(ctx, string(threadID), run.ID); err != nil {
+		log.Printf("Warning: Failed to cancel run %s: %v\n", run.ID, err)
+		return run
+	}
+	if latest, err := client.RetrieveRun(ctx, string(threadID), run.ID); err == nil {
+		return latest
+	}
+	return run
+}
+
+func runUsage(run openai.Run) types.Usage {
+	return types.Usage{
+		Model:            run.Model,
+		PromptTokens:     run.Usage.PromptTokens,
+		CompletionTokens: run.Usage.CompletionTokens,
+		TotalTokens:      run.Usage.TotalTokens,
+	}
+}
+
+// UploadFile uploads a file for use by assistants without attaching it to
+// one; threads attach the files they need.
+func UploadFile(client *openai.Client, filePath string) (string, error) {
+	oaFile, err := client.CreateFile(context.Background(), openai.FileRequest{
+		FilePath: filePath,
+		FileName: filepath.Base(filePath),
+		Purpose:  "assistants",
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload file '%s': %v", filePath, err)
+	}
+	return oaFile.ID, nil
+}
+
+// DeleteFile deletes an uploaded file. Threads it was attached to no longer
+// find it.
+func DeleteFile(client *openai.Client, fileID string) error {
+	if err := client.DeleteFile(context.Background(), fileID); err != nil {
+		return fmt.Errorf("failed to delete file '%s': %v", fileID, err)
+	}
+	return nil
+}
+
+// vectorStoreID returns the vector store behind the assistant's file_search
+// tool, creating and attaching one if the assistant has none.
+func vectorStoreID(client *openai.Client, asstID string) (string, error) {
+	asst, err := client.RetrieveAssistant(context.Background(), asstID)
+	if err != nil {
+		return "", fmt.Errorf("error fetching assistant: %v", err)
+	}
+	if res := asst.ToolResources; res != nil && res.FileSearch != nil && len(res.FileSearch.VectorStoreIDs) > 0 {
+		return res.FileSearch.VectorStoreIDs[0], nil
+	}
+
+	store, err := client.CreateVectorStore(context.Background(), openai.VectorStoreRequest{
+		Name: fmt.Sprintf("%s-files", asstID),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating vector store: %v", err)
+	}
+	_, err = client.ModifyAssistant(context.Background(), asstID, openai.AssistantRequest{
+		Model: asst.Model,
+		ToolResources: &openai.AssistantToolResource{
+			FileSearch: &openai.AssistantToolFileSearch{VectorStoreIDs: []string{store.ID}},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error attaching vector store: %v", err)
+	}
+	return store.ID, nil
+}
+
 func GetFilesHashMap(client *openai.Client, asstID string) (map[string]string, error) {
 	fileIDByName := make(map[string]string)
 
-	var limit *int = nil
-	var order *string = nil
-	var after *string = nil
-	var before *string = nil
-
-	asstFiles, err := client.ListAssistantFiles(context.Background(), string(asstID), limit, order, after, before)
+	storeID, err := vectorStoreID(client, asstID)
 	if err != nil {
-		return nil, fmt.Errorf("error listing assistant files: %v", err)
+		return nil, err
 	}
 
 	asstFileIDs := make(map[string]struct{})
-	for _, file := range asstFiles.AssistantFiles {
-		asstFileIDs[file.ID] = struct{}{}
+	limit := 100
+	var after *string
+	for {
+		page, err := client.ListVectorStoreFiles(context.Background(), storeID, openai.Pagination{Limit: &limit, After: after})
+		if err != nil {
+			return nil, fmt.Errorf("error listing assistant files: %v", err)
+		}
+		for _, file := range page.VectorStoreFiles {
+			asstFileIDs[file.ID] = struct{}{}
+		}
+		if !page.HasMore || page.LastID == nil {
+			break
+		}
+		after = page.LastID
 	}
 
 	orgFiles, err := client.ListFiles(context.Background())
@@ -172,34 +525,67 @@ func GetFilesHashMap(client *openai.Client, asstID string) (map[string]string, e
 	return fileIDByName, nil
 }
 
+// UploadFileByName keeps one upload of the file at filePath attached to the
+// assistant. The uploaded name carries a hash of the content, so an unchanged
+// file is skipped unless force is set and a changed one replaces the upload
+// made under the same file name.
 func UploadFileByName(client *openai.Client, asstID string, filePath string, force bool) (string, bool, error) {
-	fileName := filepath.Base(filePath)
-	fileIDByName, err := GetFilesHashMap(client, asstID)
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", false, fmt.Errorf("cannot read file '%s': %v", filePath, err)
+	}
+	sum := sha256.Sum256(data)
+	base := filepath.Base(filePath)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	fileName := fmt.Sprintf("%s.%s%s", stem, hex.EncodeToString(sum[:6]), ext)
 
+	fileIDByName, err := GetFilesHashMap(client, asstID)
 	if err != nil {
 		return "", false, fmt.Errorf("error getting files hashmap: %v", err)
 	}
-	fileID, exists := fileIDByName[filePath]
 
-	if !force && exists {
+	if fileID, exists := fileIDByName[fileName]; !force && exists {
 		fmt.Println("Existing file found.")
 		return fileID, false, nil
 	}
 
-	if exists {
+	storeID, err := vectorStoreID(client, asstID)
+	if err != nil {
+		return "", false, err
+	}
+
+	// Earlier uploads of the file, under any content hash or under the
+	// plain name used before hashes were added.
+	earlier := regexp.MustCompile(`^` + regexp.QuoteMeta(stem) + `(\.[0-9a-f]{12})?` + regexp.QuoteMeta(ext) + `$`)
+	for name, fileID := range fileIDByName {
+		if !earlier.MatchString(name) {
+			continue
+		}
 		fmt.Println("Deleting old file")
 
-		if err := client.DeleteAssistantFile(context.Background(), asstID, fileID); err != nil {
-			fmt.Printf("Can't remove assistant file '%s': %v\n", fileName, err)
+		if err := client.DeleteVectorStoreFile(context.Background(), storeID, fileID); err != nil {
+			fmt.Printf("Can't remove assistant file '%s': %v\n", name, err)
 		}
 
 		if err := client.DeleteFile(context.Background(), fileID); err != nil {
-			fmt.Printf("Can't delete file '%s': %v\n", filePath, err)
+			fmt.Printf("Can't delete file '%s': %v\n", name, err)
 		}
 	}
 
+	// The API names uploads after the file on disk.
+	tmpDir, err := os.MkdirTemp("", "repotalk-upload-")
+	if err != nil {
+		return "", false, err
+	}
+	defer os.RemoveAll(tmpDir)
+	hashedPath := filepath.Join(tmpDir, fileName)
+	if err := os.WriteFile(hashedPath, data, 0o644); err != nil {
+		return "", false, err
+	}
+
 	oaFile, err := client.CreateFile(context.Background(), openai.FileRequest{
-		FilePath: filePath,
+		FilePath: hashedPath,
 		FileName: fileName,
 		Purpose:  "assistants",
 	})
@@ -207,7 +593,7 @@ func UploadFileByName(client *openai.Client, asstID string, filePath string, for
 		return "", false, fmt.Errorf("failed to upload file '%s': %v", filePath, err)
 	}
 
-	if _, err := client.CreateAssistantFile(context.Background(), asstID, openai.AssistantFileRequest{
+	if _, err := client.CreateVectorStoreFile(context.Background(), storeID, openai.VectorStoreFileRequest{
 		FileID: oaFile.ID,
 	}); err != nil {
 		return "", false, fmt.Errorf("failed to attach file '%s' to assistant: %v", filePath, err)
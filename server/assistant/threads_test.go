@@ -0,0 +1,127 @@
+package assistant
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gastrader/repotalk/types"
+	"github.com/sashabaranov/go-openai"
+)
+
+func textContent(value string, annotations ...any) openai.MessageContent {
+	return openai.MessageContent{Type: "text", Text: &openai.MessageText{Value: value, Annotations: annotations}}
+}
+
+func citation(text string, start int, fileID, quote string) map[string]any {
+	return map[string]any{
+		"type":          "file_citation",
+		"text":          text,
+		"start_index":   start,
+		"file_citation": map[string]any{"file_id": fileID, "quote": quote},
+	}
+}
+
+func TestGetParts(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []openai.MessageContent
+		want    []types.ContentPart
+	}{
+		{"none", nil, nil},
+		{
+			name: "text and images in order",
+			content: []openai.MessageContent{
+				textContent("Here is the chart:"),
+				{Type: "image_file", ImageFile: &openai.ImageFile{FileID: "file-img"}},
+				textContent("It shows growth."),
+			},
+			want: []types.ContentPart{
+				{Type: "text", Text: "Here is the chart:"},
+				{Type: "image_file", FileID: "file-img"},
+				{Type: "text", Text: "It shows growth."},
+			},
+		},
+		{
+			name:    "refusal with text",
+			content: []openai.MessageContent{{Type: "refusal", Text: &openai.MessageText{Value: "I can't help with that."}}},
+			want:    []types.ContentPart{{Type: "refusal", Text: "I can't help with that."}},
+		},
+		{
+			name:    "refusal without text",
+			content: []openai.MessageContent{{Type: "refusal"}},
+			want:    []types.ContentPart{{Type: "refusal", Text: "The assistant declined to answer."}},
+		},
+		{
+			name: "incomplete and unknown parts skipped",
+			content: []openai.MessageContent{
+				{Type: "text"},
+				{Type: "image_file"},
+				{Type: "image_url"},
+				textContent("done"),
+			},
+			want: []types.ContentPart{{Type: "text", Text: "done"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := GetParts(openai.Message{Content: tt.content})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetParts = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  []openai.MessageContent
+		wantText string
+		wantAnns []types.Annotation
+	}{
+		{"empty message", nil, "no message found", nil},
+		{
+			name: "text parts joined around an image",
+			content: []openai.MessageContent{
+				textContent("First."),
+				{Type: "image_file", ImageFile: &openai.ImageFile{FileID: "file-img"}},
+				textContent("Second."),
+			},
+			wantText: "First.\n\nSecond.",
+		},
+		{
+			name:     "citation markers removed",
+			content:  []openai.MessageContent{textContent("Saving validates first【4:0†bundle.txt】.", citation("【4:0†bundle.txt】", 23, "file-1", "validate()"))},
+			wantText: "Saving validates first.",
+			wantAnns: []types.Annotation{{Type: "file_citation", Text: "【4:0†bundle.txt】", FileID: "file-1", Quote: "validate()", Context: "Saving validates first"}},
+		},
+		{
+			name:     "context starts at the paragraph",
+			content:  []openai.MessageContent{textContent("Intro.\n\nThe store saves【1】", citation("【1】", 24, "file-1", ""))},
+			wantText: "Intro.\n\nThe store saves",
+			wantAnns: []types.Annotation{{Type: "file_citation", Text: "【1】", FileID: "file-1", Context: "The store saves"}},
+		},
+		{
+			name:     "wrong start index falls back to searching",
+			content:  []openai.MessageContent{textContent("See here【2】", citation("【2】", 99, "file-1", ""))},
+			wantText: "See here",
+			wantAnns: []types.Annotation{{Type: "file_citation", Text: "【2】", FileID: "file-1", Context: "See here"}},
+		},
+		{
+			name:     "refusal",
+			content:  []openai.MessageContent{{Type: "refusal"}},
+			wantText: "The assistant declined to answer.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := GetReply(openai.Message{Content: tt.content})
+			if reply.Text != tt.wantText {
+				t.Errorf("text = %q, want %q", reply.Text, tt.wantText)
+			}
+			if !reflect.DeepEqual(reply.Annotations, tt.wantAnns) {
+				t.Errorf("annotations = %+v, want %+v", reply.Annotations, tt.wantAnns)
+			}
+		})
+	}
+}
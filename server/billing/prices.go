@@ -0,0 +1,75 @@
+package billing
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gastrader/repotalk/types"
+	"github.com/gastrader/repotalk/utils"
+)
+
+// Price is the USD cost per million tokens.
+type Price struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+type PriceTable map[string]Price
+
+func DefaultPrices() PriceTable {
+	return PriceTable{
+		"gpt-3.5-turbo": {Prompt: 0.50, Completion: 1.50},
+		"gpt-4o-mini":   {Prompt: 0.15, Completion: 0.60},
+		"gpt-4o":        {Prompt: 2.50, Completion: 10.00},
+		"gpt-4-turbo":   {Prompt: 10.00, Completion: 30.00},
+	}
+}
+
+// LoadPrices returns the default price table overlaid with the entries in
+// the JSON file at path, if it exists.
+func LoadPrices(path string) (PriceTable, error) {
+	prices := DefaultPrices()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return prices, nil
+	}
+
+	overrides := PriceTable{}
+	if err := utils.LoadFromJSON(path, &overrides); err != nil {
+		return nil, fmt.Errorf("cannot load prices '%s': %v", path, err)
+	}
+	for model, price := range overrides {
+		prices[model] = price
+	}
+	return prices, nil
+}
+
+// Lookup finds the price for a model, falling back to the longest configured
+// prefix so dated snapshots such as gpt-4o-2024-08-06 resolve to gpt-4o.
+func (pt PriceTable) Lookup(model string) (Price, bool) {
+	if price, ok := pt[model]; ok {
+		return price, true
+	}
+
+	best := ""
+	for name := range pt {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return Price{}, false
+	}
+	return pt[best], true
+}
+
+// Cost fills in the cost of a usage record. Unknown models cost zero.
+func (pt PriceTable) Cost(usage types.Usage) types.Usage {
+	price, ok := pt.Lookup(usage.Model)
+	if !ok {
+		fmt.Printf("No price configured for model '%s'\n", usage.Model)
+		return usage
+	}
+	usage.Cost = (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1e6
+	return usage
+}
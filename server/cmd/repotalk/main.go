@@ -0,0 +1,244 @@
+// Command repotalk talks to an assistant about a local working copy, without
+// the server or web UI.
+//
+//	repotalk init            write .Helper/config.json for the current directory
+//	repotalk sync            bundle the configured sources and upload them
+//	repotalk ask "question"  ask a question in the saved conversation
+//	repotalk repl            ask questions interactively
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/gastrader/repotalk/assistant"
+	buddy "github.com/gastrader/repotalk/helper"
+	"github.com/gastrader/repotalk/types"
+	"github.com/gastrader/repotalk/utils"
+)
+
+const usage = `usage: repotalk <command> [flags]
+
+commands:
+  init   write a config of file bundles for a directory
+  sync   upload the configured file bundles
+  ask    ask a question, e.g. repotalk ask "where is auth handled?"
+  repl   ask questions interactively
+
+Run repotalk <command> -h for the flags of a command.`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "init":
+		err = runInit(os.Args[2:])
+	case "sync":
+		err = runSync(os.Args[2:])
+	case "ask":
+		err = runAsk(os.Args[2:])
+	case "repl":
+		err = runRepl(os.Args[2:])
+	case "-h", "--help", "help":
+		fmt.Println(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n%s\n", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "repotalk: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func configPath(dir string) string {
+	return filepath.Join(dir, ".Helper", "config.json")
+}
+
+func runInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	dir := fs.String("dir", ".", "working copy to talk about")
+	name := fs.String("name", "", "assistant name (default repotalk-<directory name>)")
+	model := fs.String("model", "gpt-4o-mini", "assistant model")
+	force := fs.Bool("force", false, "overwrite an existing config")
+	fs.Parse(args)
+
+	absDir, err := filepath.Abs(*dir)
+	if err != nil {
+		return err
+	}
+	path := configPath(absDir)
+	if _, err := os.Stat(path); err == nil && !*force {
+		return fmt.Errorf("'%s' already exists; use -force to overwrite it", path)
+	}
+	if *name == "" {
+		*name = "repotalk-" + filepath.Base(absDir)
+	}
+
+	config := types.AsstConfig{
+		Name:         *name,
+		Model:        *model,
+		Tools:        []string{"file_search"},
+		Instructions: "You answer questions about the source code in the attached files. Name the files you refer to.",
+		FileBundles: []types.FileBundle{
+			{SrcDir: ".", BundleName: "code", DstExt: "md"},
+		},
+	}
+	if _, err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	if err := utils.SaveToJSON(path, config); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s. Edit its FileBundles, then run 'repotalk sync'.\n", path)
+	return nil
+}
+
+func runSync(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	dir := fs.String("dir", ".", "working copy to talk about")
+	recreate := fs.Bool("recreate", false, "upload every bundle even if unchanged")
+	fs.Parse(args)
+
+	h, err := loadHelper(*dir)
+	if err != nil {
+		return err
+	}
+	n, err := h.UploadFiles(*recreate)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d bundle(s) uploaded.\n", n)
+	return nil
+}
+
+func runAsk(args []string) error {
+	fs := flag.NewFlagSet("ask", flag.ExitOnError)
+	dir := fs.String("dir", ".", "working copy to talk about")
+	newConv := fs.Bool("new", false, "start a new conversation")
+	fs.Parse(args)
+
+	question := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if question == "" {
+		return fmt.Errorf("no question given")
+	}
+
+	h, err := loadHelper(*dir)
+	if err != nil {
+		return err
+	}
+	conv, err := h.LoadOrCreateConv(*newConv)
+	if err != nil {
+		return err
+	}
+	answer, err := h.Chat(*conv, question)
+	if err != nil {
+		return err
+	}
+	fmt.Println(answer)
+	return nil
+}
+
+func runRepl(args []string) error {
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
+	dir := fs.String("dir", ".", "working copy to talk about")
+	newConv := fs.Bool("new", false, "start a new conversation")
+	fs.Parse(args)
+
+	h, err := loadHelper(*dir)
+	if err != nil {
+		return err
+	}
+	conv, err := h.LoadOrCreateConv(*newConv)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Type a question, /sync to re-upload sources, /new for a new conversation or /quit to exit.")
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return scanner.Err()
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "":
+			continue
+		case "/quit", "/exit":
+			return nil
+		case "/new":
+			if conv, err = h.LoadOrCreateConv(true); err != nil {
+				return err
+			}
+			continue
+		case "/sync":
+			n, err := h.UploadFiles(false)
+			if err != nil {
+				fmt.Printf("Sync failed: %v\n", err)
+				continue
+			}
+			fmt.Printf("%d bundle(s) uploaded.\n", n)
+			continue
+		}
+
+		answer, err := h.Chat(*conv, line)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+		fmt.Printf("\n%s\n\n", answer)
+	}
+}
+
+// loadHelper reads the directory's config and reconciles its assistant.
+func loadHelper(dir string) (*buddy.Helper, error) {
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Warning: could not load .env file: %v\n", err)
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var config types.AsstConfig
+	if err := utils.LoadFromJSON(configPath(absDir), &config); err != nil {
+		return nil, fmt.Errorf("cannot read config, run 'repotalk init' first: %v", err)
+	}
+
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("OPENAI_API_KEY is not set")
+	}
+	oaiConfig := openai.DefaultConfig(apiKey)
+	if baseURL := os.Getenv("OPENAI_BASE_URL"); baseURL != "" {
+		oaiConfig.BaseURL = baseURL
+	}
+	client := openai.NewClientWithConfig(oaiConfig)
+
+	asstID, _, err := assistant.Reconcile(client, config, assistant.ReconcileOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &buddy.Helper{
+		Dir:       absDir,
+		OaiClient: client,
+		AsstID:    asstID,
+		Config:    config,
+	}, nil
+}
@@ -0,0 +1,460 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/gastrader/repotalk/instructions"
+	"github.com/gastrader/repotalk/limits"
+	"github.com/gastrader/repotalk/policy"
+	"github.com/gastrader/repotalk/types"
+)
+
+type Config struct {
+	Port             string    `yaml:"port"`
+	Backend          Backend   `yaml:"backend"`
+	Assistant        Assistant `yaml:"assistant"`
+	InstructionsFile string    `yaml:"instructionsFile"`
+	// InstructionsPollSeconds is how often instruction files are checked for
+	// changes; 0 disables hot reload.
+	InstructionsPollSeconds int    `yaml:"instructionsPollSeconds"`
+	DataDir                 string `yaml:"dataDir"`
+	ReposDir                string `yaml:"reposDir"`
+	BundlesDir              string `yaml:"bundlesDir"`
+	PricesFile              string `yaml:"pricesFile"`
+	AdminToken              string `yaml:"adminToken"`
+	// Personas adds to or replaces the built-in personas by name.
+	Personas     map[string]string `yaml:"personas"`
+	ClientLimits limits.Limits     `yaml:"clientLimits"`
+	RepoLimits   limits.Limits     `yaml:"repoLimits"`
+	CORS         CORS              `yaml:"cors"`
+	Sources      Sources           `yaml:"sources"`
+	Webhooks     Webhooks          `yaml:"webhooks"`
+	History      History           `yaml:"history"`
+	Redaction    Redaction         `yaml:"redaction"`
+	// Policy decides which repositories may be crawled.
+	Policy policy.Policy `yaml:"policy"`
+}
+
+type Backend struct {
+	Provider string `yaml:"provider"`
+	BaseURL  string `yaml:"baseURL"`
+	APIKey   string `yaml:"apiKey"`
+}
+
+type Assistant struct {
+	Name  string `yaml:"name"`
+	Model string `yaml:"model"`
+	// Models lists the models callers may pick per repository or per query.
+	Models []string `yaml:"models"`
+	Tools  []string `yaml:"tools"`
+	// DryRun prints the changes needed to bring existing assistants in line
+	// with this config instead of applying them.
+	DryRun bool `yaml:"dryRun"`
+}
+
+type CORS struct {
+	AllowedOrigins   []string `yaml:"allowedOrigins"`
+	AllowedMethods   []string `yaml:"allowedMethods"`
+	AllowedHeaders   []string `yaml:"allowedHeaders"`
+	AllowCredentials bool     `yaml:"allowCredentials"`
+	MaxAge           int      `yaml:"maxAge"`
+}
+
+// Sources controls crawl sources other than GitHub URLs.
+type Sources struct {
+	// LocalRoots lists the server directories crawls may read; empty
+	// disables local paths.
+	LocalRoots        []string `yaml:"localRoots"`
+	MaxUploadBytes    int64    `yaml:"maxUploadBytes"`
+	MaxExtractedBytes int64    `yaml:"maxExtractedBytes"`
+	MaxArchiveFiles   int      `yaml:"maxArchiveFiles"`
+}
+
+// Webhooks holds the shared secrets push webhooks are signed with. A receiver
+// is disabled while its secret is empty.
+type Webhooks struct {
+	GitHubSecret string `yaml:"githubSecret"`
+	GitSecret    string `yaml:"gitSecret"`
+}
+
+// History controls the git history extracted from checkouts at crawl time.
+type History struct {
+	// Commits is how many recent commits are kept; 0 disables history.
+	Commits int `yaml:"commits"`
+	// Blame adds a summary of who wrote the lines of the files changed by the
+	// kept commits, up to 200 of them. It runs git blame once per file, so
+	// it slows down crawls.
+	Blame bool `yaml:"blame"`
+}
+
+// Redaction controls how secrets in bundles are handled before upload.
+type Redaction struct {
+	// Policy is "redact" to replace secrets with placeholders, "strict" to
+	// refuse crawls that contain any, or "off".
+	Policy string `yaml:"policy"`
+}
+
+func Default() Config {
+	return Config{
+		Port:    ":8080",
+		Backend: Backend{Provider: "openai"},
+		Assistant: Assistant{
+			Name:   "repo_talk_01",
+			Model:  "gpt-3.5-turbo-1106",
+			Models: []string{"gpt-3.5-turbo-1106", "gpt-4o-mini", "gpt-4o"},
+			Tools:  []string{"file_search"},
+		},
+		InstructionsFile: "./instructions.md",
+
+		InstructionsPollSeconds: 5,
+		DataDir:                 "./data",
+		ReposDir:                "./repos",
+		BundlesDir:              "./bundles",
+		PricesFile:              "./prices.json",
+		CORS: CORS{
+			AllowedOrigins: []string{"http://localhost:3000"},
+			AllowedMethods: []string{"GET", "POST", "DELETE"},
+			AllowedHeaders: []string{"Content-Type", "Authorization", "X-API-Key"},
+			MaxAge:         600,
+		},
+		Sources: Sources{
+			MaxUploadBytes:    100 << 20,
+			MaxExtractedBytes: 500 << 20,
+			MaxArchiveFiles:   20000,
+		},
+		History:   History{Commits: 500},
+		Redaction: Redaction{Policy: "redact"},
+	}
+}
+
+// Persona returns the instructions for a named persona.
+func (c Config) Persona(name string) (string, bool) {
+	if text, ok := c.Personas[name]; ok {
+		return text, true
+	}
+	text, ok := instructions.DefaultPersonas()[name]
+	return text, ok
+}
+
+// ModelAllowed reports whether model may be requested by callers. The
+// assistant's default model is always allowed.
+func (c Config) ModelAllowed(model string) bool {
+	if model == c.Assistant.Model {
+		return true
+	}
+	for _, allowed := range c.Assistant.Models {
+		if allowed == model {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Config) AsstConfig() types.AsstConfig {
+	return types.AsstConfig{
+		Name:     c.Assistant.Name,
+		Model:    c.Assistant.Model,
+		Tools:    c.Assistant.Tools,
+		Metadata: map[string]string{"managedBy": "repotalk"},
+	}
+}
+
+// Load builds the configuration from, in increasing precedence: defaults,
+// the YAML file named by -config (repotalk.yaml if present), REPOTALK_*
+// environment variables and command-line flags.
+func Load(args []string) (Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("repotalk", flag.ContinueOnError)
+	configFile := fs.String("config", "", "path to a YAML config file (default repotalk.yaml if present)")
+	port := fs.String("port", "", "address to listen on, e.g. :8080")
+	model := fs.String("model", "", "assistant model")
+	asstName := fs.String("assistant-name", "", "assistant name")
+	dataDir := fs.String("data-dir", "", "directory for persisted state")
+	reposDir := fs.String("repos-dir", "", "directory for repository checkouts")
+	bundlesDir := fs.String("bundles-dir", "", "directory for repository bundles")
+	backend := fs.String("backend", "", "assistant backend provider")
+	baseURL := fs.String("base-url", "", "base URL of the backend API")
+	instructions := fs.String("instructions", "", "path to the assistant instructions file")
+	dryRun := fs.Bool("dry-run", false, "print planned assistant changes without applying them")
+	ints := []struct {
+		name  string
+		value *int
+		dst   *int
+	}{
+		{"client-requests-per-minute", fs.Int("client-requests-per-minute", 0, "requests per minute per client, 0 for no limit"), &cfg.ClientLimits.RequestsPerMinute},
+		{"client-daily-tokens", fs.Int("client-daily-tokens", 0, "tokens per day per client, 0 for no limit"), &cfg.ClientLimits.DailyTokens},
+		{"client-monthly-tokens", fs.Int("client-monthly-tokens", 0, "tokens per month per client, 0 for no limit"), &cfg.ClientLimits.MonthlyTokens},
+		{"repo-requests-per-minute", fs.Int("repo-requests-per-minute", 0, "requests per minute per repository, 0 for no limit"), &cfg.RepoLimits.RequestsPerMinute},
+		{"repo-daily-tokens", fs.Int("repo-daily-tokens", 0, "tokens per day per repository, 0 for no limit"), &cfg.RepoLimits.DailyTokens},
+		{"repo-monthly-tokens", fs.Int("repo-monthly-tokens", 0, "tokens per month per repository, 0 for no limit"), &cfg.RepoLimits.MonthlyTokens},
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	// Numeric flags apply only when given, so an explicit 0 overrides too.
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	path := *configFile
+	if path == "" {
+		if _, err := os.Stat("repotalk.yaml"); err == nil {
+			path = "repotalk.yaml"
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("cannot read config '%s': %v", path, err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("cannot parse config '%s': %v", path, err)
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return Config{}, err
+	}
+
+	setIf(&cfg.Port, *port)
+	setIf(&cfg.Assistant.Model, *model)
+	setIf(&cfg.Assistant.Name, *asstName)
+	setIf(&cfg.DataDir, *dataDir)
+	setIf(&cfg.ReposDir, *reposDir)
+	setIf(&cfg.BundlesDir, *bundlesDir)
+	setIf(&cfg.Backend.Provider, *backend)
+	setIf(&cfg.Backend.BaseURL, *baseURL)
+	setIf(&cfg.InstructionsFile, *instructions)
+	if *dryRun {
+		cfg.Assistant.DryRun = true
+	}
+	for _, v := range ints {
+		if set[v.name] {
+			*v.dst = *v.value
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func applyEnv(cfg *Config) error {
+	setIf(&cfg.Port, os.Getenv("REPOTALK_PORT"))
+	setIf(&cfg.Backend.Provider, os.Getenv("REPOTALK_BACKEND"))
+	setIf(&cfg.Backend.BaseURL, os.Getenv("OPENAI_BASE_URL"))
+	setIf(&cfg.Backend.APIKey, os.Getenv("OPENAI_API_KEY"))
+	setIf(&cfg.Assistant.Name, os.Getenv("REPOTALK_ASSISTANT_NAME"))
+	setIf(&cfg.Assistant.Model, os.Getenv("REPOTALK_MODEL"))
+	if v := os.Getenv("REPOTALK_MODELS"); v != "" {
+		cfg.Assistant.Models = splitList(v)
+	}
+	setIf(&cfg.InstructionsFile, os.Getenv("REPOTALK_INSTRUCTIONS_FILE"))
+	setIf(&cfg.DataDir, os.Getenv("REPOTALK_DATA_DIR"))
+	setIf(&cfg.ReposDir, os.Getenv("REPOTALK_REPOS_DIR"))
+	setIf(&cfg.BundlesDir, os.Getenv("REPOTALK_BUNDLES_DIR"))
+	setIf(&cfg.PricesFile, os.Getenv("REPOTALK_PRICES_FILE"))
+	setIf(&cfg.AdminToken, os.Getenv("ADMIN_TOKEN"))
+	setIf(&cfg.Webhooks.GitHubSecret, os.Getenv("GITHUB_WEBHOOK_SECRET"))
+	setIf(&cfg.Webhooks.GitSecret, os.Getenv("REPOTALK_WEBHOOK_SECRET"))
+	setIf(&cfg.Redaction.Policy, os.Getenv("REPOTALK_REDACTION_POLICY"))
+
+	ints := []struct {
+		name string
+		dst  *int
+	}{
+		{"CLIENT_REQUESTS_PER_MINUTE", &cfg.ClientLimits.RequestsPerMinute},
+		{"CLIENT_DAILY_TOKENS", &cfg.ClientLimits.DailyTokens},
+		{"CLIENT_MONTHLY_TOKENS", &cfg.ClientLimits.MonthlyTokens},
+		{"REPO_REQUESTS_PER_MINUTE", &cfg.RepoLimits.RequestsPerMinute},
+		{"REPO_DAILY_TOKENS", &cfg.RepoLimits.DailyTokens},
+		{"REPO_MONTHLY_TOKENS", &cfg.RepoLimits.MonthlyTokens},
+		{"CORS_MAX_AGE", &cfg.CORS.MaxAge},
+		{"REPOTALK_INSTRUCTIONS_POLL_SECONDS", &cfg.InstructionsPollSeconds},
+		{"REPOTALK_HISTORY_COMMITS", &cfg.History.Commits},
+	}
+	for _, v := range ints {
+		value := os.Getenv(v.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", v.name, err)
+		}
+		*v.dst = n
+	}
+
+	if v := os.Getenv("CORS_ALLOWED_ORIGINS"); v != "" {
+		cfg.CORS.AllowedOrigins = splitList(v)
+	}
+	if v := os.Getenv("CORS_ALLOWED_METHODS"); v != "" {
+		cfg.CORS.AllowedMethods = splitList(strings.ToUpper(v))
+	}
+	if v := os.Getenv("CORS_ALLOWED_HEADERS"); v != "" {
+		cfg.CORS.AllowedHeaders = splitList(v)
+	}
+	if v := os.Getenv("REPOTALK_LOCAL_ROOTS"); v != "" {
+		cfg.Sources.LocalRoots = splitList(v)
+	}
+	lists := []struct {
+		name string
+		dst  *[]string
+	}{
+		{"REPOTALK_POLICY_ALLOW_HOSTS", &cfg.Policy.AllowHosts},
+		{"REPOTALK_POLICY_DENY_HOSTS", &cfg.Policy.DenyHosts},
+		{"REPOTALK_POLICY_ALLOW_ORGS", &cfg.Policy.AllowOrgs},
+		{"REPOTALK_POLICY_DENY_ORGS", &cfg.Policy.DenyOrgs},
+		{"REPOTALK_POLICY_LICENSES", &cfg.Policy.Licenses},
+	}
+	for _, v := range lists {
+		if value := os.Getenv(v.name); value != "" {
+			*v.dst = splitList(value)
+		}
+	}
+	setIf(&cfg.Policy.OptInFile, os.Getenv("REPOTALK_POLICY_OPT_IN_FILE"))
+	if v := os.Getenv("REPOTALK_POLICY_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid REPOTALK_POLICY_MAX_BYTES: %v", err)
+		}
+		cfg.Policy.MaxBytes = n
+	}
+	if v := os.Getenv("REPOTALK_ASSISTANT_TOOLS"); v != "" {
+		cfg.Assistant.Tools = splitList(v)
+	}
+	if v := os.Getenv("REPOTALK_POLICY_ALLOW_UPLOADS"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid REPOTALK_POLICY_ALLOW_UPLOADS: %v", err)
+		}
+		cfg.Policy.AllowUploads = b
+	}
+	if v := os.Getenv("REPOTALK_ASSISTANT_DRY_RUN"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid REPOTALK_ASSISTANT_DRY_RUN: %v", err)
+		}
+		cfg.Assistant.DryRun = b
+	}
+	if v := os.Getenv("REPOTALK_HISTORY_BLAME"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid REPOTALK_HISTORY_BLAME: %v", err)
+		}
+		cfg.History.Blame = b
+	}
+	if v := os.Getenv("CORS_ALLOW_CREDENTIALS"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid CORS_ALLOW_CREDENTIALS: %v", err)
+		}
+		cfg.CORS.AllowCredentials = b
+	}
+	return nil
+}
+
+func (c Config) Validate() error {
+	var problems []string
+	if c.Port == "" {
+		problems = append(problems, "port is required")
+	}
+	if c.Backend.Provider != "openai" {
+		problems = append(problems, fmt.Sprintf("unsupported backend '%s'", c.Backend.Provider))
+	}
+	if c.Backend.APIKey == "" {
+		problems = append(problems, "OPENAI_API_KEY is required")
+	}
+	if c.Assistant.Name == "" || c.Assistant.Model == "" {
+		problems = append(problems, "assistant name and model are required")
+	}
+	for _, tool := range c.Assistant.Tools {
+		if tool != "file_search" && tool != "code_interpreter" {
+			problems = append(problems, fmt.Sprintf("unsupported assistant tool '%s'", tool))
+		}
+	}
+	if c.DataDir == "" || c.ReposDir == "" || c.BundlesDir == "" {
+		problems = append(problems, "dataDir, reposDir and bundlesDir are required")
+	}
+	if _, err := os.Stat(c.InstructionsFile); err != nil {
+		problems = append(problems, fmt.Sprintf("instructions file: %v", err))
+	}
+	for _, l := range []limits.Limits{c.ClientLimits, c.RepoLimits} {
+		if l.RequestsPerMinute < 0 || l.DailyTokens < 0 || l.MonthlyTokens < 0 {
+			problems = append(problems, "limits cannot be negative")
+			break
+		}
+	}
+	if c.Sources.MaxUploadBytes <= 0 || c.Sources.MaxExtractedBytes <= 0 || c.Sources.MaxArchiveFiles <= 0 {
+		problems = append(problems, "sources: upload and archive limits must be positive")
+	}
+	if c.History.Commits < 0 {
+		problems = append(problems, "history: commits cannot be negative")
+	}
+	switch c.Redaction.Policy {
+	case "off", "redact", "strict":
+	default:
+		problems = append(problems, fmt.Sprintf("redaction: unknown policy '%s'", c.Redaction.Policy))
+	}
+	if c.Policy.MaxBytes < 0 {
+		problems = append(problems, "policy: maxBytes cannot be negative")
+	}
+	if opt := c.Policy.OptInFile; opt != "" && (filepath.IsAbs(opt) || opt != filepath.Clean(opt) || strings.HasPrefix(opt, "..")) {
+		problems = append(problems, fmt.Sprintf("policy: optInFile '%s' must be a clean path relative to the repository root", opt))
+	}
+	if c.CORS.AllowCredentials {
+		for _, origin := range c.CORS.AllowedOrigins {
+			if origin == "*" {
+				problems = append(problems, "cors: allowCredentials cannot be used with origin \"*\"")
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// String renders the effective configuration as YAML with secrets redacted.
+func (c Config) String() string {
+	redacted := c
+	redacted.Backend.APIKey = redact(c.Backend.APIKey)
+	redacted.AdminToken = redact(c.AdminToken)
+	redacted.Webhooks.GitHubSecret = redact(c.Webhooks.GitHubSecret)
+	redacted.Webhooks.GitSecret = redact(c.Webhooks.GitSecret)
+
+	out, err := yaml.Marshal(redacted)
+	if err != nil {
+		return fmt.Sprintf("cannot render config: %v", err)
+	}
+	return string(out)
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "********"
+}
+
+func setIf(dst *string, value string) {
+	if value != "" {
+		*dst = value
+	}
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
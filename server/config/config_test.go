@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	settings := []struct {
+		name string
+		yaml string
+		env  string
+		flag string
+		get  func(Config) string
+		// values are the default and what the file, environment and flag
+		// set, in that order.
+		values [4]string
+	}{
+		{
+			name: "repos dir", yaml: "reposDir: %s\n", env: "REPOTALK_REPOS_DIR", flag: "-repos-dir",
+			get:    func(c Config) string { return c.ReposDir },
+			values: [4]string{"./repos", "/file/repos", "/env/repos", "/flag/repos"},
+		},
+		{
+			name: "bundles dir", yaml: "bundlesDir: %s\n", env: "REPOTALK_BUNDLES_DIR", flag: "-bundles-dir",
+			get:    func(c Config) string { return c.BundlesDir },
+			values: [4]string{"./bundles", "/file/bundles", "/env/bundles", "/flag/bundles"},
+		},
+		{
+			name: "base URL", yaml: "backend:\n  baseURL: %s\n", env: "OPENAI_BASE_URL", flag: "-base-url",
+			get:    func(c Config) string { return c.Backend.BaseURL },
+			values: [4]string{"", "http://file", "http://env", "http://flag"},
+		},
+		{
+			name: "client daily tokens", yaml: "clientLimits:\n  dailyTokens: %s\n", env: "CLIENT_DAILY_TOKENS", flag: "-client-daily-tokens",
+			get:    func(c Config) string { return strconv.Itoa(c.ClientLimits.DailyTokens) },
+			values: [4]string{"0", "100", "200", "0"},
+		},
+		{
+			name: "repo requests per minute", yaml: "repoLimits:\n  requestsPerMinute: %s\n", env: "REPO_REQUESTS_PER_MINUTE", flag: "-repo-requests-per-minute",
+			get:    func(c Config) string { return strconv.Itoa(c.RepoLimits.RequestsPerMinute) },
+			values: [4]string{"0", "10", "20", "30"},
+		},
+	}
+	layers := []struct {
+		name            string
+		file, env, flag bool
+		// want indexes the values of the layer that should win.
+		want int
+	}{
+		{"default", false, false, false, 0},
+		{"file over default", true, false, false, 1},
+		{"env over file", true, true, false, 2},
+		{"flag over env", true, true, true, 3},
+		{"flag over file", true, false, true, 3},
+	}
+
+	dir := t.TempDir()
+	instructions := filepath.Join(dir, "instructions.md")
+	if err := os.WriteFile(instructions, []byte("Answer questions.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range settings {
+		for _, l := range layers {
+			t.Run(s.name+"/"+l.name, func(t *testing.T) {
+				t.Setenv("OPENAI_API_KEY", "test")
+				t.Setenv(s.env, "")
+				args := []string{"-instructions", instructions}
+				if l.file {
+					path := filepath.Join(t.TempDir(), "repotalk.yaml")
+					if err := os.WriteFile(path, []byte(fmt.Sprintf(s.yaml, s.values[1])), 0644); err != nil {
+						t.Fatal(err)
+					}
+					args = append(args, "-config", path)
+				}
+				if l.env {
+					t.Setenv(s.env, s.values[2])
+				}
+				if l.flag {
+					args = append(args, s.flag, s.values[3])
+				}
+
+				cfg, err := Load(args)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := s.get(cfg), s.values[l.want]; got != want {
+					t.Errorf("%s = %q, want %q", s.name, got, want)
+				}
+			})
+		}
+	}
+}
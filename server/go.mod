@@ -3,6 +3,7 @@ module github.com/gastrader/repotalk
 go 1.20
 
 require (
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/sashabaranov/go-openai v1.36.0 // indirect
+	github.com/joho/godotenv v1.5.1
+	github.com/sashabaranov/go-openai v1.36.0
+	gopkg.in/yaml.v3 v3.0.1
 )
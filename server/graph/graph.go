@@ -0,0 +1,313 @@
+// Package graph builds import graphs between the packages and modules of a
+// crawled repository from its symbol table.
+package graph
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+// SummaryName is the bundle section holding the graph summary.
+const SummaryName = "REPOTALK_GRAPH.txt"
+
+// maxSummaryNodes bounds the summary appended to bundles of large
+// repositories.
+const maxSummaryNodes = 500
+
+type builder struct {
+	nodes map[string]*types.GraphNode
+	edges map[[2]string]int
+	// known holds internal node IDs, for resolving Python and Java imports.
+	known map[string]bool
+}
+
+// Build derives the import graph of table. Nodes are Go packages, Java
+// packages, Python modules and TypeScript/JavaScript directories, with "."
+// for the repository root.
+func Build(table types.SymbolTable) types.DepGraph {
+	b := &builder{
+		nodes: make(map[string]*types.GraphNode),
+		edges: make(map[[2]string]int),
+		known: make(map[string]bool),
+	}
+
+	for _, file := range table.Files {
+		id := nodeID(file)
+		b.known[id] = true
+		b.node(id, file.Language, true).Files++
+	}
+
+	for _, file := range table.Files {
+		from := nodeID(file)
+		for _, imp := range file.Imports {
+			to, internal := b.resolve(file, imp.Path)
+			if to == "" || to == from {
+				continue
+			}
+			b.node(to, file.Language, internal)
+			b.edges[[2]string{from, to}]++
+		}
+	}
+	return b.graph()
+}
+
+// nodeID is the node a file belongs to.
+func nodeID(file types.FileSymbols) string {
+	switch file.Language {
+	case "Go":
+		if file.ImportPath != "" {
+			return file.ImportPath
+		}
+		return dir(file.Path)
+	case "Java":
+		if file.Package != "" {
+			return file.Package
+		}
+		return dir(file.Path)
+	case "Python":
+		return file.Package
+	default:
+		return dir(file.Path)
+	}
+}
+
+// resolve maps an import in file to a node, reporting whether the node is
+// part of the repository.
+func (b *builder) resolve(file types.FileSymbols, imp string) (string, bool) {
+	switch file.Language {
+	case "Go":
+		return imp, b.known[imp]
+	case "Java":
+		pkg := strings.TrimSuffix(imp, ".*")
+		// Drop class names, which start with an upper-case letter.
+		for {
+			i := strings.LastIndex(pkg, ".")
+			if i < 0 || !isUpper(pkg[i+1:]) {
+				break
+			}
+			pkg = pkg[:i]
+		}
+		return pkg, b.known[pkg]
+	case "Python":
+		module := imp
+		if strings.HasPrefix(imp, ".") {
+			module = relativeModule(file, imp)
+		}
+		// "import a.b.c" may name a module or a symbol in one.
+		for m := module; m != ""; m = parentModule(m) {
+			if b.known[m] {
+				return m, true
+			}
+		}
+		return strings.Split(module, ".")[0], false
+	default:
+		if strings.HasPrefix(imp, ".") {
+			target := path.Join(dir(file.Path), imp)
+			if b.known[target] {
+				return target, true
+			}
+			// "./util" usually names a file, so its directory is the node.
+			return dir(target), b.known[dir(target)]
+		}
+		return packageName(imp), false
+	}
+}
+
+func (b *builder) node(id, language string, internal bool) *types.GraphNode {
+	n, ok := b.nodes[id]
+	if !ok {
+		n = &types.GraphNode{ID: id, Language: language, Internal: internal}
+		b.nodes[id] = n
+	}
+	return n
+}
+
+func (b *builder) graph() types.DepGraph {
+	g := types.DepGraph{Nodes: []types.GraphNode{}, Edges: []types.GraphEdge{}}
+	for key, count := range b.edges {
+		from, to := b.nodes[key[0]], b.nodes[key[1]]
+		from.Dependencies = append(from.Dependencies, to.ID)
+		to.Dependents = append(to.Dependents, from.ID)
+		g.Edges = append(g.Edges, types.GraphEdge{From: key[0], To: key[1], Imports: count})
+	}
+	for _, n := range b.nodes {
+		sort.Strings(n.Dependencies)
+		sort.Strings(n.Dependents)
+		g.Nodes = append(g.Nodes, *n)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		return g.Edges[i].To < g.Edges[j].To
+	})
+	return g
+}
+
+// Filter keeps the nodes in ids and the edges between them. With internal
+// set, external dependencies are dropped as well.
+func Filter(g types.DepGraph, internal bool, ids ...string) types.DepGraph {
+	keep := make(map[string]bool)
+	for _, n := range g.Nodes {
+		if internal && !n.Internal {
+			continue
+		}
+		keep[n.ID] = len(ids) == 0
+	}
+	for _, id := range ids {
+		if _, ok := keep[id]; ok {
+			keep[id] = true
+		}
+	}
+
+	out := types.DepGraph{Nodes: []types.GraphNode{}, Edges: []types.GraphEdge{}}
+	for _, n := range g.Nodes {
+		if keep[n.ID] {
+			n.Dependencies = kept(n.Dependencies, keep)
+			n.Dependents = kept(n.Dependents, keep)
+			out.Nodes = append(out.Nodes, n)
+		}
+	}
+	for _, e := range g.Edges {
+		if keep[e.From] && keep[e.To] {
+			out.Edges = append(out.Edges, e)
+		}
+	}
+	return out
+}
+
+// Neighbourhood returns id with its direct dependencies and dependents.
+func Neighbourhood(g types.DepGraph, id string) ([]string, bool) {
+	for _, n := range g.Nodes {
+		if n.ID == id {
+			ids := append([]string{id}, n.Dependencies...)
+			return append(ids, n.Dependents...), true
+		}
+	}
+	return nil, false
+}
+
+// DOT renders the graph in Graphviz format. External nodes are dashed.
+func DOT(g types.DepGraph) string {
+	var b strings.Builder
+	b.WriteString("digraph imports {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	for _, n := range g.Nodes {
+		style := ""
+		if !n.Internal {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "\t%q [label=%q%s];\n", n.ID, n.ID, style)
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "\t%q -> %q;\n", e.From, e.To)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Summary describes the internal packages and what they import and are
+// imported by, for inclusion in the bundle.
+func Summary(g types.DepGraph) string {
+	internal := make(map[string]bool)
+	for _, n := range g.Nodes {
+		internal[n.ID] = n.Internal
+	}
+
+	var b strings.Builder
+	b.WriteString("Import graph: each internal package or module, what it imports and what imports it.\n")
+	count := 0
+	for _, n := range g.Nodes {
+		if !n.Internal {
+			continue
+		}
+		if count == maxSummaryNodes {
+			fmt.Fprintf(&b, "(graph truncated after %d packages)\n", maxSummaryNodes)
+			break
+		}
+		count++
+
+		fmt.Fprintf(&b, "%s (%s, %d files)\n", n.ID, n.Language, n.Files)
+		var deps, external []string
+		for _, dep := range n.Dependencies {
+			if internal[dep] {
+				deps = append(deps, dep)
+			} else {
+				external = append(external, dep)
+			}
+		}
+		if len(deps) > 0 {
+			fmt.Fprintf(&b, "  imports: %s\n", strings.Join(deps, ", "))
+		}
+		if len(external) > 0 {
+			fmt.Fprintf(&b, "  external: %s\n", strings.Join(external, ", "))
+		}
+		if len(n.Dependents) > 0 {
+			fmt.Fprintf(&b, "  imported by: %s\n", strings.Join(n.Dependents, ", "))
+		}
+	}
+	return b.String()
+}
+
+func kept(ids []string, keep map[string]bool) []string {
+	out := []string{}
+	for _, id := range ids {
+		if keep[id] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func dir(p string) string {
+	return path.Dir(p)
+}
+
+func isUpper(s string) bool {
+	return s != "" && s[0] >= 'A' && s[0] <= 'Z'
+}
+
+func parentModule(m string) string {
+	i := strings.LastIndex(m, ".")
+	if i < 0 {
+		return ""
+	}
+	return m[:i]
+}
+
+// relativeModule resolves a relative Python import such as "..util" against
+// the module containing file.
+func relativeModule(file types.FileSymbols, imp string) string {
+	dots := len(imp) - len(strings.TrimLeft(imp, "."))
+	base := file.Package
+	// A package's __init__ is the package itself; other modules sit in one.
+	if !strings.HasSuffix(file.Path, "__init__.py") {
+		base = parentModule(base)
+	}
+	for i := 1; i < dots; i++ {
+		base = parentModule(base)
+	}
+	rest := imp[dots:]
+	switch {
+	case base == "":
+		return rest
+	case rest == "":
+		return base
+	default:
+		return base + "." + rest
+	}
+}
+
+// packageName reduces a bare JavaScript import to its npm package, keeping
+// the scope of scoped packages.
+func packageName(imp string) string {
+	parts := strings.Split(imp, "/")
+	if strings.HasPrefix(imp, "@") && len(parts) > 1 {
+		return parts[0] + "/" + parts[1]
+	}
+	return parts[0]
+}
@@ -0,0 +1,182 @@
+package graph
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+func imports(paths ...string) []types.Import {
+	var imps []types.Import
+	for i, p := range paths {
+		imps = append(imps, types.Import{Path: p, Line: i + 1})
+	}
+	return imps
+}
+
+var testTable = types.SymbolTable{Files: []types.FileSymbols{
+	{Path: "main.go", Language: "Go", ImportPath: "example.com/app", Imports: imports("example.com/app/store", "fmt")},
+	{Path: "store/store.go", Language: "Go", ImportPath: "example.com/app/store", Imports: imports("os")},
+	{Path: "store/cache.go", Language: "Go", ImportPath: "example.com/app/store", Imports: imports("os", "example.com/app/store")},
+	{Path: "web/app.ts", Language: "TypeScript", Imports: imports("./components/Button", "react", "@tanstack/query/core")},
+	{Path: "web/components/Button.tsx", Language: "TypeScript", Imports: imports("../util", "react-dom/client")},
+	{Path: "web/util/index.ts", Language: "TypeScript"},
+	{Path: "pkg/models/__init__.py", Language: "Python", Package: "pkg.models", Imports: imports(".user")},
+	{Path: "pkg/models/user.py", Language: "Python", Package: "pkg.models.user", Imports: imports("..util", "os.path")},
+	{Path: "pkg/util.py", Language: "Python", Package: "pkg.util", Imports: imports("pkg.models.user.User")},
+	{Path: "src/App.java", Language: "Java", Package: "com.acme", Imports: imports("com.acme.model.User", "com.acme.model.*", "java.util.List")},
+	{Path: "src/model/User.java", Language: "Java", Package: "com.acme.model"},
+}}
+
+func TestBuildEdges(t *testing.T) {
+	g := Build(testTable)
+
+	var edges []string
+	for _, e := range g.Edges {
+		edges = append(edges, e.From+" -> "+e.To)
+	}
+	want := []string{
+		"com.acme -> com.acme.model",
+		"com.acme -> java.util",
+		"example.com/app -> example.com/app/store",
+		"example.com/app -> fmt",
+		"example.com/app/store -> os",
+		"pkg.models -> pkg.models.user",
+		"pkg.models.user -> os",
+		"pkg.models.user -> pkg.util",
+		"pkg.util -> pkg.models.user",
+		"web -> @tanstack/query",
+		"web -> react",
+		"web -> web/components",
+		"web/components -> react-dom",
+		"web/components -> web/util",
+	}
+	if !reflect.DeepEqual(edges, want) {
+		t.Errorf("edges = %q\nwant %q", edges, want)
+	}
+
+	for _, e := range g.Edges {
+		if e.From == "com.acme" && e.To == "com.acme.model" && e.Imports != 2 {
+			t.Errorf("com.acme -> com.acme.model counts %d imports, want 2", e.Imports)
+		}
+	}
+}
+
+func TestBuildNodes(t *testing.T) {
+	g := Build(testTable)
+	nodes := make(map[string]types.GraphNode)
+	for _, n := range g.Nodes {
+		nodes[n.ID] = n
+	}
+
+	tests := []struct {
+		id       string
+		internal bool
+		files    int
+	}{
+		{"example.com/app", true, 1},
+		{"example.com/app/store", true, 2},
+		{"fmt", false, 0},
+		{"web", true, 1},
+		{"web/components", true, 1},
+		{"react", false, 0},
+		{"pkg.models", true, 1},
+		{"pkg.util", true, 1},
+		{"os", false, 0},
+		{"com.acme.model", true, 1},
+		{"java.util", false, 0},
+	}
+	for _, tt := range tests {
+		n, ok := nodes[tt.id]
+		if !ok {
+			t.Errorf("no node %q", tt.id)
+			continue
+		}
+		if n.Internal != tt.internal || n.Files != tt.files {
+			t.Errorf("node %q internal %v files %d, want %v and %d", tt.id, n.Internal, n.Files, tt.internal, tt.files)
+		}
+	}
+
+	store := nodes["example.com/app/store"]
+	if !reflect.DeepEqual(store.Dependencies, []string{"os"}) || !reflect.DeepEqual(store.Dependents, []string{"example.com/app"}) {
+		t.Errorf("store dependencies %q, dependents %q", store.Dependencies, store.Dependents)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	g := Build(testTable)
+
+	ids, ok := Neighbourhood(g, "example.com/app/store")
+	if !ok {
+		t.Fatal("store not found")
+	}
+	if want := []string{"example.com/app/store", "os", "example.com/app"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Neighbourhood = %q, want %q", ids, want)
+	}
+	if _, ok := Neighbourhood(g, "missing"); ok {
+		t.Error("Neighbourhood found a missing node")
+	}
+
+	tests := []struct {
+		name      string
+		internal  bool
+		ids       []string
+		wantNodes int
+		wantEdges int
+	}{
+		{"everything", false, nil, len(g.Nodes), len(g.Edges)},
+		{"internal only", true, nil, 10, 7},
+		{"neighbourhood", false, ids, 3, 2},
+		{"internal neighbourhood", true, ids, 2, 1},
+		{"unknown ids ignored", false, []string{"missing", "fmt"}, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Filter(g, tt.internal, tt.ids...)
+			if len(got.Nodes) != tt.wantNodes || len(got.Edges) != tt.wantEdges {
+				t.Errorf("Filter kept %d nodes and %d edges, want %d and %d", len(got.Nodes), len(got.Edges), tt.wantNodes, tt.wantEdges)
+			}
+		})
+	}
+}
+
+func TestSummary(t *testing.T) {
+	g := Build(types.SymbolTable{Files: testTable.Files[:3]})
+	want := "Import graph: each internal package or module, what it imports and what imports it.\n" +
+		"example.com/app (Go, 1 files)\n" +
+		"  imports: example.com/app/store\n" +
+		"  external: fmt\n" +
+		"example.com/app/store (Go, 2 files)\n" +
+		"  external: os\n" +
+		"  imported by: example.com/app\n"
+	if got := Summary(g); got != want {
+		t.Errorf("Summary =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSummaryTruncated(t *testing.T) {
+	var table types.SymbolTable
+	for i := 0; i < maxSummaryNodes+1; i++ {
+		table.Files = append(table.Files, types.FileSymbols{Path: strings.Repeat("d/", i+1) + "a.ts", Language: "TypeScript"})
+	}
+	summary := Summary(Build(table))
+	if n := strings.Count(summary, "(TypeScript, 1 files)"); n != maxSummaryNodes {
+		t.Errorf("summary lists %d packages, want %d", n, maxSummaryNodes)
+	}
+	if !strings.HasSuffix(summary, "(graph truncated after 500 packages)\n") {
+		t.Error("summary does not say it was truncated")
+	}
+}
+
+func TestDOT(t *testing.T) {
+	g := Build(types.SymbolTable{Files: testTable.Files[1:2]})
+	want := "digraph imports {\n\trankdir=LR;\n\tnode [shape=box];\n" +
+		"\t\"example.com/app/store\" [label=\"example.com/app/store\"];\n" +
+		"\t\"os\" [label=\"os\", style=dashed];\n" +
+		"\t\"example.com/app/store\" -> \"os\";\n}\n"
+	if got := DOT(g); got != want {
+		t.Errorf("DOT =\n%s\nwant\n%s", got, want)
+	}
+}
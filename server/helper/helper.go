@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/gastrader/repotalk/assistant"
+	"github.com/gastrader/repotalk/redact"
 	"github.com/gastrader/repotalk/types"
 	"github.com/gastrader/repotalk/utils"
 	"github.com/sashabaranov/go-openai"
@@ -85,11 +86,11 @@ func (h *Helper) LoadOrCreateConv(recreate bool) (*Conv, error) {
 }
 
 func (h *Helper) Chat(conv Conv, msg string) (string, error) {
-	res, err := assistant.RunThreadMsg(h.OaiClient, h.AsstID, conv.Thread_ID, msg)
+	res, err := assistant.RunThreadMsg(h.OaiClient, h.AsstID, conv.Thread_ID, msg, assistant.RunOptions{})
 	if err != nil {
 		return "", fmt.Errorf("failed to chat: %v", err)
 	}
-	return res, nil
+	return res.Text, nil
 }
 
 func (h *Helper) UploadFiles(recreate bool) (int, error) {
@@ -127,6 +128,13 @@ func (h *Helper) UploadFiles(recreate bool) (int, error) {
 				bundleFileName := fmt.Sprintf("%s-bundle-%s.%s", bundle.BundleName, h.AsstID, bundle.DstExt)
 				bundleFile := filepath.Join(dataFilesDir, bundleFileName)
 				utils.BundleToFile(files, bundleFile)
+				report, err := redact.Bundle(bundleFile, srcDir)
+				if err != nil {
+					return 0, err
+				}
+				if report.Redacted > 0 {
+					fmt.Printf("Redacted %s\n", redact.Summary(report))
+				}
 				forceReupload := recreate
 
 				_, uploaded, err := assistant.UploadFileByName(h.OaiClient, string(h.AsstID), bundleFile, forceReupload)
@@ -0,0 +1,446 @@
+// Package history extracts a checkout's git history at crawl time: its
+// recent commits with the files they changed, who last modified each file
+// and, optionally, a blame summary per file.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gastrader/repotalk/types"
+	"github.com/gastrader/repotalk/utils"
+)
+
+// FileName is the history kept next to a repository's bundle.
+const FileName = "history.json"
+
+// SectionName is the bundle section holding the rendered history.
+const SectionName = "REPOTALK_HISTORY.txt"
+
+// maxSectionLines bounds each part of the history appended to bundles of
+// busy repositories.
+const maxSectionLines = 5000
+
+// maxBlameAuthors is how many authors are kept per file's blame summary.
+const maxBlameAuthors = 5
+
+// maxBlameFiles bounds how many files are blamed per crawl, as each runs
+// git blame once.
+const maxBlameFiles = 200
+
+// maxScopeCommits bounds the commits described to the assistant for a
+// scoped question.
+const maxScopeCommits = 200
+
+type Options struct {
+	// Commits is how many of the most recent commits are kept.
+	Commits int
+	// Blame adds a per-file summary of which authors wrote its lines to
+	// the files changed by the kept commits, most recently changed first and
+	// at most maxBlameFiles of them.
+	Blame bool
+	// Paths limits the history to commits touching these directories.
+	Paths []string
+}
+
+// Collect reads the history of the git checkout at dir. Paths are relative
+// to dir, and only commits touching dir are included, so a directory inside
+// a larger repository gets its own history. files are the bundled files
+// whose last change is looked up.
+func Collect(dir string, files []string, opts Options) (types.RepoHistory, error) {
+	pathspecs := []string{"."}
+	if len(opts.Paths) > 0 {
+		pathspecs = opts.Paths
+	}
+
+	commits, err := logCommits(dir, opts.Commits, pathspecs)
+	if err != nil {
+		return types.RepoHistory{}, err
+	}
+
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			continue
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	sort.Strings(paths)
+
+	last, err := lastModified(dir, paths, pathspecs)
+	if err != nil {
+		return types.RepoHistory{}, err
+	}
+
+	var blamed map[string]bool
+	if opts.Blame {
+		blamed = blameFiles(commits, last)
+	}
+
+	hist := types.RepoHistory{Commits: commits, Files: []types.FileHistory{}}
+	for _, path := range paths {
+		fh, ok := last[path]
+		if !ok {
+			// Untracked files have no history.
+			continue
+		}
+		if blamed[path] {
+			fh.Authors = blame(dir, path)
+		}
+		hist.Files = append(hist.Files, fh)
+	}
+	return hist, nil
+}
+
+// blameFiles picks the bundled files to blame: those changed by commits,
+// newest first, up to maxBlameFiles.
+func blameFiles(commits []types.Commit, bundled map[string]types.FileHistory) map[string]bool {
+	files := make(map[string]bool)
+	for _, c := range commits {
+		for _, f := range c.Files {
+			if len(files) == maxBlameFiles {
+				return files
+			}
+			if _, ok := bundled[f.Path]; ok {
+				files[f.Path] = true
+			}
+		}
+	}
+	return files
+}
+
+func logCommits(dir string, n int, pathspecs []string) ([]types.Commit, error) {
+	args := []string{"--literal-pathspecs", "-C", dir, "log", "-n", strconv.Itoa(n), "--no-renames", "--relative", "--numstat",
+		"--format=%x1e%H%x1f%an%x1f%ae%x1f%aI%x1f%s%x1f%b%x1f", "--"}
+	out, err := exec.Command("git", append(args, pathspecs...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read git log: %v", err)
+	}
+
+	commits := []types.Commit{}
+	for _, record := range strings.Split(string(out), "\x1e") {
+		fields := strings.Split(record, "\x1f")
+		if len(fields) != 7 {
+			continue
+		}
+		c := types.Commit{
+			Hash:    fields[0],
+			Author:  fields[1],
+			Email:   fields[2],
+			Date:    fields[3],
+			Subject: fields[4],
+			Body:    strings.TrimSpace(fields[5]),
+		}
+		for _, line := range strings.Split(fields[6], "\n") {
+			parts := strings.SplitN(line, "\t", 3)
+			if len(parts) != 3 {
+				continue
+			}
+			c.Files = append(c.Files, types.CommitFile{
+				Path:    parts[2],
+				Added:   numstat(parts[0]),
+				Deleted: numstat(parts[1]),
+			})
+		}
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
+
+// numstat parses a --numstat count, which is "-" for binary files.
+func numstat(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// lastModified walks the log from HEAD until every path has been seen, so
+// files untouched for a long time cost a longer walk rather than a git
+// invocation each.
+func lastModified(dir string, paths, pathspecs []string) (map[string]types.FileHistory, error) {
+	wanted := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		wanted[path] = true
+	}
+	found := make(map[string]types.FileHistory, len(paths))
+
+	args := []string{"--literal-pathspecs", "-C", dir, "log", "--no-renames", "--relative", "--name-only",
+		"--format=%x1e%H%x1f%an%x1f%aI", "--"}
+	cmd := exec.Command("git", append(args, pathspecs...)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read git log: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("cannot read git log: %v", err)
+	}
+
+	var current types.FileHistory
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for len(found) < len(wanted) && scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "\x1e") {
+			fields := strings.Split(line[1:], "\x1f")
+			if len(fields) == 3 {
+				current = types.FileHistory{LastCommit: fields[0], LastAuthor: fields[1], LastModified: fields[2]}
+			}
+			continue
+		}
+		if line == "" || !wanted[line] {
+			continue
+		}
+		if _, ok := found[line]; !ok {
+			fh := current
+			fh.Path = line
+			found[line] = fh
+		}
+	}
+
+	if len(found) < len(wanted) {
+		if err := cmd.Wait(); err != nil {
+			return nil, fmt.Errorf("cannot read git log: %v", err)
+		}
+		return found, nil
+	}
+	// Every path was found; the rest of the log is not needed.
+	cmd.Process.Kill()
+	cmd.Wait()
+	return found, nil
+}
+
+// blame summarizes who wrote path's current lines, most lines first.
+// Files git cannot blame get no summary.
+func blame(dir, path string) []types.AuthorLines {
+	out, err := exec.Command("git", "-C", dir, "blame", "--line-porcelain", "--", path).Output()
+	if err != nil {
+		return nil
+	}
+
+	lines := make(map[string]int)
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		if author, ok := bytes.CutPrefix(line, []byte("author ")); ok {
+			lines[string(author)]++
+		}
+	}
+
+	authors := make([]types.AuthorLines, 0, len(lines))
+	for author, n := range lines {
+		authors = append(authors, types.AuthorLines{Author: author, Lines: n})
+	}
+	sort.Slice(authors, func(i, j int) bool {
+		if authors[i].Lines != authors[j].Lines {
+			return authors[i].Lines > authors[j].Lines
+		}
+		return authors[i].Author < authors[j].Author
+	})
+	if len(authors) > maxBlameAuthors {
+		authors = authors[:maxBlameAuthors]
+	}
+	return authors
+}
+
+func Path(bundlePath string) string {
+	return filepath.Join(filepath.Dir(bundlePath), FileName)
+}
+
+func Save(bundlePath string, hist types.RepoHistory) error {
+	return utils.SaveToJSON(Path(bundlePath), hist)
+}
+
+// Remove drops the history kept for a bundle, if any.
+func Remove(bundlePath string) error {
+	if err := os.Remove(Path(bundlePath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func Load(bundlePath string) (types.RepoHistory, error) {
+	var hist types.RepoHistory
+	if err := utils.LoadFromJSON(Path(bundlePath), &hist); err != nil {
+		return types.RepoHistory{}, err
+	}
+	return hist, nil
+}
+
+// Render formats the history for inclusion in the bundle:
+//
+//	a1b2c3d4 2024-05-01 Jane Doe: Fix retry loop
+//	  api/client.go +12 -3
+//
+// followed by the last change to each file.
+func Render(hist types.RepoHistory) string {
+	var b strings.Builder
+	b.WriteString("Git history: the most recent commits, newest first, as hash date author: subject, each followed by the files it changed.\n")
+	lines := 1
+	for _, c := range hist.Commits {
+		if lines >= maxSectionLines {
+			b.WriteString("... (truncated)\n")
+			break
+		}
+		lines += writeCommit(&b, c)
+	}
+
+	b.WriteString("\nLast change to each file, as path hash date author")
+	if len(hist.Files) > 0 && hist.Files[0].Authors != nil {
+		b.WriteString(", followed by who wrote its current lines")
+	}
+	b.WriteString(".\n")
+	lines = 1
+	for _, fh := range hist.Files {
+		if lines >= maxSectionLines {
+			b.WriteString("... (truncated)\n")
+			break
+		}
+		fmt.Fprintf(&b, "%s %s %s %s", fh.Path, short(fh.LastCommit), day(fh.LastModified), fh.LastAuthor)
+		if len(fh.Authors) > 0 {
+			authors := make([]string, len(fh.Authors))
+			for i, a := range fh.Authors {
+				authors[i] = fmt.Sprintf("%s %d", a.Author, a.Lines)
+			}
+			fmt.Fprintf(&b, " (lines: %s)", strings.Join(authors, ", "))
+		}
+		b.WriteString("\n")
+		lines++
+	}
+	return b.String()
+}
+
+// Scope describes commits to the assistant for a question about a commit
+// range.
+func Scope(r types.CommitRange, commits []types.Commit) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "This question is about the commits %s. Base the answer on these commits and the files they changed, listed newest first:\n", describeRange(r))
+	for i, c := range commits {
+		if i == maxScopeCommits {
+			fmt.Fprintf(&b, "... and %d older commits\n", len(commits)-i)
+			break
+		}
+		writeCommit(&b, c)
+		if c.Body != "" {
+			for _, line := range strings.Split(c.Body, "\n") {
+				fmt.Fprintf(&b, "  | %s\n", line)
+			}
+		}
+	}
+	return b.String()
+}
+
+func describeRange(r types.CommitRange) string {
+	switch {
+	case r.From != "" && r.To != "":
+		return fmt.Sprintf("after %s up to %s", r.From, r.To)
+	case r.From != "":
+		return fmt.Sprintf("after %s", r.From)
+	case r.To != "":
+		return fmt.Sprintf("up to %s", r.To)
+	}
+	return "in the crawled history"
+}
+
+func writeCommit(b *strings.Builder, c types.Commit) int {
+	fmt.Fprintf(b, "%s %s %s: %s\n", short(c.Hash), day(c.Date), c.Author, c.Subject)
+	for _, f := range c.Files {
+		if f.Added < 0 {
+			fmt.Fprintf(b, "  %s (binary)\n", f.Path)
+		} else {
+			fmt.Fprintf(b, "  %s +%d -%d\n", f.Path, f.Added, f.Deleted)
+		}
+	}
+	return 1 + len(c.Files)
+}
+
+// Range returns the commits in r, newest first. A hash end follows git's
+// from..to: From itself is excluded and To included. A date end is
+// inclusive, so {From: "2024-05-01", To: "2024-05-07"} covers that week.
+func Range(hist types.RepoHistory, r types.CommitRange) ([]types.Commit, error) {
+	commits := hist.Commits
+	lo, hi := 0, len(commits)
+
+	if r.To != "" {
+		i, err := boundary(commits, r.To, true)
+		if err != nil {
+			return nil, err
+		}
+		lo = i
+	}
+	if r.From != "" {
+		i, err := boundary(commits, r.From, false)
+		if err != nil {
+			return nil, err
+		}
+		hi = i
+	}
+	if lo >= hi {
+		return []types.Commit{}, nil
+	}
+	return commits[lo:hi], nil
+}
+
+// boundary returns the index of the newest commit at or before ref. For a
+// hash that is the commit itself; for a date it is the first commit made
+// by the end of that day (isEnd) or before its start.
+func boundary(commits []types.Commit, ref string, isEnd bool) (int, error) {
+	if t, ok := parseDate(ref, isEnd); ok {
+		for i, c := range commits {
+			at, err := time.Parse(time.RFC3339, c.Date)
+			if err != nil {
+				continue
+			}
+			if (isEnd && !at.After(t)) || (!isEnd && at.Before(t)) {
+				return i, nil
+			}
+		}
+		return len(commits), nil
+	}
+
+	if len(ref) < 4 {
+		return 0, fmt.Errorf("commit '%s' is too short; use at least 4 characters", ref)
+	}
+	for i, c := range commits {
+		if strings.HasPrefix(c.Hash, strings.ToLower(ref)) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("commit '%s' is not in the crawled history", ref)
+}
+
+func parseDate(s string, isEnd bool) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if isEnd {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, true
+}
+
+func short(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
+
+func day(date string) string {
+	if len(date) > 10 {
+		return date[:10]
+	}
+	return date
+}
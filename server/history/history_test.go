@@ -0,0 +1,105 @@
+package history
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+// testHistory holds five commits, newest first, two of them on 2024-05-03.
+var testHistory = types.RepoHistory{Commits: []types.Commit{
+	{Hash: "eeee5555", Date: "2024-05-05T09:00:00Z", Subject: "five"},
+	{Hash: "dddd4444", Date: "2024-05-03T18:00:00Z", Subject: "four"},
+	{Hash: "cccc3333", Date: "2024-05-03T08:00:00Z", Subject: "three"},
+	{Hash: "bbbb2222", Date: "2024-05-02T12:00:00Z", Subject: "two"},
+	{Hash: "aaaa1111", Date: "2024-05-01T12:00:00Z", Subject: "one"},
+}}
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		r       types.CommitRange
+		want    []string
+		wantErr string
+	}{
+		{"everything", types.CommitRange{}, []string{"five", "four", "three", "two", "one"}, ""},
+		{"hash range excludes from", types.CommitRange{From: "bbbb2222", To: "dddd4444"}, []string{"four", "three"}, ""},
+		{"hash prefix", types.CommitRange{From: "CCCC"}, []string{"five", "four"}, ""},
+		{"up to a hash", types.CommitRange{To: "bbbb"}, []string{"two", "one"}, ""},
+		{"date range is inclusive", types.CommitRange{From: "2024-05-02", To: "2024-05-03"}, []string{"four", "three", "two"}, ""},
+		{"single day", types.CommitRange{From: "2024-05-03", To: "2024-05-03"}, []string{"four", "three"}, ""},
+		{"timestamps", types.CommitRange{From: "2024-05-03T08:00:00Z", To: "2024-05-03T12:00:00Z"}, []string{"three"}, ""},
+		{"after the newest commit", types.CommitRange{From: "2024-06-01"}, []string{}, ""},
+		{"before the oldest commit", types.CommitRange{To: "2024-04-01"}, []string{}, ""},
+		{"reversed", types.CommitRange{From: "dddd4444", To: "bbbb2222"}, []string{}, ""},
+		{"short hash", types.CommitRange{From: "abc"}, nil, "too short"},
+		{"unknown hash", types.CommitRange{To: "ffff"}, nil, "not in the crawled history"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commits, err := Range(testHistory, tt.r)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Range error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, c := range commits {
+				got = append(got, c.Subject)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Range = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScope(t *testing.T) {
+	commits := []types.Commit{{
+		Hash:    "dddd4444ffff",
+		Date:    "2024-05-03T18:00:00Z",
+		Author:  "Jane Doe",
+		Subject: "Fix retry loop",
+		Body:    "Retries stopped after one attempt.\nFixes #12.",
+		Files:   []types.CommitFile{{Path: "api/client.go", Added: 12, Deleted: 3}, {Path: "logo.png", Added: -1, Deleted: -1}},
+	}}
+	want := "This question is about the commits after bbbb2222 up to dddd4444. Base the answer on these commits and the files they changed, listed newest first:\n" +
+		"dddd4444 2024-05-03 Jane Doe: Fix retry loop\n" +
+		"  api/client.go +12 -3\n" +
+		"  logo.png (binary)\n" +
+		"  | Retries stopped after one attempt.\n" +
+		"  | Fixes #12.\n"
+	if got := Scope(types.CommitRange{From: "bbbb2222", To: "dddd4444"}, commits); got != want {
+		t.Errorf("Scope =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestBlameFiles(t *testing.T) {
+	commits := []types.Commit{
+		{Files: []types.CommitFile{{Path: "api/client.go"}, {Path: "vendor/lib.go"}}},
+		{Files: []types.CommitFile{{Path: "api/client.go"}, {Path: "main.go"}}},
+	}
+	bundled := map[string]types.FileHistory{"api/client.go": {}, "main.go": {}, "README.md": {}}
+	want := map[string]bool{"api/client.go": true, "main.go": true}
+	if got := blameFiles(commits, bundled); !reflect.DeepEqual(got, want) {
+		t.Errorf("blameFiles = %v, want %v", got, want)
+	}
+
+	var many []types.Commit
+	bundled = make(map[string]types.FileHistory)
+	for i := 0; i < maxBlameFiles+10; i++ {
+		path := strings.Repeat("d/", i) + "f.go"
+		many = append(many, types.Commit{Files: []types.CommitFile{{Path: path}}})
+		bundled[path] = types.FileHistory{}
+	}
+	got := blameFiles(many, bundled)
+	if len(got) != maxBlameFiles || !got["f.go"] || got[strings.Repeat("d/", maxBlameFiles)+"f.go"] {
+		t.Errorf("blameFiles kept %d files, want the %d changed most recently", len(got), maxBlameFiles)
+	}
+}
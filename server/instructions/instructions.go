@@ -0,0 +1,56 @@
+package instructions
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RepoFile is the file a repository can ship to give repotalk extra
+// instructions about itself.
+const RepoFile = "REPOTALK.md"
+
+func DefaultPersonas() map[string]string {
+	return map[string]string{
+		"reviewer": "Act as a senior code reviewer. Point out bugs, risky patterns and missing tests in the code you are asked about, " +
+			"citing the files involved. Prefer concrete suggestions over general advice.",
+		"onboarding": "Act as an onboarding guide for a developer new to this codebase. Explain where things live and how the pieces fit " +
+			"together before going into detail, and suggest which files to read next.",
+		"security": "Act as a security auditor. Focus on input validation, authentication, secrets handling, injection and unsafe " +
+			"file or network access, and rate each finding by severity.",
+	}
+}
+
+// ReadRepoFile returns the contents of the repository's REPOTALK.md, matched
+// case-insensitively at the repository root, or "" if there is none.
+func ReadRepoFile(repoDir string) (string, error) {
+	entries, err := os.ReadDir(repoDir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(entry.Name(), RepoFile) {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(repoDir, entry.Name()))
+		if err != nil {
+			return "", fmt.Errorf("cannot read '%s': %v", entry.Name(), err)
+		}
+		return string(content), nil
+	}
+	return "", nil
+}
+
+// Layer combines repository instructions and a persona into the additional
+// instructions for a run. The global instructions stay on the assistant.
+func Layer(repoInstructions, persona string) string {
+	var layers []string
+	if repoInstructions = strings.TrimSpace(repoInstructions); repoInstructions != "" {
+		layers = append(layers, "Instructions from the repository maintainers:\n"+repoInstructions)
+	}
+	if persona = strings.TrimSpace(persona); persona != "" {
+		layers = append(layers, persona)
+	}
+	return strings.Join(layers, "\n\n")
+}
@@ -0,0 +1,175 @@
+package instructions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gastrader/repotalk/utils"
+)
+
+type Version struct {
+	Hash string `json:"hash"`
+	Path string `json:"path"`
+	Size int    `json:"size"`
+	At   int64  `json:"at"`
+}
+
+// Hash identifies a version of an instructions file by its content.
+func Hash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:6])
+}
+
+type tracked struct {
+	current  Version
+	onChange func(content string)
+}
+
+// Registry watches instruction files, keeps a persisted history of every
+// version seen and notifies listeners when a file changes.
+type Registry struct {
+	mu          sync.Mutex
+	historyPath string
+	history     map[string][]Version
+	files       map[string]*tracked
+}
+
+func NewRegistry(historyPath string) (*Registry, error) {
+	r := &Registry{
+		historyPath: historyPath,
+		history:     make(map[string][]Version),
+		files:       make(map[string]*tracked),
+	}
+	if _, err := os.Stat(historyPath); err == nil {
+		if err := utils.LoadFromJSON(historyPath, &r.history); err != nil {
+			return nil, fmt.Errorf("cannot load instructions history '%s': %v", historyPath, err)
+		}
+	}
+	return r, nil
+}
+
+// Track starts watching path and returns its current version. onChange may be
+// nil; it is called with the new content whenever the file changes.
+func (r *Registry) Track(path string, onChange func(content string)) (Version, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Version{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	version, err := r.record(path, string(content))
+	if err != nil {
+		return Version{}, err
+	}
+	if t, ok := r.files[path]; ok {
+		t.current = version
+		if onChange != nil {
+			t.onChange = onChange
+		}
+		return version, nil
+	}
+	r.files[path] = &tracked{current: version, onChange: onChange}
+	return version, nil
+}
+
+func (r *Registry) Current(path string) (Version, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.files[path]
+	if !ok {
+		return Version{}, false
+	}
+	return t.current, true
+}
+
+// History returns every recorded version, oldest first, keyed by path.
+func (r *Registry) History() map[string][]Version {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history := make(map[string][]Version, len(r.history))
+	for path, versions := range r.history {
+		history[path] = append([]Version(nil), versions...)
+	}
+	return history
+}
+
+// Poll re-reads every tracked file and applies the ones that changed.
+func (r *Registry) Poll() {
+	r.mu.Lock()
+	paths := make([]string, 0, len(r.files))
+	for path := range r.files {
+		paths = append(paths, path)
+	}
+	r.mu.Unlock()
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Printf("Can't read instructions '%s': %v\n", path, err)
+			}
+			continue
+		}
+
+		r.mu.Lock()
+		t := r.files[path]
+		if Hash(string(content)) == t.current.Hash {
+			r.mu.Unlock()
+			continue
+		}
+		version, err := r.record(path, string(content))
+		if err != nil {
+			fmt.Printf("Can't record instructions '%s': %v\n", path, err)
+		}
+		t.current = version
+		onChange := t.onChange
+		r.mu.Unlock()
+
+		fmt.Printf("Instructions '%s' changed to version %s\n", path, version.Hash)
+		if onChange != nil {
+			onChange(string(content))
+		}
+	}
+}
+
+// Watch polls tracked files every interval until stop is closed.
+func (r *Registry) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.Poll()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// record adds content to the history of path unless it is already the
+// latest version. The caller must hold r.mu.
+func (r *Registry) record(path, content string) (Version, error) {
+	version := Version{Hash: Hash(content), Path: path, Size: len(content), At: time.Now().Unix()}
+	versions := r.history[path]
+	if n := len(versions); n > 0 && versions[n-1].Hash == version.Hash {
+		return versions[n-1], nil
+	}
+
+	r.history[path] = append(versions, version)
+	if _, err := utils.EnsureDir(filepath.Dir(r.historyPath)); err != nil {
+		return version, err
+	}
+	return version, utils.SaveToJSON(r.historyPath, r.history)
+}
@@ -0,0 +1,186 @@
+package limits
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gastrader/repotalk/types"
+	"github.com/gastrader/repotalk/utils"
+)
+
+// Limits caps requests and tokens for a single client or repository. Zero
+// means unlimited.
+type Limits struct {
+	RequestsPerMinute int `json:"requestsPerMinute" yaml:"requestsPerMinute"`
+	DailyTokens       int `json:"dailyTokens" yaml:"dailyTokens"`
+	MonthlyTokens     int `json:"monthlyTokens" yaml:"monthlyTokens"`
+}
+
+type counter struct {
+	Window      int64  `json:"window"`
+	Requests    int    `json:"requests"`
+	Day         string `json:"day"`
+	DayTokens   int    `json:"dayTokens"`
+	Month       string `json:"month"`
+	MonthTokens int    `json:"monthTokens"`
+}
+
+// Limiter enforces Limits per key and persists its counters to disk so they
+// survive restarts.
+type Limiter struct {
+	mu       sync.Mutex
+	path     string
+	limits   Limits
+	counters map[string]*counter
+}
+
+func NewLimiter(path string, limits Limits) (*Limiter, error) {
+	l := &Limiter{
+		path:     path,
+		limits:   limits,
+		counters: make(map[string]*counter),
+	}
+	if _, err := os.Stat(path); err == nil {
+		if err := utils.LoadFromJSON(path, &l.counters); err != nil {
+			return nil, fmt.Errorf("cannot load limits '%s': %v", path, err)
+		}
+	}
+	return l, nil
+}
+
+// Check reports whether a request for key is within the rate limit and
+// token budgets without counting it.
+func (l *Limiter) Check(key string, now time.Time) types.LimitStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := counter{}
+	if existing, ok := l.counters[key]; ok {
+		c = *existing
+	}
+	c.roll(now)
+	status := l.status(&c)
+
+	switch {
+	case l.limits.DailyTokens > 0 && c.DayTokens >= l.limits.DailyTokens:
+		status.Reason = "daily token budget exhausted"
+		status.RetryAfter = secondsUntil(now, nextDay(now))
+	case l.limits.MonthlyTokens > 0 && c.MonthTokens >= l.limits.MonthlyTokens:
+		status.Reason = "monthly token budget exhausted"
+		status.RetryAfter = secondsUntil(now, nextMonth(now))
+	case l.limits.RequestsPerMinute > 0 && c.Requests >= l.limits.RequestsPerMinute:
+		status.Reason = "rate limit exceeded"
+		status.RetryAfter = secondsUntil(now, time.Unix(c.Window, 0).Add(time.Minute))
+	default:
+		status.Allowed = true
+	}
+	return status
+}
+
+// Take counts a request for key, which Check allowed, and returns the
+// remaining limits.
+func (l *Limiter) Take(key string, now time.Time) (types.LimitStatus, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := l.counter(key, now)
+	c.Requests++
+	status := l.status(c)
+	status.Allowed = true
+	return status, l.save(now)
+}
+
+// Spend charges tokens used by a completed run against key's budgets.
+func (l *Limiter) Spend(key string, tokens int, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := l.counter(key, now)
+	c.DayTokens += tokens
+	c.MonthTokens += tokens
+	return l.save(now)
+}
+
+func (l *Limiter) counter(key string, now time.Time) *counter {
+	c, ok := l.counters[key]
+	if !ok {
+		c = &counter{}
+		l.counters[key] = c
+	}
+	c.roll(now)
+	return c
+}
+
+// roll resets the counts of windows that have ended by now.
+func (c *counter) roll(now time.Time) {
+	now = now.UTC()
+	if window := now.Truncate(time.Minute).Unix(); c.Window != window {
+		c.Window = window
+		c.Requests = 0
+	}
+	if day := now.Format("2006-01-02"); c.Day != day {
+		c.Day = day
+		c.DayTokens = 0
+	}
+	if month := now.Format("2006-01"); c.Month != month {
+		c.Month = month
+		c.MonthTokens = 0
+	}
+}
+
+func (l *Limiter) status(c *counter) types.LimitStatus {
+	return types.LimitStatus{
+		RemainingRequests:      remaining(l.limits.RequestsPerMinute, c.Requests),
+		RemainingDailyTokens:   remaining(l.limits.DailyTokens, c.DayTokens),
+		RemainingMonthlyTokens: remaining(l.limits.MonthlyTokens, c.MonthTokens),
+	}
+}
+
+// save evicts the counters whose windows have all ended, so keys that are
+// no longer used do not accumulate, and writes the rest to disk.
+func (l *Limiter) save(now time.Time) error {
+	for key, c := range l.counters {
+		rolled := *c
+		rolled.roll(now)
+		if rolled.Requests == 0 && rolled.DayTokens == 0 && rolled.MonthTokens == 0 {
+			delete(l.counters, key)
+		}
+	}
+
+	if _, err := utils.EnsureDir(filepath.Dir(l.path)); err != nil {
+		return err
+	}
+	return utils.SaveToJSON(l.path, l.counters)
+}
+
+// remaining returns -1 for unlimited budgets.
+func remaining(limit, used int) int {
+	if limit <= 0 {
+		return -1
+	}
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
+
+func nextDay(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+func nextMonth(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func secondsUntil(now, t time.Time) int {
+	seconds := int(t.Sub(now).Seconds())
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
@@ -0,0 +1,132 @@
+package limits
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(t *testing.T, limits Limits) *Limiter {
+	t.Helper()
+	l, err := NewLimiter(filepath.Join(t.TempDir(), "limits.json"), limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2024, 5, 31, 23, 59, 30, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		limits     Limits
+		requests   int
+		spend      int
+		at         time.Time
+		wantAllow  bool
+		wantReason string
+	}{
+		{"unlimited", Limits{}, 100, 1 << 30, now, true, ""},
+		{"under rate limit", Limits{RequestsPerMinute: 3}, 2, 0, now, true, ""},
+		{"rate limit exceeded", Limits{RequestsPerMinute: 3}, 3, 0, now, false, "rate limit exceeded"},
+		{"rate limit resets next minute", Limits{RequestsPerMinute: 3}, 3, 0, now.Add(time.Minute), true, ""},
+		{"daily budget exhausted", Limits{DailyTokens: 100}, 1, 100, now, false, "daily token budget exhausted"},
+		{"daily budget resets next day", Limits{DailyTokens: 100}, 1, 100, now.Add(time.Hour), true, ""},
+		{"monthly budget exhausted", Limits{MonthlyTokens: 100, DailyTokens: 1000}, 1, 100, now, false, "monthly token budget exhausted"},
+		{"monthly budget resets next month", Limits{MonthlyTokens: 100}, 1, 100, now.Add(time.Hour), true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLimiter(t, tt.limits)
+			for i := 0; i < tt.requests; i++ {
+				if !l.Check("k", now).Allowed {
+					t.Fatalf("request %d refused", i)
+				}
+				if _, err := l.Take("k", now); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := l.Spend("k", tt.spend, now); err != nil {
+				t.Fatal(err)
+			}
+
+			status := l.Check("k", tt.at)
+			if status.Allowed != tt.wantAllow || status.Reason != tt.wantReason {
+				t.Errorf("Check = allowed %v %q, want %v %q", status.Allowed, status.Reason, tt.wantAllow, tt.wantReason)
+			}
+			if !status.Allowed && status.RetryAfter < 1 {
+				t.Errorf("RetryAfter = %d, want at least 1", status.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestCheckDoesNotCount(t *testing.T) {
+	l := newTestLimiter(t, Limits{RequestsPerMinute: 1})
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !l.Check("k", now).Allowed {
+			t.Fatalf("check %d refused", i)
+		}
+	}
+	if _, err := l.Take("k", now); err != nil {
+		t.Fatal(err)
+	}
+	if l.Check("k", now).Allowed {
+		t.Error("request over the limit allowed")
+	}
+}
+
+func TestLimiterPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	limits := Limits{DailyTokens: 100}
+	now := time.Now()
+
+	l, err := NewLimiter(path, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Spend("k", 100, now); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewLimiter(path, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Check("k", now).Allowed {
+		t.Error("budget spent before reloading is allowed again")
+	}
+}
+
+func TestLimiterEvictsExpiredCounters(t *testing.T) {
+	l := newTestLimiter(t, Limits{RequestsPerMinute: 10, MonthlyTokens: 1000})
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, err := l.Take("idle", start); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Spend("spender", 10, start); err != nil {
+		t.Fatal(err)
+	}
+
+	// A minute later the idle key's request window has ended, while the
+	// spender's tokens count until the month ends.
+	if _, err := l.Take("active", start.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.counters["idle"]; ok {
+		t.Error("idle counter kept after its window ended")
+	}
+	if _, ok := l.counters["spender"]; !ok {
+		t.Error("counter with monthly tokens evicted")
+	}
+
+	if _, err := l.Take("active", start.AddDate(0, 1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.counters["spender"]; ok {
+		t.Error("spender counter kept after the month ended")
+	}
+}
@@ -5,46 +5,132 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/joho/godotenv"
 
 	"github.com/gastrader/repotalk/api"
-	"github.com/gastrader/repotalk/assistant"
-	"github.com/gastrader/repotalk/types"
-	"github.com/sashabaranov/go-openai"
+	"github.com/gastrader/repotalk/billing"
+	"github.com/gastrader/repotalk/config"
+	"github.com/gastrader/repotalk/instructions"
+	"github.com/gastrader/repotalk/limits"
+	"github.com/gastrader/repotalk/store"
 )
 
 func main() {
-	err := godotenv.Load()
+	// .env is optional; container deploys pass real environment variables.
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: could not load .env file: %v\n", err)
+	}
+
+	cfg, err := config.Load(os.Args[1:])
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatalf("Error loading config: %v", err)
 	}
+	fmt.Printf("Effective config:\n%s\n", cfg)
 
-	client := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+	client := api.NewOpenAIClient(cfg.Backend, cfg.Backend.APIKey)
 
-	asstCFG := types.AsstConfig{
-		Name:  "repo_talk_01",
-		Model: "gpt-3.5-turbo-1106",
+	content, err := os.ReadFile(cfg.InstructionsFile)
+	if err != nil {
+		log.Fatalf("Error reading instructions file: %v", err)
 	}
-	asst := assistant.LoadOrCreate(*client, asstCFG, false)
 
-	instructionsFile := "./instructions.md"
-	content, err := os.ReadFile(instructionsFile)
+	versions, err := instructions.NewRegistry(filepath.Join(cfg.DataDir, "instructions_history.json"))
 	if err != nil {
-		log.Fatalf("Error reading instructions file: %v", err)
+		log.Fatalf("Error loading instructions history: %v", err)
+	}
+
+	repos, err := store.NewRepoStore(filepath.Join(cfg.DataDir, "repos.json"))
+	if err != nil {
+		log.Fatalf("Error loading repositories: %v", err)
+	}
+
+	threads, err := store.NewThreadStore(filepath.Join(cfg.DataDir, "threads.json"))
+	if err != nil {
+		log.Fatalf("Error loading threads: %v", err)
+	}
+
+	usage, err := store.NewUsageStore(filepath.Join(cfg.DataDir, "usage.json"))
+	if err != nil {
+		log.Fatalf("Error loading usage: %v", err)
+	}
+
+	prices, err := billing.LoadPrices(cfg.PricesFile)
+	if err != nil {
+		log.Fatalf("Error loading prices: %v", err)
+	}
+
+	clientLimit, err := limits.NewLimiter(filepath.Join(cfg.DataDir, "client_limits.json"), cfg.ClientLimits)
+	if err != nil {
+		log.Fatalf("Error loading client limits: %v", err)
 	}
 
-	// Upload the instructions to the assistant
-	assistant.UploadInstructions(client, asst, string(content))
+	repoLimit, err := limits.NewLimiter(filepath.Join(cfg.DataDir, "repo_limits.json"), cfg.RepoLimits)
+	if err != nil {
+		log.Fatalf("Error loading repository limits: %v", err)
+	}
+
+	tenants, err := store.NewTenantStore(filepath.Join(cfg.DataDir, "tenants.json"))
+	if err != nil {
+		log.Fatalf("Error loading tenants: %v", err)
+	}
+
+	queryLog, err := store.NewQueryLog(filepath.Join(cfg.DataDir, "queries.jsonl"))
+	if err != nil {
+		log.Fatalf("Error opening query log: %v", err)
+	}
+
+	overviews, err := store.NewOverviewStore(filepath.Join(cfg.DataDir, "overviews.json"))
+	if err != nil {
+		log.Fatalf("Error loading overviews: %v", err)
+	}
+
+	stores := api.Stores{Repos: repos, Tenants: tenants, Overviews: overviews, Threads: threads, Usage: usage, QueryLog: queryLog, Instructions: versions}
+	repoHandler := api.NewRepoHandler(client, cfg, string(content), stores, prices, clientLimit, repoLimit)
+
+	if _, err := versions.Track(cfg.InstructionsFile, repoHandler.SetInstructions); err != nil {
+		log.Fatalf("Error tracking instructions file: %v", err)
+	}
+	for _, repo := range repos.All() {
+		for _, file := range repo.InstructionFiles {
+			if _, err := versions.Track(file, nil); err != nil {
+				log.Printf("Warning: Failed to track instructions '%s': %v\n", file, err)
+			}
+		}
+	}
+	if cfg.InstructionsPollSeconds > 0 {
+		go versions.Watch(time.Duration(cfg.InstructionsPollSeconds)*time.Second, make(chan struct{}))
+	}
+
+	if cfg.Assistant.DryRun {
+		fmt.Println("Assistant dry run: planned changes are printed, not applied.")
+		repoHandler.PlanAssistants(tenants.List())
+	}
+
+	if cfg.AdminToken == "" {
+		fmt.Println("ADMIN_TOKEN is not set; the admin API is disabled.")
+	}
+	adminHandler := api.NewAdminHandler(tenants, versions, cfg.AdminToken)
 
-	repoHandler := api.NewRepoHandler(client, asst)
-	http.HandleFunc("/api/v1/crawl", repoHandler.CrawlHandler)
-	http.HandleFunc("/api/v1/query", repoHandler.QueryHandler)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/crawl", api.AllowMethods(api.RequireAPIKey(tenants, repoHandler.CrawlHandler), http.MethodPost))
+	mux.HandleFunc("/api/v1/query", api.AllowMethods(api.RequireAPIKey(tenants, repoHandler.QueryHandler), http.MethodPost))
+	mux.HandleFunc("/api/v1/review", api.AllowMethods(api.RequireAPIKey(tenants, repoHandler.ReviewHandler), http.MethodPost))
+	mux.HandleFunc("/api/v1/files/", api.AllowMethods(api.RequireAPIKey(tenants, repoHandler.FileHandler), http.MethodGet))
+	mux.HandleFunc("/api/v1/repos/", api.AllowMethods(api.RequireAPIKey(tenants, repoHandler.ReposHandler), http.MethodGet))
+	mux.HandleFunc("/api/v1/usage", api.AllowMethods(api.RequireAPIKey(tenants, repoHandler.UsageHandler), http.MethodGet))
+	mux.HandleFunc("/api/v1/webhooks/github", api.AllowMethods(repoHandler.GitHubWebhookHandler, http.MethodPost))
+	mux.HandleFunc("/api/v1/webhooks/git", api.AllowMethods(repoHandler.GitWebhookHandler, http.MethodPost))
+	mux.HandleFunc("/api/v1/admin/tenants", api.AllowMethods(adminHandler.TenantsHandler, http.MethodGet, http.MethodPost))
+	mux.HandleFunc("/api/v1/admin/tenants/", api.AllowMethods(adminHandler.TenantsHandler, http.MethodPost))
+	mux.HandleFunc("/api/v1/admin/keys/", api.AllowMethods(adminHandler.KeysHandler, http.MethodDelete))
+	mux.HandleFunc("/api/v1/admin/instructions", api.AllowMethods(adminHandler.InstructionsHandler, http.MethodGet))
 
-	port := ":8080"
-	fmt.Printf("Server is running on http://localhost%s\n", port)
+	fmt.Printf("Server is running on http://localhost%s\n", cfg.Port)
 
-	if err := http.ListenAndServe(port, nil); err != nil {
+	if err := http.ListenAndServe(cfg.Port, api.CORS(cfg.CORS, mux)); err != nil {
 		log.Fatalf("Could not start server: %v\n", err)
 	}
 }
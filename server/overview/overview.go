@@ -0,0 +1,180 @@
+// Package overview builds the structured repository summary generated at
+// crawl time.
+package overview
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gastrader/repotalk/types"
+	"github.com/gastrader/repotalk/utils"
+)
+
+// Prompt asks the assistant for the overview fields it cannot compute
+// locally, as a single JSON object.
+const Prompt = `Read the attached repository bundle and describe the repository as a single JSON object, with no other text, using exactly these fields:
+
+{
+  "purpose": "two or three sentences on what the repository does and for whom",
+  "entryPoints": ["paths of main programs, servers, CLIs or exported library entry points"],
+  "packages": [{"path": "directory or package path", "description": "one sentence"}],
+  "keyTypes": [{"name": "type or class name", "path": "file path", "description": "one sentence"}],
+  "buildCommands": ["commands to build or install"],
+  "runCommands": ["commands to run or test"]
+}
+
+Use paths as they appear in the file path headers, relative to the repository root. List at most 15 packages and 15 key types. Leave a list empty rather than guessing.`
+
+var languages = map[string]string{
+	".go":   "Go",
+	".ts":   "TypeScript",
+	".tsx":  "TypeScript",
+	".js":   "JavaScript",
+	".jsx":  "JavaScript",
+	".py":   "Python",
+	".java": "Java",
+	".rb":   "Ruby",
+	".cpp":  "C++",
+	".c":    "C",
+	".cs":   "C#",
+	".zig":  "Zig",
+	".sh":   "Shell",
+	".html": "HTML",
+	".yaml": "YAML",
+	".toml": "TOML",
+	".kt":   "Kotlin",
+	".kts":  "Kotlin",
+	".php":  "PHP",
+}
+
+// Key identifies the content of a crawl: the commit and the paths crawled
+// if there is one, otherwise a hash of the bundle.
+func Key(commit string, paths []string, bundlePath string) (string, error) {
+	if commit != "" && len(paths) > 0 {
+		return commit + ":" + strings.Join(paths, ","), nil
+	}
+	if commit != "" {
+		return commit, nil
+	}
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Languages counts the files and non-blank lines of each language in a
+// bundle, most lines first.
+func Languages(bundlePath string) ([]types.LanguageShare, error) {
+	sections, lines, err := utils.ParseBundleIndex(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+
+	byLanguage := make(map[string]*types.LanguageShare)
+	for _, section := range sections {
+		name, ok := languages[strings.ToLower(path.Ext(section.Path))]
+		if !ok {
+			continue
+		}
+		share := byLanguage[name]
+		if share == nil {
+			share = &types.LanguageShare{Language: name}
+			byLanguage[name] = share
+		}
+		share.Files++
+		// HeaderLine and EndLine are 1-based, so lines[HeaderLine] is the
+		// file's first line.
+		for _, line := range lines[section.HeaderLine:section.EndLine] {
+			if strings.TrimSpace(line) != "" {
+				share.Lines++
+			}
+		}
+	}
+
+	shares := make([]types.LanguageShare, 0, len(byLanguage))
+	for _, share := range byLanguage {
+		shares = append(shares, *share)
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		if shares[i].Lines != shares[j].Lines {
+			return shares[i].Lines > shares[j].Lines
+		}
+		return shares[i].Language < shares[j].Language
+	})
+	return shares, nil
+}
+
+// Parse reads the assistant's reply to Prompt, tolerating a Markdown code
+// fence or prose around the JSON object.
+func Parse(reply string) (types.RepoOverview, error) {
+	start := strings.Index(reply, "{")
+	end := strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return types.RepoOverview{}, fmt.Errorf("reply contains no JSON object")
+	}
+
+	var ov types.RepoOverview
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &ov); err != nil {
+		return types.RepoOverview{}, fmt.Errorf("cannot parse overview: %v", err)
+	}
+	if strings.TrimSpace(ov.Purpose) == "" {
+		return types.RepoOverview{}, fmt.Errorf("overview has no purpose")
+	}
+	return ov, nil
+}
+
+// Render formats an overview as Markdown.
+func Render(ov types.RepoOverview) string {
+	var b strings.Builder
+	b.WriteString(ov.Purpose)
+	b.WriteString("\n")
+
+	if len(ov.Languages) > 0 {
+		var names []string
+		for _, l := range ov.Languages {
+			names = append(names, fmt.Sprintf("%s (%d files)", l.Language, l.Files))
+		}
+		fmt.Fprintf(&b, "\n**Languages:** %s\n", strings.Join(names, ", "))
+	}
+	list(&b, "Entry points", ov.EntryPoints)
+
+	var packages []string
+	for _, p := range ov.Packages {
+		packages = append(packages, fmt.Sprintf("`%s`: %s", p.Path, p.Description))
+	}
+	list(&b, "Packages", packages)
+
+	var keyTypes []string
+	for _, t := range ov.KeyTypes {
+		keyTypes = append(keyTypes, fmt.Sprintf("`%s` (%s): %s", t.Name, t.Path, t.Description))
+	}
+	list(&b, "Key types", keyTypes)
+
+	list(&b, "Build", ov.BuildCommands)
+	list(&b, "Run", ov.RunCommands)
+	return b.String()
+}
+
+func list(b *strings.Builder, title string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n**%s:**\n", title)
+	for _, item := range items {
+		fmt.Fprintf(b, "- %s\n", item)
+	}
+}
@@ -0,0 +1,146 @@
+package overview
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gastrader/repotalk/types"
+	"github.com/gastrader/repotalk/utils"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		want    types.RepoOverview
+		wantErr string
+	}{
+		{
+			name:  "bare object",
+			reply: `{"purpose": "A chat server.", "entryPoints": ["main.go"]}`,
+			want:  types.RepoOverview{Purpose: "A chat server.", EntryPoints: []string{"main.go"}},
+		},
+		{
+			name:  "code fence",
+			reply: "```json\n{\"purpose\": \"A chat server.\", \"buildCommands\": [\"go build ./...\"]}\n```",
+			want:  types.RepoOverview{Purpose: "A chat server.", BuildCommands: []string{"go build ./..."}},
+		},
+		{
+			name:  "prose around the object",
+			reply: "Here is the overview:\n{\"purpose\": \"A CLI.\", \"packages\": [{\"path\": \"cmd\", \"description\": \"Commands\"}]}\nLet me know.",
+			want:  types.RepoOverview{Purpose: "A CLI.", Packages: []types.PackageSummary{{Path: "cmd", Description: "Commands"}}},
+		},
+		{name: "no object", reply: "I could not read the bundle.", wantErr: "no JSON object"},
+		{name: "invalid json", reply: `{"purpose": }`, wantErr: "cannot parse overview"},
+		{name: "no purpose", reply: `{"purpose": "  ", "entryPoints": ["main.go"]}`, wantErr: "no purpose"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.reply)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	dir := t.TempDir()
+	bundleA := filepath.Join(dir, "a.txt")
+	bundleB := filepath.Join(dir, "b.txt")
+	if err := os.WriteFile(bundleA, []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bundleB, []byte("package b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		commit string
+		paths  []string
+		bundle string
+		want   string
+	}{
+		{"commit", "abc123", nil, bundleA, "abc123"},
+		{"commit and paths", "abc123", []string{"api", "store"}, bundleA, "abc123:api,store"},
+		{"commit ignores the bundle", "abc123", nil, filepath.Join(dir, "missing.txt"), "abc123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Key(tt.commit, tt.paths, tt.bundle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	a, err := Key("", nil, bundleA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Key("", nil, bundleB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(a, "sha256:") || a == b {
+		t.Errorf("bundle keys = %q and %q, want distinct sha256 keys", a, b)
+	}
+	if _, err := Key("", nil, filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("Key without commit or bundle succeeded")
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	repoDir := t.TempDir()
+	files := map[string]string{
+		"main.go":      "package main\n\nfunc main() {}\n",
+		"util.go":      "package main\n",
+		"web/app.ts":   "export const app = 1\n",
+		"README.md":    "# Repo\n",
+		"web/index.js": "\n\n",
+	}
+	var paths []string
+	for _, name := range []string{"main.go", "util.go", "web/app.ts", "README.md", "web/index.js"} {
+		path := filepath.Join(repoDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(files[name]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	bundlePath := filepath.Join(t.TempDir(), "bundle.txt")
+	if err := utils.BundleToFile(paths, bundlePath); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Languages(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []types.LanguageShare{
+		{Language: "Go", Files: 2, Lines: 3},
+		{Language: "TypeScript", Files: 1, Lines: 1},
+		{Language: "JavaScript", Files: 1, Lines: 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Languages = %+v, want %+v", got, want)
+	}
+}
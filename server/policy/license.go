@@ -0,0 +1,161 @@
+package policy
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// LicenseNone is reported for repositories without a license file.
+	LicenseNone = "none"
+	// LicenseUnknown is reported for license files that are not recognized.
+	LicenseUnknown = "unknown"
+)
+
+// maxLicenseBytes is how much of a license file is read.
+const maxLicenseBytes = 64 << 10
+
+// titles identify licenses by the heading near the start of their text.
+var titles = []struct {
+	id     string
+	phrase []string
+}{
+	{"AGPL-3.0", []string{"gnu affero general public license", "version 3"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license", "version 2.1"}},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}},
+	{"Apache-2.0", []string{"apache license", "version 2.0"}},
+	{"MPL-2.0", []string{"mozilla public license", "2.0"}},
+	{"EPL-2.0", []string{"eclipse public license", "2.0"}},
+}
+
+// bodies identify licenses by a sentence of their text.
+var bodies = []struct {
+	id     string
+	phrase string
+}{
+	{"Unlicense", "this is free and unencumbered software released into the public domain"},
+	{"CC0-1.0", "cc0 1.0 universal"},
+	{"BSL-1.0", "boost software license - version 1.0"},
+	{"MIT", "permission is hereby granted, free of charge, to any person obtaining a copy"},
+	{"ISC", "permission to use, copy, modify, and/or distribute this software for any purpose with or without fee is hereby granted"},
+	{"ISC", "permission to use, copy, modify, and distribute this software for any purpose with or without fee is hereby granted"},
+}
+
+// Detect returns the SPDX identifier of the license of the repository at
+// dir, read from the license files at its root. Several recognized licenses
+// are joined with " OR ", as repositories ship one file per license they
+// offer a choice of.
+func Detect(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return LicenseNone
+	}
+
+	found := false
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() || !isLicenseFile(entry.Name()) {
+			continue
+		}
+		found = true
+		if id := identify(filepath.Join(dir, entry.Name())); id != "" {
+			seen[id] = true
+		}
+	}
+
+	switch {
+	case len(seen) > 0:
+		ids := make([]string, 0, len(seen))
+		for id := range seen {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return strings.Join(ids, " OR ")
+	case found:
+		return LicenseUnknown
+	}
+	return LicenseNone
+}
+
+// isLicenseFile matches LICENSE, LICENCE, COPYING and UNLICENSE files with
+// any extension or suffix, such as LICENSE-MIT or COPYING.txt.
+func isLicenseFile(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, prefix := range []string{"LICENSE", "LICENCE", "COPYING", "UNLICENSE"} {
+		if strings.HasPrefix(upper, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// identify returns the SPDX identifier of the license in file, or "" if it
+// is not recognized. An SPDX-License-Identifier line takes precedence over
+// the text.
+func identify(file string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxLicenseBytes))
+	if err != nil {
+		return ""
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "SPDX-License-Identifier:"); i >= 0 {
+			if id := strings.TrimSpace(line[i+len("SPDX-License-Identifier:"):]); id != "" {
+				return id
+			}
+		}
+	}
+
+	text := strings.Join(strings.Fields(strings.ToLower(string(data))), " ")
+	head := text
+	if len(head) > 300 {
+		head = head[:300]
+	}
+	// The title that comes first is the license's own; the GPL family's
+	// texts mention each other further down.
+	id, at := "", -1
+	for _, t := range titles {
+		i := strings.Index(head, t.phrase[0])
+		if i >= 0 && (at < 0 || i < at) && containsAll(head, t.phrase[1:]) {
+			id, at = t.id, i
+		}
+	}
+	if id != "" {
+		return id
+	}
+	for _, b := range bodies {
+		if strings.Contains(text, b.phrase) {
+			return b.id
+		}
+	}
+	if strings.Contains(text, "redistribution and use in source and binary forms") {
+		if strings.Contains(text, "endorse or promote products") {
+			return "BSD-3-Clause"
+		}
+		return "BSD-2-Clause"
+	}
+	return ""
+}
+
+func containsAll(s string, phrases []string) bool {
+	for _, phrase := range phrases {
+		if !strings.Contains(s, phrase) {
+			return false
+		}
+	}
+	return true
+}
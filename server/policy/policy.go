@@ -0,0 +1,187 @@
+// Package policy decides which repositories may be crawled and sent to the
+// model provider: where they are hosted, which organization owns them, their
+// license, their size and whether they opted in.
+package policy
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Policy is checked before every crawl. Empty lists and zero values impose
+// no restriction.
+type Policy struct {
+	// AllowHosts and DenyHosts match the host of a repository URL exactly,
+	// or any subdomain with a "*.example.com" entry.
+	AllowHosts []string `yaml:"allowHosts"`
+	DenyHosts  []string `yaml:"denyHosts"`
+	// AllowOrgs and DenyOrgs match the owner of a repository URL.
+	AllowOrgs []string `yaml:"allowOrgs"`
+	DenyOrgs  []string `yaml:"denyOrgs"`
+	// AllowUploads admits uploaded archives and local paths, which have no
+	// host or owner, while AllowHosts or AllowOrgs is set. They are always
+	// admitted otherwise.
+	AllowUploads bool `yaml:"allowUploads"`
+	// Licenses lists the SPDX identifiers of the licenses that may be
+	// crawled. "unknown" admits license files that are not recognized and
+	// "none" repositories without one.
+	Licenses []string `yaml:"licenses"`
+	// MaxBytes caps the total size of the files that would be bundled.
+	MaxBytes int64 `yaml:"maxBytes"`
+	// OptInFile must exist at the repository root for it to be crawled.
+	OptInFile string `yaml:"optInFile"`
+}
+
+// Violation is returned when a repository breaks one of the policy's rules.
+type Violation struct {
+	// Rule is the policy setting that was violated, e.g. "policy.licenses".
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("%s (%s)", v.Message, v.Rule)
+}
+
+func violation(rule, format string, args ...interface{}) *Violation {
+	return &Violation{Rule: "policy." + rule, Message: fmt.Sprintf(format, args...)}
+}
+
+// ChecksCheckout reports whether the policy has rules that need the
+// repository's files, so a bundle made earlier cannot be reused unchecked.
+func (p Policy) ChecksCheckout() bool {
+	return len(p.Licenses) > 0 || p.MaxBytes > 0 || p.OptInFile != ""
+}
+
+// CheckOrigin checks the host and owner of a repository URL against the
+// host and organization lists.
+func (p Policy) CheckOrigin(rawURL string) error {
+	if len(p.AllowHosts)+len(p.DenyHosts)+len(p.AllowOrgs)+len(p.DenyOrgs) == 0 {
+		return nil
+	}
+	host, org := Origin(rawURL)
+
+	if matchHost(p.DenyHosts, host) {
+		return violation("denyHosts", "Host '%s' is denied by policy", host)
+	}
+	if len(p.AllowHosts) > 0 && host == "" {
+		return violation("allowHosts", "Repository URL '%s' names no host", rawURL)
+	}
+	if len(p.AllowHosts) > 0 && !matchHost(p.AllowHosts, host) {
+		return violation("allowHosts", "Host '%s' is not in the allowed hosts", host)
+	}
+	if matchOrg(p.DenyOrgs, org) {
+		return violation("denyOrgs", "Organization '%s' is denied by policy", org)
+	}
+	if len(p.AllowOrgs) > 0 && org == "" {
+		return violation("allowOrgs", "Repository URL '%s' names no organization", rawURL)
+	}
+	if len(p.AllowOrgs) > 0 && !matchOrg(p.AllowOrgs, org) {
+		return violation("allowOrgs", "Organization '%s' is not in the allowed organizations", org)
+	}
+	return nil
+}
+
+// CheckUnhosted checks a source without a host or owner, an uploaded
+// archive or a local path, which an allow-list of hosts or organizations
+// admits only with AllowUploads.
+func (p Policy) CheckUnhosted() error {
+	if len(p.AllowHosts)+len(p.AllowOrgs) > 0 && !p.AllowUploads {
+		return violation("allowUploads", "Uploads and local paths are not allowed while hosts or organizations are restricted")
+	}
+	return nil
+}
+
+// CheckCheckout checks the checkout at dir, of which files would be
+// bundled, against the opt-in, size and license rules. It returns the
+// detected license either way.
+func (p Policy) CheckCheckout(dir string, files []string) (string, error) {
+	license := Detect(dir)
+
+	if p.OptInFile != "" {
+		info, err := os.Stat(filepath.Join(dir, p.OptInFile))
+		if err != nil || info.IsDir() {
+			return license, violation("optInFile", "Repository has no '%s' file opting in to crawling", p.OptInFile)
+		}
+	}
+
+	if p.MaxBytes > 0 {
+		var size int64
+		for _, file := range files {
+			if info, err := os.Stat(file); err == nil {
+				size += info.Size()
+			}
+		}
+		if size > p.MaxBytes {
+			return license, violation("maxBytes", "Repository is %d bytes, over the limit of %d", size, p.MaxBytes)
+		}
+	}
+
+	if len(p.Licenses) > 0 && !p.licenseAllowed(license) {
+		return license, violation("licenses", "License '%s' is not in the allowed licenses (%s)", license, strings.Join(p.Licenses, ", "))
+	}
+	return license, nil
+}
+
+// licenseAllowed reports whether license, which may offer a choice of
+// licenses joined by " OR ", includes an allowed one.
+func (p Policy) licenseAllowed(license string) bool {
+	for _, id := range strings.Split(license, " OR ") {
+		for _, allowed := range p.Licenses {
+			if strings.EqualFold(id, allowed) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Origin returns the host and owner of a repository URL, given as a URL or
+// in scp-like form (git@host:owner/repo or host:owner/repo). Either is
+// empty when the URL does not name it.
+func Origin(rawURL string) (string, string) {
+	var host, path string
+	if strings.Contains(rawURL, "://") {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return "", ""
+		}
+		host, path = u.Hostname(), u.Path
+	} else if colon := strings.Index(rawURL, ":"); colon >= 0 && !strings.Contains(rawURL[:colon], "/") {
+		// As for git, a colon before any slash makes it scp-like rather than
+		// a path.
+		host, path = rawURL[:colon], rawURL[colon+1:]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	org := ""
+	if len(parts) >= 2 {
+		org = parts[0]
+	}
+	return strings.ToLower(host), org
+}
+
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == host || strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOrg(orgs []string, org string) bool {
+	for _, o := range orgs {
+		if strings.EqualFold(o, org) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOrigin(t *testing.T) {
+	tests := []struct {
+		url, host, org string
+	}{
+		{"https://github.com/acme/app", "github.com", "acme"},
+		{"https://GitHub.com/acme/app.git", "github.com", "acme"},
+		{"ssh://git@git.example.com:2222/acme/app.git", "git.example.com", "acme"},
+		{"git@github.com:acme/app.git", "github.com", "acme"},
+		{"github.com:acme/app.git", "github.com", "acme"},
+		{"GitHub.com:acme/app", "github.com", "acme"},
+		{"./dir:acme/app", "", ""},
+		{"https://github.com/app", "github.com", ""},
+		{"/srv/code/app", "", ""},
+	}
+	for _, tt := range tests {
+		host, org := Origin(tt.url)
+		if host != tt.host || org != tt.org {
+			t.Errorf("Origin(%q) = %q, %q, want %q, %q", tt.url, host, org, tt.host, tt.org)
+		}
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		url      string
+		wantRule string
+	}{
+		{"no rules", Policy{}, "https://evil.example.com/x/y", ""},
+		{"allowed host", Policy{AllowHosts: []string{"github.com"}}, "https://github.com/acme/app", ""},
+		{"host not allowed", Policy{AllowHosts: []string{"github.com"}}, "https://gitlab.com/acme/app", "policy.allowHosts"},
+		{"subdomain wildcard", Policy{AllowHosts: []string{"*.example.com"}}, "git@git.example.com:acme/app.git", ""},
+		{"wildcard excludes other domains", Policy{AllowHosts: []string{"*.example.com"}}, "https://example.org/acme/app", "policy.allowHosts"},
+		{"scp-like without user", Policy{DenyHosts: []string{"gitlab.com"}}, "gitlab.com:acme/app.git", "policy.denyHosts"},
+		{"denied host", Policy{DenyHosts: []string{"gitlab.com"}}, "https://gitlab.com/acme/app", "policy.denyHosts"},
+		{"deny wins over allow", Policy{AllowHosts: []string{"*.example.com"}, DenyHosts: []string{"bad.example.com"}}, "https://bad.example.com/acme/app", "policy.denyHosts"},
+		{"no host under host allow-list", Policy{AllowHosts: []string{"github.com"}}, "/srv/code/app", "policy.allowHosts"},
+		{"allowed org", Policy{AllowOrgs: []string{"acme"}}, "https://github.com/ACME/app", ""},
+		{"org not allowed", Policy{AllowOrgs: []string{"acme"}}, "https://github.com/other/app", "policy.allowOrgs"},
+		{"denied org", Policy{DenyOrgs: []string{"other"}}, "git@github.com:other/app.git", "policy.denyOrgs"},
+		{"no org under org allow-list", Policy{AllowOrgs: []string{"acme"}}, "https://github.com/app", "policy.allowOrgs"},
+		{"no org under host allow-list", Policy{AllowHosts: []string{"github.com"}}, "https://github.com/app", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.CheckOrigin(tt.url)
+			if got := rule(t, err); got != tt.wantRule {
+				t.Errorf("CheckOrigin(%q) = %v, want rule %q", tt.url, err, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestCheckUnhosted(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		wantRule string
+	}{
+		{"no rules", Policy{}, ""},
+		{"deny lists only", Policy{DenyHosts: []string{"gitlab.com"}, DenyOrgs: []string{"other"}}, ""},
+		{"host allow-list", Policy{AllowHosts: []string{"github.com"}}, "policy.allowUploads"},
+		{"org allow-list", Policy{AllowOrgs: []string{"acme"}}, "policy.allowUploads"},
+		{"allow-list with allowUploads", Policy{AllowOrgs: []string{"acme"}, AllowUploads: true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.CheckUnhosted()
+			if got := rule(t, err); got != tt.wantRule {
+				t.Errorf("CheckUnhosted() = %v, want rule %q", err, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestCheckCheckout(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "LICENSE", mitText)
+	writeFile(t, dir, ".repotalk", "")
+	main := writeFile(t, dir, "main.go", strings.Repeat("x", 100))
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		wantRule string
+	}{
+		{"no rules", Policy{}, ""},
+		{"license allowed", Policy{Licenses: []string{"Apache-2.0", "mit"}}, ""},
+		{"license not allowed", Policy{Licenses: []string{"Apache-2.0"}}, "policy.licenses"},
+		{"under size limit", Policy{MaxBytes: 100}, ""},
+		{"over size limit", Policy{MaxBytes: 99}, "policy.maxBytes"},
+		{"opted in", Policy{OptInFile: ".repotalk"}, ""},
+		{"not opted in", Policy{OptInFile: ".crawl-me"}, "policy.optInFile"},
+		{"opt-in file is a directory", Policy{OptInFile: "."}, "policy.optInFile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			license, err := tt.policy.CheckCheckout(dir, []string{main})
+			if got := rule(t, err); got != tt.wantRule {
+				t.Errorf("CheckCheckout = %v, want rule %q", err, tt.wantRule)
+			}
+			if license != "MIT" {
+				t.Errorf("license = %q, want MIT", license)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"no license", map[string]string{"README.md": "hello"}, LicenseNone},
+		{"mit", map[string]string{"LICENSE": mitText}, "MIT"},
+		{"apache", map[string]string{"LICENSE.txt": "                                 Apache License\n                           Version 2.0, January 2004\n"}, "Apache-2.0"},
+		{"gpl-3 mentioning affero", map[string]string{"COPYING": gpl3Text}, "GPL-3.0"},
+		{"agpl-3", map[string]string{"LICENSE": "GNU AFFERO GENERAL PUBLIC LICENSE\nVersion 3, 19 November 2007\n"}, "AGPL-3.0"},
+		{"lgpl-2.1", map[string]string{"LICENSE": "GNU LESSER GENERAL PUBLIC LICENSE\nVersion 2.1, February 1999\n"}, "LGPL-2.1"},
+		{"bsd-3", map[string]string{"LICENSE": bsdText + " Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software."}, "BSD-3-Clause"},
+		{"bsd-2", map[string]string{"LICENSE": bsdText}, "BSD-2-Clause"},
+		{"isc", map[string]string{"LICENSE": "Permission to use, copy, modify, and/or distribute this software for any purpose with or without fee is hereby granted."}, "ISC"},
+		{"unlicense", map[string]string{"UNLICENSE": "This is free and unencumbered software released into the public domain."}, "Unlicense"},
+		{"spdx identifier wins", map[string]string{"LICENSE": "SPDX-License-Identifier: MPL-2.0\n" + mitText}, "MPL-2.0"},
+		{"unrecognized", map[string]string{"LICENSE": "All rights reserved."}, LicenseUnknown},
+		{"dual license", map[string]string{"LICENSE-MIT": mitText, "LICENSE-APACHE": "Apache License\nVersion 2.0\n"}, "Apache-2.0 OR MIT"},
+		{"same license twice", map[string]string{"LICENSE": mitText, "COPYING.txt": mitText}, "MIT"},
+		{"licence spelling", map[string]string{"licence.md": mitText}, "MIT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, dir, name, content)
+			}
+			if got := Detect(dir); got != tt.want {
+				t.Errorf("Detect = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectIgnoresLicenseDirectories(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "LICENSES"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "LICENSES"), "MIT.txt", mitText)
+	if got := Detect(dir); got != LicenseNone {
+		t.Errorf("Detect = %q, want %q", got, LicenseNone)
+	}
+}
+
+const mitText = `MIT License
+
+Copyright (c) 2024 Acme
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction.`
+
+const gpl3Text = `                    GNU GENERAL PUBLIC LICENSE
+                       Version 3, 29 June 2007
+
+ Copyright (C) 2007 Free Software Foundation, Inc. <https://fsf.org/>
+ Everyone is permitted to copy and distribute verbatim copies
+ of this license document, but changing it is not allowed.
+
+  13. Use with the GNU Affero General Public License.
+  Notwithstanding any other provision of this License, you have
+  permission to link or combine any covered work with a work licensed
+  under version 3 of the GNU Affero General Public License.`
+
+const bsdText = `Copyright (c) 2024 Acme. All rights reserved.
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met.`
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// rule returns the rule err violated, or "" for a nil error.
+func rule(t *testing.T, err error) string {
+	t.Helper()
+	if err == nil {
+		return ""
+	}
+	var v *Violation
+	if !errors.As(err, &v) {
+		t.Fatalf("error %v is not a *Violation", err)
+	}
+	return v.Rule
+}
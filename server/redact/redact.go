@@ -0,0 +1,356 @@
+// Package redact finds secrets in a bundle before it is uploaded and
+// replaces them with placeholders. Known key formats, private keys, secret
+// assignments and high-entropy string literals are detected; the secrets
+// themselves are never recorded.
+package redact
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gastrader/repotalk/types"
+	"github.com/gastrader/repotalk/utils"
+)
+
+const (
+	// PolicyOff uploads bundles as they are.
+	PolicyOff = "off"
+	// PolicyRedact replaces secrets with placeholders.
+	PolicyRedact = "redact"
+	// PolicyStrict refuses to upload a bundle that contains secrets.
+	PolicyStrict = "strict"
+)
+
+// ReportName is the redaction report kept next to a repository's bundle.
+const ReportName = "redactions.json"
+
+// maxFindings bounds the findings listed in a report; counts stay exact.
+const maxFindings = 1000
+
+// BlockedError is returned when the strict policy stops a crawl.
+type BlockedError struct {
+	Report types.RedactionReport
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("crawl blocked by the strict redaction policy: %d secrets found in %d files", e.Report.Redacted, e.Report.Files)
+}
+
+type rule struct {
+	name string
+	re   *regexp.Regexp
+	// group is the submatch replaced; 0 replaces the whole match.
+	group int
+}
+
+var tokenRules = []rule{
+	{"private-key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----(?:\\n|[A-Za-z0-9+/=\s])+?-----END [A-Z ]*PRIVATE KEY-----`), 0},
+	{"aws-access-key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), 0},
+	{"github-token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{40,})\b`), 0},
+	{"openai-key", regexp.MustCompile(`\bsk-(?:proj-)?[A-Za-z0-9_-]{20,}`), 0},
+	{"slack-token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`), 0},
+	{"stripe-key", regexp.MustCompile(`\b[sr]k_live_[A-Za-z0-9]{16,}\b`), 0},
+	{"google-api-key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`), 0},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`), 0},
+	{"url-credentials", regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:([^/\s:@]{3,})@`), 1},
+}
+
+const secretName = `[A-Za-z0-9_.-]*(?i:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key|private[_-]?key|client[_-]?secret|credentials?)[A-Za-z0-9_.-]*`
+
+// quotedAssignment matches secrets assigned as string literals in any file.
+var quotedAssignment = regexp.MustCompile(`\b` + secretName + `["']?\s*(?::=|[:=])\s*["'` + "`" + `]([^"'` + "`" + `\s]{8,})["'` + "`" + `]`)
+
+// plainAssignment matches unquoted values in configuration files and
+// scripts, where they are literals rather than expressions.
+var plainAssignment = regexp.MustCompile(`\b` + secretName + `["']?\s*[:=]\s*([^\s"'#,;]{8,})`)
+
+// quotedString and plainValue are the candidates for the entropy check.
+var quotedString = regexp.MustCompile(`["'` + "`" + `]([A-Za-z0-9+/=_-]{24,})["'` + "`" + `]`)
+var plainValue = regexp.MustCompile(`[:=]\s*([A-Za-z0-9+/=_-]{24,})\s*$`)
+
+var privateKeyBegin = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)
+var privateKeyEnd = regexp.MustCompile(`-----END [A-Z ]*PRIVATE KEY-----`)
+
+// placeholders are values that are clearly not real secrets.
+var placeholders = map[string]bool{
+	"changeme": true, "password": true, "example": true, "redacted": true,
+	"your-api-key": true, "your_api_key": true, "xxxxxxxx": true, "placeholder": true,
+}
+
+func placeholder(rule string) string {
+	return "[REDACTED:" + rule + "]"
+}
+
+// Bundle rewrites the bundle at bundlePath with its secrets replaced,
+// keeping every line in place so citations still resolve. Paths in the
+// report are relative to repoDir, the directory the bundle was made from.
+func Bundle(bundlePath, repoDir string) (types.RedactionReport, error) {
+	sections, lines, err := utils.ParseBundleIndex(bundlePath)
+	if err != nil {
+		return types.RedactionReport{}, err
+	}
+
+	report := types.RedactionReport{Rules: map[string]int{}, Findings: []types.Redaction{}}
+	files := make(map[string]bool)
+	prefix := filepath.ToSlash(filepath.Clean(repoDir)) + "/"
+	for _, section := range sections {
+		path := strings.TrimPrefix(section.Path, prefix)
+		config := isConfig(path)
+		inKey := false
+		for i := section.HeaderLine; i < section.EndLine; i++ {
+			line, rules := redactLine(lines[i], config, &inKey)
+			if len(rules) == 0 {
+				continue
+			}
+			lines[i] = line
+			files[path] = true
+			for _, r := range rules {
+				report.Redacted++
+				report.Rules[r]++
+				if len(report.Findings) < maxFindings {
+					report.Findings = append(report.Findings, types.Redaction{Path: path, Line: i - section.HeaderLine + 1, Rule: r})
+				} else {
+					report.Truncated = true
+				}
+			}
+		}
+	}
+	report.Files = len(files)
+	if report.Redacted == 0 {
+		return report, nil
+	}
+	return report, rewrite(bundlePath, lines)
+}
+
+// Diff returns a unified diff with the secrets in its lines replaced and a
+// report of them. Findings are located by file and by line within the
+// diff, as the diff has no lines of the repository's own.
+func Diff(diff string) (string, types.RedactionReport) {
+	report := types.RedactionReport{Rules: map[string]int{}, Findings: []types.Redaction{}}
+	files := make(map[string]bool)
+	lines := strings.Split(diff, "\n")
+	path, config, inKey, inHunk := "", false, false, false
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			inHunk = false
+			continue
+		case strings.HasPrefix(line, "@@"):
+			inHunk = true
+			continue
+		case !inHunk:
+			// Deleted files name only their old path.
+			if name, ok := strings.CutPrefix(line, "--- a/"); ok {
+				path = name
+			} else if name, ok := strings.CutPrefix(line, "+++ b/"); ok {
+				path = name
+			}
+			config, inKey = isConfig(path), false
+			continue
+		case line == "":
+			continue
+		}
+		// Only context, added and removed lines carry file content; the
+		// leading marker is kept.
+		switch line[0] {
+		case ' ', '+', '-':
+		default:
+			continue
+		}
+		content, rules := redactLine(line[1:], config, &inKey)
+		if len(rules) == 0 {
+			continue
+		}
+		lines[i] = line[:1] + content
+		files[path] = true
+		for _, r := range rules {
+			report.Redacted++
+			report.Rules[r]++
+			if len(report.Findings) < maxFindings {
+				report.Findings = append(report.Findings, types.Redaction{Path: path, Line: i + 1, Rule: r})
+			} else {
+				report.Truncated = true
+			}
+		}
+	}
+	report.Files = len(files)
+	return strings.Join(lines, "\n"), report
+}
+
+// redactLine returns line with its secrets replaced and the rules that
+// matched. inKey tracks whether the line is inside a private key block.
+func redactLine(line string, config bool, inKey *bool) (string, []string) {
+	if *inKey {
+		if privateKeyEnd.MatchString(line) {
+			*inKey = false
+			return line, nil
+		}
+		if strings.TrimSpace(line) == "" {
+			return line, nil
+		}
+		return placeholder("private-key"), []string{"private-key"}
+	}
+	if privateKeyBegin.MatchString(line) && !privateKeyEnd.MatchString(line) {
+		*inKey = true
+		return line, nil
+	}
+
+	var matched []string
+	for _, r := range tokenRules {
+		line = replace(r.re, line, r.group, func(string) bool { return true }, r.name, &matched)
+	}
+	line = replace(quotedAssignment, line, 1, isSecretValue, "assignment", &matched)
+	if config {
+		line = replace(plainAssignment, line, 1, isSecretValue, "assignment", &matched)
+	}
+	line = replace(quotedString, line, 1, isHighEntropy, "high-entropy", &matched)
+	if config {
+		line = replace(plainValue, line, 1, isHighEntropy, "high-entropy", &matched)
+	}
+	return line, matched
+}
+
+// replace substitutes the placeholder for submatch group of each match of
+// re that accept approves, noting name in matched for each.
+func replace(re *regexp.Regexp, line string, group int, accept func(string) bool, name string, matched *[]string) string {
+	locs := re.FindAllStringSubmatchIndex(line, -1)
+	if locs == nil {
+		return line
+	}
+	var b strings.Builder
+	last := 0
+	for _, loc := range locs {
+		start, end := loc[2*group], loc[2*group+1]
+		if start < 0 || start < last || !accept(line[start:end]) || strings.HasPrefix(line[start:end], "[REDACTED:") {
+			continue
+		}
+		b.WriteString(line[last:start])
+		b.WriteString(placeholder(name))
+		last = end
+		*matched = append(*matched, name)
+	}
+	b.WriteString(line[last:])
+	return b.String()
+}
+
+// isSecretValue rejects values that are references or obvious
+// placeholders rather than secrets.
+func isSecretValue(v string) bool {
+	if strings.ContainsAny(v, "$<>{}()[]%") {
+		return false
+	}
+	lower := strings.ToLower(v)
+	if placeholders[lower] || strings.Trim(lower, "x*.-_") == "" {
+		return false
+	}
+	return true
+}
+
+// isHighEntropy reports whether s looks like random key material: long,
+// mixing letters and digits, with about 4.2 bits of entropy per character
+// or more. Hex digests and identifiers fall below that.
+func isHighEntropy(s string) bool {
+	if !strings.ContainsAny(s, "0123456789") || !strings.ContainsAny(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ") {
+		return false
+	}
+	counts := make(map[rune]int)
+	for _, c := range s {
+		counts[c]++
+	}
+	entropy := 0.0
+	for _, n := range counts {
+		p := float64(n) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy >= 4.2
+}
+
+// isConfig reports whether path is a configuration file or script, where
+// unquoted values are literals.
+func isConfig(path string) bool {
+	base := strings.ToLower(filepath.Base(path))
+	if strings.HasPrefix(base, ".env") {
+		return true
+	}
+	switch filepath.Ext(base) {
+	case ".yaml", ".yml", ".toml", ".sh", ".ini", ".properties", ".conf", ".cfg":
+		return true
+	}
+	return false
+}
+
+func rewrite(bundlePath string, lines []string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(bundlePath), ".bundle-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(writer, "%s\n", line); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), bundlePath)
+}
+
+// Summary describes a report in one line, most frequent rules first.
+func Summary(report types.RedactionReport) string {
+	if report.Redacted == 0 {
+		return "no secrets found"
+	}
+	names := make([]string, 0, len(report.Rules))
+	for name := range report.Rules {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if report.Rules[names[i]] != report.Rules[names[j]] {
+			return report.Rules[names[i]] > report.Rules[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s %d", name, report.Rules[name])
+	}
+	return fmt.Sprintf("%d secrets in %d files (%s)", report.Redacted, report.Files, strings.Join(parts, ", "))
+}
+
+func ReportPath(bundlePath string) string {
+	return filepath.Join(filepath.Dir(bundlePath), ReportName)
+}
+
+func Save(bundlePath string, report types.RedactionReport) error {
+	return utils.SaveToJSON(ReportPath(bundlePath), report)
+}
+
+func Load(bundlePath string) (types.RedactionReport, error) {
+	var report types.RedactionReport
+	if err := utils.LoadFromJSON(ReportPath(bundlePath), &report); err != nil {
+		return types.RedactionReport{}, err
+	}
+	return report, nil
+}
+
+// Remove drops the report kept for a bundle, if any.
+func Remove(bundlePath string) error {
+	if err := os.Remove(ReportPath(bundlePath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
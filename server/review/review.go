@@ -0,0 +1,134 @@
+// Package review computes the diff between two refs of a git checkout and
+// turns the assistant's review of it into structured findings.
+package review
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+// MaxDiffBytes keeps the diff, sent as a single message, well under the
+// Assistants API's message size limit.
+const MaxDiffBytes = 200 << 10
+
+// Prompt asks for findings as JSON; the diff is appended to it.
+const Prompt = `Review the following change as a careful senior engineer would before a human reviewer sees it. Use the repository files to understand how the changed code is used. Report bugs, security problems, missing error handling, behavior changes callers would not expect, and missing tests; do not restate what the change does.
+
+Reply with only a JSON object of this shape:
+
+{
+  "summary": "two or three sentences on the change and its overall risk",
+  "findings": [
+    {"file": "path as it appears in the diff", "line": 42, "severity": "error|warning|info", "message": "what is wrong and how to fix it"}
+  ]
+}
+
+"line" is the line number in the new version of the file. Use "error" for bugs and security problems, "warning" for likely problems and "info" for suggestions. Return an empty "findings" list if there is nothing worth raising.
+
+`
+
+// Resolve returns the commit ref names in the checkout at dir. With fetch
+// set, refs that are not found locally are fetched from origin, which also
+// covers branches a clone only has as origin/<branch> and GitHub pull
+// request refs such as "pull/12/head".
+func Resolve(dir, ref string, fetch bool) (string, error) {
+	if strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("invalid ref '%s'", ref)
+	}
+	if commit, err := revParse(dir, ref); err == nil {
+		return commit, nil
+	}
+	if !fetch {
+		return "", fmt.Errorf("ref '%s' not found", ref)
+	}
+	if commit, err := revParse(dir, "origin/"+ref); err == nil {
+		return commit, nil
+	}
+	if err := exec.Command("git", "-C", dir, "fetch", "--quiet", "origin", ref).Run(); err != nil {
+		return "", fmt.Errorf("ref '%s' not found", ref)
+	}
+	return revParse(dir, "FETCH_HEAD")
+}
+
+func revParse(dir, ref string) (string, error) {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--verify", "--quiet", ref+"^{commit}").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Diff returns the changes on head since it branched from base, and the
+// files they touch. paths limits the diff to those directories.
+func Diff(dir, base, head string, paths []string) (string, []string, error) {
+	rangeArg := base + "..." + head
+	pathspecs := append([]string{"--"}, paths...)
+	args := append([]string{"--literal-pathspecs", "-C", dir, "diff", "--no-color", "--no-ext-diff", "-M", rangeArg}, pathspecs...)
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot diff %s: %v", rangeArg, err)
+	}
+	args = append([]string{"--literal-pathspecs", "-C", dir, "diff", "--name-only", "-M", rangeArg}, pathspecs...)
+	names, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot diff %s: %v", rangeArg, err)
+	}
+
+	files := []string{}
+	for _, name := range strings.Split(strings.TrimSpace(string(names)), "\n") {
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	return string(out), files, nil
+}
+
+// Parse extracts the summary and findings from the assistant's reply.
+// Findings are sorted by file and line, and unknown severities are read as
+// the nearest of error, warning and info.
+func Parse(reply string) (string, []types.ReviewFinding, error) {
+	start := strings.Index(reply, "{")
+	end := strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return "", nil, fmt.Errorf("reply contains no JSON object")
+	}
+
+	var parsed struct {
+		Summary  string                `json:"summary"`
+		Findings []types.ReviewFinding `json:"findings"`
+	}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &parsed); err != nil {
+		return "", nil, fmt.Errorf("cannot parse review: %v", err)
+	}
+
+	findings := []types.ReviewFinding{}
+	for _, f := range parsed.Findings {
+		if strings.TrimSpace(f.Message) == "" {
+			continue
+		}
+		f.Severity = severity(f.Severity)
+		findings = append(findings, f)
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].File != findings[j].File {
+			return findings[i].File < findings[j].File
+		}
+		return findings[i].Line < findings[j].Line
+	})
+	return parsed.Summary, findings, nil
+}
+
+func severity(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "error", "critical", "blocker", "high", "bug":
+		return "error"
+	case "warning", "warn", "major", "medium":
+		return "warning"
+	}
+	return "info"
+}
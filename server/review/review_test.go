@@ -0,0 +1,85 @@
+package review
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		reply       string
+		wantSummary string
+		want        []types.ReviewFinding
+		wantErr     string
+	}{
+		{
+			name:        "no findings",
+			reply:       `{"summary": "Looks good.", "findings": []}`,
+			wantSummary: "Looks good.",
+			want:        []types.ReviewFinding{},
+		},
+		{
+			name: "sorted by file and line",
+			reply: "```json\n" + `{"summary": "Two problems.", "findings": [
+				{"file": "store/store.go", "line": 40, "severity": "warning", "message": "Error ignored."},
+				{"file": "api/handler.go", "line": 12, "severity": "error", "message": "Nil dereference."},
+				{"file": "store/store.go", "line": 3, "severity": "info", "message": "Unused import."}
+			]}` + "\n```",
+			wantSummary: "Two problems.",
+			want: []types.ReviewFinding{
+				{File: "api/handler.go", Line: 12, Severity: "error", Message: "Nil dereference."},
+				{File: "store/store.go", Line: 3, Severity: "info", Message: "Unused import."},
+				{File: "store/store.go", Line: 40, Severity: "warning", Message: "Error ignored."},
+			},
+		},
+		{
+			name: "severities normalized",
+			reply: `Here is my review: {"summary": "", "findings": [
+				{"file": "a.go", "line": 1, "severity": " Critical ", "message": "a"},
+				{"file": "a.go", "line": 2, "severity": "BUG", "message": "b"},
+				{"file": "a.go", "line": 3, "severity": "medium", "message": "c"},
+				{"file": "a.go", "line": 4, "severity": "nit", "message": "d"},
+				{"file": "a.go", "line": 5, "message": "e"}
+			]}`,
+			want: []types.ReviewFinding{
+				{File: "a.go", Line: 1, Severity: "error", Message: "a"},
+				{File: "a.go", Line: 2, Severity: "error", Message: "b"},
+				{File: "a.go", Line: 3, Severity: "warning", Message: "c"},
+				{File: "a.go", Line: 4, Severity: "info", Message: "d"},
+				{File: "a.go", Line: 5, Severity: "info", Message: "e"},
+			},
+		},
+		{
+			name:        "empty messages dropped",
+			reply:       `{"summary": "One.", "findings": [{"file": "a.go", "line": 1, "message": "  "}, {"file": "b.go", "line": 2, "severity": "warn", "message": "Racy."}]}`,
+			wantSummary: "One.",
+			want:        []types.ReviewFinding{{File: "b.go", Line: 2, Severity: "warning", Message: "Racy."}},
+		},
+		{name: "no object", reply: "The diff is empty.", wantErr: "no JSON object"},
+		{name: "invalid json", reply: `{"summary": "x", "findings": [}`, wantErr: "cannot parse review"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary, findings, err := Parse(tt.reply)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if summary != tt.wantSummary {
+				t.Errorf("summary = %q, want %q", summary, tt.wantSummary)
+			}
+			if !reflect.DeepEqual(findings, tt.want) {
+				t.Errorf("findings = %+v, want %+v", findings, tt.want)
+			}
+		})
+	}
+}
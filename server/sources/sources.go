@@ -0,0 +1,269 @@
+// Package sources turns crawl sources other than GitHub URLs into a directory
+// of files for bundling: allow-listed server paths, uploaded archives and git
+// bundles.
+package sources
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Limits bounds what an archive may expand to, guarding against
+// decompression bombs.
+type Limits struct {
+	MaxBytes int64
+	MaxFiles int
+}
+
+// ResolveLocal returns the real path of path if it lies within one of roots.
+// Symlinks are resolved first so they cannot lead outside the roots.
+func ResolveLocal(roots []string, path string) (string, error) {
+	if len(roots) == 0 {
+		return "", fmt.Errorf("local paths are disabled")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve '%s': %v", path, err)
+	}
+
+	for _, root := range roots {
+		realRoot, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		if realRoot, err = filepath.EvalSymlinks(realRoot); err != nil {
+			continue
+		}
+		if within(realRoot, real) {
+			return real, nil
+		}
+	}
+	return "", fmt.Errorf("'%s' is not under an allowed root", path)
+}
+
+// scpRemote matches scp-like ssh remotes such as git@github.com:owner/repo.
+var scpRemote = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[^/-]`)
+
+// CheckRemote accepts only https:// and ssh remotes (ssh:// or git@host:path).
+// Anything else, a path or file:// URL in particular, would let git clone
+// repositories on the server around the local roots allow-list.
+func CheckRemote(remote string) error {
+	lower := strings.ToLower(remote)
+	switch {
+	case strings.HasPrefix(lower, "https://"), strings.HasPrefix(lower, "ssh://"):
+		u, err := url.Parse(remote)
+		if err != nil || u.Host == "" || strings.HasPrefix(u.Host, "-") {
+			return fmt.Errorf("invalid repository URL '%s'", remote)
+		}
+		return nil
+	case scpRemote.MatchString(remote):
+		return nil
+	}
+	return fmt.Errorf("repository URL '%s' must be an https:// or ssh remote", remote)
+}
+
+func IsArchive(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".zip") || strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+func IsGitBundle(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".bundle")
+}
+
+// Stem returns name without its directory and archive or bundle extension.
+func Stem(name string) string {
+	base := filepath.Base(name)
+	lower := strings.ToLower(base)
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip", ".bundle"} {
+		if strings.HasSuffix(lower, ext) {
+			return base[:len(base)-len(ext)]
+		}
+	}
+	return base
+}
+
+// Extract unpacks the .zip or .tar.gz archive at path into dst. name is the
+// archive's original file name, used to pick the format. Entries that would
+// land outside dst, links and special files are rejected or skipped, and
+// extraction stops once limits are exceeded.
+func Extract(path, name, dst string, limits Limits) error {
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+	x := &extractor{dst: dst, limits: limits}
+	if strings.HasSuffix(strings.ToLower(name), ".zip") {
+		return x.zip(path)
+	}
+	return x.tarGz(path)
+}
+
+// CloneBundle checks out the git bundle at path into dst.
+func CloneBundle(path, dst string) error {
+	verify := exec.Command("git", "bundle", "verify", path)
+	if out, err := verify.CombinedOutput(); err != nil {
+		return fmt.Errorf("invalid git bundle: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	clone := exec.Command("git", "clone", path, dst)
+	if out, err := clone.CombinedOutput(); err != nil {
+		return fmt.Errorf("cannot clone git bundle: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+type extractor struct {
+	dst     string
+	limits  Limits
+	written int64
+	files   int
+}
+
+func (x *extractor) zip(path string) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("cannot open zip archive: %v", err)
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		mode := f.FileInfo().Mode()
+		if mode.IsDir() {
+			if _, err := x.dir(f.Name); err != nil {
+				return err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			continue
+		}
+		if x.limits.MaxBytes > 0 && int64(f.UncompressedSize64) > x.limits.MaxBytes-x.written {
+			return fmt.Errorf("archive expands beyond %d bytes", x.limits.MaxBytes)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("cannot read '%s': %v", f.Name, err)
+		}
+		err = x.file(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) tarGz(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("cannot open tar.gz archive: %v", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read tar archive: %v", err)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if _, err := x.dir(hdr.Name); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := x.file(hdr.Name, tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (x *extractor) dir(name string) (string, error) {
+	target, err := x.target(name)
+	if err != nil {
+		return "", err
+	}
+	return target, os.MkdirAll(target, os.ModePerm)
+}
+
+func (x *extractor) file(name string, r io.Reader) error {
+	x.files++
+	if x.limits.MaxFiles > 0 && x.files > x.limits.MaxFiles {
+		return fmt.Errorf("archive has more than %d files", x.limits.MaxFiles)
+	}
+
+	target, err := x.target(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	// Sizes in archive headers can lie, so the budget is enforced on the
+	// bytes actually written.
+	if x.limits.MaxBytes > 0 {
+		r = io.LimitReader(r, x.limits.MaxBytes-x.written+1)
+	}
+	n, err := io.Copy(out, r)
+	x.written += n
+	if err != nil {
+		return fmt.Errorf("cannot extract '%s': %v", name, err)
+	}
+	if x.limits.MaxBytes > 0 && x.written > x.limits.MaxBytes {
+		return fmt.Errorf("archive expands beyond %d bytes", x.limits.MaxBytes)
+	}
+	return nil
+}
+
+// target maps an archive entry name to a path inside dst, rejecting entries
+// that would escape it (zip-slip).
+func (x *extractor) target(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("archive entry '%s' has an absolute path", name)
+	}
+	target := filepath.Join(x.dst, filepath.FromSlash(name))
+	if !within(x.dst, target) {
+		return "", fmt.Errorf("archive entry '%s' escapes the extraction directory", name)
+	}
+	return target, nil
+}
+
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
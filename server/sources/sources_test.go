@@ -0,0 +1,258 @@
+package sources
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type entry struct {
+	name    string
+	body    string
+	symlink string
+}
+
+func writeZip(t *testing.T, entries []entry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "src.zip")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeTarGz(t *testing.T, entries []entry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if e.symlink != "" {
+			hdr = &tar.Header{Name: e.name, Linkname: e.symlink, Typeflag: tar.TypeSymlink}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if e.symlink == "" {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "src.tar.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		limits  Limits
+		wantErr string
+		want    []string
+	}{
+		{
+			name:    "regular files",
+			entries: []entry{{name: "main.go", body: "package main"}, {name: "pkg/util.go", body: "package pkg"}},
+			want:    []string{"main.go", "pkg/util.go"},
+		},
+		{
+			name:    "parent traversal",
+			entries: []entry{{name: "../evil.sh", body: "rm -rf /"}},
+			wantErr: "escapes the extraction directory",
+		},
+		{
+			name:    "nested traversal",
+			entries: []entry{{name: "pkg/../../evil.sh", body: "x"}},
+			wantErr: "escapes the extraction directory",
+		},
+		{
+			name:    "backslash traversal",
+			entries: []entry{{name: `..\evil.sh`, body: "x"}},
+			wantErr: "escapes the extraction directory",
+		},
+		{
+			name:    "absolute path",
+			entries: []entry{{name: "/etc/evil", body: "x"}},
+			wantErr: "absolute path",
+		},
+		{
+			name:    "too many files",
+			entries: []entry{{name: "a", body: "1"}, {name: "b", body: "2"}, {name: "c", body: "3"}},
+			limits:  Limits{MaxFiles: 2},
+			wantErr: "more than 2 files",
+		},
+		{
+			name:    "too large",
+			entries: []entry{{name: "a", body: strings.Repeat("a", 60)}, {name: "b", body: strings.Repeat("b", 60)}},
+			limits:  Limits{MaxBytes: 100},
+			wantErr: "beyond 100 bytes",
+		},
+		{
+			name:    "exactly at the limits",
+			entries: []entry{{name: "a", body: strings.Repeat("a", 50)}, {name: "b", body: strings.Repeat("b", 50)}},
+			limits:  Limits{MaxBytes: 100, MaxFiles: 2},
+			want:    []string{"a", "b"},
+		},
+	}
+	formats := []struct {
+		name  string
+		write func(*testing.T, []entry) string
+	}{
+		{"src.zip", writeZip},
+		{"src.tar.gz", writeTarGz},
+	}
+	for _, format := range formats {
+		for _, tt := range tests {
+			t.Run(format.name+"/"+tt.name, func(t *testing.T) {
+				archive := format.write(t, tt.entries)
+				parent := t.TempDir()
+				dst := filepath.Join(parent, "out")
+
+				err := Extract(archive, format.name, dst, tt.limits)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("Extract error = %v, want %q", err, tt.wantErr)
+					}
+					if _, err := os.Stat(filepath.Join(parent, "evil.sh")); err == nil {
+						t.Error("entry written outside the extraction directory")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Extract: %v", err)
+				}
+				for _, name := range tt.want {
+					if _, err := os.Stat(filepath.Join(dst, filepath.FromSlash(name))); err != nil {
+						t.Errorf("%s not extracted: %v", name, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestExtractSkipsSymlinks(t *testing.T) {
+	archive := writeTarGz(t, []entry{{name: "passwd", symlink: "/etc/passwd"}, {name: "main.go", body: "package main"}})
+	dst := t.TempDir()
+	if err := Extract(archive, "src.tar.gz", dst, Limits{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "passwd")); !os.IsNotExist(err) {
+		t.Errorf("symlink extracted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "main.go")); err != nil {
+		t.Errorf("main.go not extracted: %v", err)
+	}
+}
+
+func TestCheckRemote(t *testing.T) {
+	tests := []struct {
+		remote string
+		ok     bool
+	}{
+		{"https://github.com/acme/app", true},
+		{"https://github.com/acme/app.git", true},
+		{"HTTPS://github.com/acme/app", true},
+		{"ssh://git@github.com/acme/app.git", true},
+		{"git@github.com:acme/app.git", true},
+		{"deploy@git.example.com:acme/app.git", true},
+		{"http://github.com/acme/app", false},
+		{"file:///srv/code/app", false},
+		{"/srv/code/app", false},
+		{"../app", false},
+		{"git://github.com/acme/app", false},
+		{"ext::sh -c touch% /tmp/pwned", false},
+		{"https://", false},
+		{"ssh://-oProxyCommand=evil/app", false},
+		{"git@github.com:-oProxyCommand=evil", false},
+		{"git@github.com:/srv/code/app", false},
+		{"-uhttps://github.com/acme/app", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		err := CheckRemote(tt.remote)
+		if (err == nil) != tt.ok {
+			t.Errorf("CheckRemote(%q) = %v, want ok %v", tt.remote, err, tt.ok)
+		}
+	}
+}
+
+func TestResolveLocal(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		roots []string
+		path  string
+		ok    bool
+	}{
+		{"inside a root", []string{root}, filepath.Join(root, "app"), true},
+		{"the root itself", []string{root}, root, true},
+		{"outside every root", []string{root}, outside, false},
+		{"traversal", []string{root}, filepath.Join(root, "app", "..", "..", filepath.Base(outside)), false},
+		{"symlink out of the root", []string{root}, filepath.Join(root, "escape"), false},
+		{"missing", []string{root}, filepath.Join(root, "missing"), false},
+		{"no roots", nil, filepath.Join(root, "app"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ResolveLocal(tt.roots, tt.path)
+			if (err == nil) != tt.ok {
+				t.Errorf("ResolveLocal(%q) = %v, want ok %v", tt.path, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestStem(t *testing.T) {
+	for name, want := range map[string]string{
+		"/tmp/app.zip":     "app",
+		"app.TAR.GZ":       "app",
+		"app-v1.tgz":       "app-v1",
+		"repo.bundle":      "repo",
+		"notes.txt":        "notes.txt",
+		"dir/app.v2.zip":   "app.v2",
+		"archive.tar.gz.1": "archive.tar.gz.1",
+	} {
+		if got := Stem(name); got != want {
+			t.Errorf("Stem(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
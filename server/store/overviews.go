@@ -0,0 +1,80 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+// maxOverviewsPerRepo is how many overviews are kept for each repository;
+// the least recently generated are dropped first.
+const maxOverviewsPerRepo = 20
+
+// OverviewStore keeps the overviews of each crawled repository by the key
+// of the content they describe, so crawling an earlier commit again reuses
+// its overview.
+type OverviewStore struct {
+	mu        sync.Mutex
+	path      string
+	overviews map[string]types.RepoOverview
+}
+
+func NewOverviewStore(path string) (*OverviewStore, error) {
+	s := &OverviewStore{
+		path:      path,
+		overviews: make(map[string]types.RepoOverview),
+	}
+	if err := load(path, &s.overviews); err != nil {
+		return nil, fmt.Errorf("cannot load overview store '%s': %v", path, err)
+	}
+	// Earlier versions kept one overview per repository, under its key alone.
+	for k, ov := range s.overviews {
+		if !strings.Contains(k, "@") {
+			delete(s.overviews, k)
+			s.overviews[k+"@"+ov.Key] = ov
+		}
+	}
+	return s, nil
+}
+
+func overviewKey(tenantID, username, reponame, key string) string {
+	return repoKey(tenantID, username, reponame) + "@" + key
+}
+
+// Get returns the repository's overview of the content identified by key.
+func (s *OverviewStore) Get(tenantID, username, reponame, key string) (types.RepoOverview, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ov, ok := s.overviews[overviewKey(tenantID, username, reponame, key)]
+	return ov, ok
+}
+
+// Put stores ov under its Key, dropping the repository's oldest overviews
+// beyond maxOverviewsPerRepo.
+func (s *OverviewStore) Put(tenantID, username, reponame string, ov types.RepoOverview) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.overviews[overviewKey(tenantID, username, reponame, ov.Key)] = ov
+
+	prefix := repoKey(tenantID, username, reponame) + "@"
+	var keys []string
+	for k := range s.overviews {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) > maxOverviewsPerRepo {
+		sort.Slice(keys, func(i, j int) bool {
+			return s.overviews[keys[i]].GeneratedAt > s.overviews[keys[j]].GeneratedAt
+		})
+		for _, k := range keys[maxOverviewsPerRepo:] {
+			delete(s.overviews, k)
+		}
+	}
+	return save(s.path, s.overviews)
+}
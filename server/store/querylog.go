@@ -0,0 +1,39 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gastrader/repotalk/types"
+	"github.com/gastrader/repotalk/utils"
+)
+
+// QueryLog appends one JSON line per answered query, so answers can be
+// compared across models and instruction versions.
+type QueryLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewQueryLog(path string) (*QueryLog, error) {
+	if _, err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("cannot create query log directory: %v", err)
+	}
+	return &QueryLog{path: path}, nil
+}
+
+func (l *QueryLog) Append(entry types.QueryLogEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open query log '%s': %v", l.path, err)
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(entry)
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+type RepoStore struct {
+	mu    sync.Mutex
+	path  string
+	repos map[string]types.RepoRecord
+}
+
+func NewRepoStore(path string) (*RepoStore, error) {
+	s := &RepoStore{
+		path:  path,
+		repos: make(map[string]types.RepoRecord),
+	}
+	if err := load(path, &s.repos); err != nil {
+		return nil, fmt.Errorf("cannot load repo store '%s': %v", path, err)
+	}
+	return s, nil
+}
+
+func (s *RepoStore) Get(tenantID, username, reponame string) (types.RepoRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.repos[repoKey(tenantID, username, reponame)]
+	return rec, ok
+}
+
+func (s *RepoStore) Put(rec types.RepoRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.repos[repoKey(rec.TenantID, rec.Username, rec.Reponame)] = rec
+	return save(s.path, s.repos)
+}
+
+// Replace stores rec and returns the record it replaced, if any.
+func (s *RepoStore) Replace(rec types.RepoRecord) (types.RepoRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := repoKey(rec.TenantID, rec.Username, rec.Reponame)
+	prev, ok := s.repos[k]
+	s.repos[k] = rec
+	return prev, ok, save(s.path, s.repos)
+}
+
+func (s *RepoStore) All() []types.RepoRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	repos := make([]types.RepoRecord, 0, len(s.repos))
+	for _, rec := range s.repos {
+		repos = append(repos, rec)
+	}
+	return repos
+}
+
+// AddScope records the file uploaded for part of rec's bundle and returns
+// the file recorded for key, which is another upload if one was recorded
+// concurrently. It records nothing and returns "" if the repository was
+// crawled again since rec was read, as the file holds part of the previous
+// bundle.
+func (s *RepoStore) AddScope(rec types.RepoRecord, key, fileID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := repoKey(rec.TenantID, rec.Username, rec.Reponame)
+	current, ok := s.repos[k]
+	if !ok || current.FileID != rec.FileID {
+		return "", nil
+	}
+	if existing, ok := current.Scopes[key]; ok {
+		return existing, nil
+	}
+	if current.Scopes == nil {
+		current.Scopes = make(map[string]string)
+	}
+	current.Scopes[key] = fileID
+	s.repos[k] = current
+	return fileID, save(s.path, s.repos)
+}
@@ -0,0 +1,27 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gastrader/repotalk/utils"
+)
+
+func repoKey(tenantID, username, reponame string) string {
+	return tenantID + ":" + strings.ToLower(username+"/"+reponame)
+}
+
+func load(path string, v interface{}) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	return utils.LoadFromJSON(path, v)
+}
+
+func save(path string, v interface{}) error {
+	if _, err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	return utils.SaveToJSON(path, v)
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+const keyPrefix = "rt_"
+
+type tenantData struct {
+	Tenants map[string]types.Tenant `json:"tenants"`
+	Keys    map[string]types.APIKey `json:"keys"`
+}
+
+// TenantStore holds tenants and their API keys. Only key hashes are stored;
+// the plaintext key is returned once when it is issued.
+type TenantStore struct {
+	mu   sync.Mutex
+	path string
+	data tenantData
+}
+
+func NewTenantStore(path string) (*TenantStore, error) {
+	s := &TenantStore{
+		path: path,
+		data: tenantData{
+			Tenants: make(map[string]types.Tenant),
+			Keys:    make(map[string]types.APIKey),
+		},
+	}
+	if err := load(path, &s.data); err != nil {
+		return nil, fmt.Errorf("cannot load tenant store '%s': %v", path, err)
+	}
+	return s, nil
+}
+
+func (s *TenantStore) Create(name, openAIKey string) (types.Tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := randomHex(6)
+	if err != nil {
+		return types.Tenant{}, err
+	}
+	tenant := types.Tenant{
+		ID:        "t_" + id,
+		Name:      name,
+		OpenAIKey: openAIKey,
+		CreatedAt: time.Now().Unix(),
+	}
+	s.data.Tenants[tenant.ID] = tenant
+	return tenant, save(s.path, s.data)
+}
+
+func (s *TenantStore) List() []types.Tenant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tenants := make([]types.Tenant, 0, len(s.data.Tenants))
+	for _, tenant := range s.data.Tenants {
+		tenants = append(tenants, tenant)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].CreatedAt < tenants[j].CreatedAt })
+	return tenants
+}
+
+func (s *TenantStore) Get(id string) (types.Tenant, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tenant, ok := s.data.Tenants[id]
+	return tenant, ok
+}
+
+// IssueKey creates a new API key for the tenant and returns its plaintext.
+func (s *TenantStore) IssueKey(tenantID string) (types.APIKey, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data.Tenants[tenantID]; !ok {
+		return types.APIKey{}, "", fmt.Errorf("tenant '%s' not found", tenantID)
+	}
+
+	secret, err := randomHex(24)
+	if err != nil {
+		return types.APIKey{}, "", err
+	}
+	plaintext := keyPrefix + secret
+	key := types.APIKey{
+		ID:        secret[:8],
+		TenantID:  tenantID,
+		Hash:      hashKey(plaintext),
+		CreatedAt: time.Now().Unix(),
+	}
+	s.data.Keys[key.ID] = key
+	return key, plaintext, save(s.path, s.data)
+}
+
+func (s *TenantStore) RevokeKey(keyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.data.Keys[keyID]
+	if !ok {
+		return fmt.Errorf("key '%s' not found", keyID)
+	}
+	key.RevokedAt = time.Now().Unix()
+	s.data.Keys[keyID] = key
+	return save(s.path, s.data)
+}
+
+// Authenticate returns the tenant and key that a plaintext API key belongs to.
+func (s *TenantStore) Authenticate(plaintext string) (types.Tenant, types.APIKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(plaintext) < len(keyPrefix)+8 {
+		return types.Tenant{}, types.APIKey{}, false
+	}
+	key, ok := s.data.Keys[plaintext[len(keyPrefix):len(keyPrefix)+8]]
+	if !ok || key.RevokedAt != 0 || subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashKey(plaintext))) != 1 {
+		return types.Tenant{}, types.APIKey{}, false
+	}
+	tenant, ok := s.data.Tenants[key.TenantID]
+	return tenant, key, ok
+}
+
+func hashKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot generate random id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+type ThreadStore struct {
+	mu      sync.Mutex
+	path    string
+	threads map[types.ThreadID]types.ThreadBinding
+}
+
+func NewThreadStore(path string) (*ThreadStore, error) {
+	s := &ThreadStore{
+		path:    path,
+		threads: make(map[types.ThreadID]types.ThreadBinding),
+	}
+	if err := load(path, &s.threads); err != nil {
+		return nil, fmt.Errorf("cannot load thread store '%s': %v", path, err)
+	}
+	return s, nil
+}
+
+func (s *ThreadStore) Get(tid types.ThreadID) (types.ThreadBinding, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	binding, ok := s.threads[tid]
+	return binding, ok
+}
+
+func (s *ThreadStore) Bind(binding types.ThreadBinding) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.threads[binding.ThreadID] = binding
+	return save(s.path, s.threads)
+}
+
+// AddOutputs records files the assistant produced in the thread so they can
+// be downloaded through the thread later.
+func (s *ThreadStore) AddOutputs(tid types.ThreadID, fileIDs ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	binding, ok := s.threads[tid]
+	if !ok {
+		return fmt.Errorf("thread '%s' is not bound", tid)
+	}
+	binding.OutputIDs = append(binding.OutputIDs, fileIDs...)
+	s.threads[tid] = binding
+	return save(s.path, s.threads)
+}
+
+func (s *ThreadStore) SetPersona(tid types.ThreadID, persona string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	binding, ok := s.threads[tid]
+	if !ok {
+		return fmt.Errorf("thread '%s' is not bound", tid)
+	}
+	binding.Persona = persona
+	s.threads[tid] = binding
+	return save(s.path, s.threads)
+}
+
+// BoundTo reports whether the thread was created by the tenant for the given
+// repository.
+func BoundTo(binding types.ThreadBinding, tenantID, username, reponame string) bool {
+	return repoKey(binding.TenantID, binding.Username, binding.Reponame) == repoKey(tenantID, username, reponame)
+}
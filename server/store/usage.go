@@ -0,0 +1,128 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+const dayLayout = "2006-01-02"
+
+type usageEntry struct {
+	TenantID string                       `json:"tenantID"`
+	Username string                       `json:"username"`
+	Reponame string                       `json:"reponame"`
+	Days     map[string]types.UsageTotals `json:"days"`
+}
+
+type UsageStore struct {
+	mu    sync.Mutex
+	path  string
+	repos map[string]*usageEntry
+}
+
+func NewUsageStore(path string) (*UsageStore, error) {
+	s := &UsageStore{
+		path:  path,
+		repos: make(map[string]*usageEntry),
+	}
+	if err := load(path, &s.repos); err != nil {
+		return nil, fmt.Errorf("cannot load usage store '%s': %v", path, err)
+	}
+	return s, nil
+}
+
+func (s *UsageStore) Record(tenantID, username, reponame string, usage types.Usage, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := repoKey(tenantID, username, reponame)
+	entry, ok := s.repos[key]
+	if !ok {
+		entry = &usageEntry{TenantID: tenantID, Username: username, Reponame: reponame, Days: make(map[string]types.UsageTotals)}
+		s.repos[key] = entry
+	}
+
+	day := at.UTC().Format(dayLayout)
+	entry.Days[day] = addUsage(entry.Days[day], usage)
+	return save(s.path, s.repos)
+}
+
+// Report aggregates a tenant's usage per repository and per day. A non-empty
+// username or reponame narrows it to the repositories with that owner or
+// name, ignoring case as GitHub does, and empty ones select every
+// repository; from and to are inclusive YYYY-MM-DD bounds and may be empty.
+func (s *UsageStore) Report(tenantID, username, reponame, from, to string) types.UsageReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := types.UsageReport{Repos: []types.RepoUsage{}}
+	keys := make([]string, 0, len(s.repos))
+	for key := range s.repos {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		entry := s.repos[key]
+		if entry.TenantID != tenantID {
+			continue
+		}
+		if username != "" && !strings.EqualFold(entry.Username, username) || reponame != "" && !strings.EqualFold(entry.Reponame, reponame) {
+			continue
+		}
+
+		repo := types.RepoUsage{Username: entry.Username, Reponame: entry.Reponame, Days: []types.DailyUsage{}}
+		days := make([]string, 0, len(entry.Days))
+		for day := range entry.Days {
+			if (from == "" || day >= from) && (to == "" || day <= to) {
+				days = append(days, day)
+			}
+		}
+		sort.Strings(days)
+
+		for _, day := range days {
+			totals := entry.Days[day]
+			repo.Days = append(repo.Days, types.DailyUsage{Day: day, UsageTotals: totals})
+			repo.Total = mergeTotals(repo.Total, totals)
+		}
+		if len(repo.Days) == 0 {
+			continue
+		}
+		report.Repos = append(report.Repos, repo)
+		report.Total = mergeTotals(report.Total, repo.Total)
+	}
+	return report
+}
+
+func addUsage(totals types.UsageTotals, usage types.Usage) types.UsageTotals {
+	return mergeTotals(totals, types.UsageTotals{
+		Queries:          1,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+		Cost:             usage.Cost,
+		Models:           map[string]int{usage.Model: 1},
+	})
+}
+
+func mergeTotals(a, b types.UsageTotals) types.UsageTotals {
+	models := make(map[string]int)
+	for _, m := range []map[string]int{a.Models, b.Models} {
+		for model, n := range m {
+			models[model] += n
+		}
+	}
+	return types.UsageTotals{
+		Queries:          a.Queries + b.Queries,
+		PromptTokens:     a.PromptTokens + b.PromptTokens,
+		CompletionTokens: a.CompletionTokens + b.CompletionTokens,
+		TotalTokens:      a.TotalTokens + b.TotalTokens,
+		Cost:             a.Cost + b.Cost,
+		Models:           models,
+	}
+}
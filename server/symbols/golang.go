@@ -0,0 +1,131 @@
+package symbols
+
+import (
+	"bufio"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+func extractGo(path string, src []byte) (types.FileSymbols, bool) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, parser.SkipObjectResolution)
+	if err != nil {
+		return types.FileSymbols{}, false
+	}
+
+	fs := types.FileSymbols{Path: path, Language: "Go", Package: file.Name.Name}
+	line := func(pos token.Pos) int { return fset.Position(pos).Line }
+
+	for _, imp := range file.Imports {
+		if p, err := strconv.Unquote(imp.Path.Value); err == nil {
+			fs.Imports = append(fs.Imports, types.Import{Path: p, Line: line(imp.Pos())})
+		}
+	}
+
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			sym := types.Symbol{Name: d.Name.Name, Kind: "func", Line: line(d.Name.Pos())}
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				sym.Kind = "method"
+				sym.Parent = receiverType(d.Recv.List[0].Type)
+			}
+			fs.Symbols = append(fs.Symbols, sym)
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					kind := "type"
+					if _, ok := s.Type.(*ast.InterfaceType); ok {
+						kind = "interface"
+					}
+					fs.Symbols = append(fs.Symbols, types.Symbol{Name: s.Name.Name, Kind: kind, Line: line(s.Name.Pos())})
+				case *ast.ValueSpec:
+					kind := "var"
+					if d.Tok == token.CONST {
+						kind = "const"
+					}
+					for _, name := range s.Names {
+						if name.Name == "_" {
+							continue
+						}
+						fs.Symbols = append(fs.Symbols, types.Symbol{Name: name.Name, Kind: kind, Line: line(name.Pos())})
+					}
+				}
+			}
+		}
+	}
+	return fs, true
+}
+
+// receiverType returns the type name of a method receiver such as *T or
+// T[K, V].
+func receiverType(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// goImportPath returns the import path of the package in dir, from the
+// nearest go.mod at or above dir within root. modules caches the module path
+// found for each directory ("" if none).
+func goImportPath(root, dir string, modules map[string]string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		rel, err := filepath.Rel(root, d)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return ""
+		}
+
+		module, ok := modules[d]
+		if !ok {
+			module = modulePath(filepath.Join(d, "go.mod"))
+			modules[d] = module
+		}
+		if module != "" {
+			sub, err := filepath.Rel(d, dir)
+			if err != nil || sub == "." {
+				return module
+			}
+			return path.Join(module, filepath.ToSlash(sub))
+		}
+		if rel == "." {
+			return ""
+		}
+	}
+}
+
+func modulePath(goMod string) string {
+	file, err := os.Open(goMod)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if module, ok := strings.CutPrefix(line, "module "); ok {
+			return strings.Trim(strings.TrimSpace(module), `"`)
+		}
+	}
+	return ""
+}
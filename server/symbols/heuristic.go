@@ -0,0 +1,179 @@
+package symbols
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+// pattern maps a line to a symbol: the first submatch is the name.
+type pattern struct {
+	kind string
+	re   *regexp.Regexp
+}
+
+var (
+	scriptPatterns = []pattern{
+		{"func", regexp.MustCompile(`^\s*(?:export\s+)?(?:default\s+)?(?:async\s+)?function\s*\*?\s*([A-Za-z_$][\w$]*)`)},
+		{"class", regexp.MustCompile(`^\s*(?:export\s+)?(?:default\s+)?(?:abstract\s+)?class\s+([A-Za-z_$][\w$]*)`)},
+		{"interface", regexp.MustCompile(`^\s*(?:export\s+)?interface\s+([A-Za-z_$][\w$]*)`)},
+		{"type", regexp.MustCompile(`^\s*(?:export\s+)?type\s+([A-Za-z_$][\w$]*)\s*(?:<[^=]*>)?\s*=`)},
+		{"enum", regexp.MustCompile(`^\s*(?:export\s+)?(?:const\s+)?enum\s+([A-Za-z_$][\w$]*)`)},
+		{"func", regexp.MustCompile(`^(?:export\s+)?(?:const|let)\s+([A-Za-z_$][\w$]*)\s*(?::[^=]+)?=\s*(?:async\s+)?(?:\([^)]*\)|[A-Za-z_$][\w$]*)\s*(?::[^=]+)?=>`)},
+		{"const", regexp.MustCompile(`^export\s+(?:const|let|var)\s+([A-Za-z_$][\w$]*)`)},
+	}
+	scriptMethod  = regexp.MustCompile(`^\s+(?:(?:public|private|protected|static|readonly|abstract|override|async|get|set)\s+)*\*?([A-Za-z_$][\w$]*)\s*(?:<[^>]*>)?\s*\([^;]*$`)
+	scriptKeyword = map[string]bool{"if": true, "for": true, "while": true, "switch": true, "catch": true, "return": true, "function": true, "constructor": true}
+	scriptImports = []*regexp.Regexp{
+		regexp.MustCompile(`^\s*import\s+(?:[^'"]*\s+from\s+)?['"]([^'"]+)['"]`),
+		regexp.MustCompile(`^\s*export\s+[^'"]*\s+from\s+['"]([^'"]+)['"]`),
+		regexp.MustCompile(`require\(\s*['"]([^'"]+)['"]\s*\)`),
+	}
+
+	pythonClass  = regexp.MustCompile(`^(\s*)class\s+([A-Za-z_]\w*)`)
+	pythonDef    = regexp.MustCompile(`^(\s*)(?:async\s+)?def\s+([A-Za-z_]\w*)`)
+	pythonImport = regexp.MustCompile(`^\s*import\s+([\w.]+(?:\s*,\s*[\w.]+)*)`)
+	pythonFrom   = regexp.MustCompile(`^\s*from\s+([\w.]+)\s+import\b`)
+
+	javaPackage = regexp.MustCompile(`^\s*package\s+([\w.]+)\s*;`)
+	javaImport  = regexp.MustCompile(`^\s*import\s+(?:static\s+)?([\w.*]+)\s*;`)
+	javaType    = regexp.MustCompile(`^\s*(?:(?:public|protected|private|abstract|final|static|sealed|non-sealed|strictfp)\s+)*(class|interface|enum|record|@interface)\s+([A-Za-z_$][\w$]*)`)
+	javaMethod  = regexp.MustCompile(`^\s+(?:(?:public|protected|private|static|final|abstract|synchronized|native|default|strictfp)\s+)*(?:<[^>]+>\s+)?[\w$<>\[\],.?]+(?:\s*\[\])*\s+([A-Za-z_$][\w$]*)\s*\([^;]*$`)
+	javaKeyword = map[string]bool{"if": true, "for": true, "while": true, "switch": true, "catch": true, "return": true, "new": true, "else": true, "throw": true}
+)
+
+func extractScript(file string, src []byte) types.FileSymbols {
+	fs := types.FileSymbols{Path: file, Language: "TypeScript"}
+	if ext := strings.ToLower(path.Ext(file)); ext == ".js" || ext == ".jsx" {
+		fs.Language = "JavaScript"
+	}
+
+	// class is the class being declared and classDepth the brace depth its
+	// members are at.
+	class, classDepth, depth := "", 0, 0
+	for i, line := range strings.Split(string(src), "\n") {
+		if class != "" && depth < classDepth {
+			class = ""
+		}
+		if class != "" && depth == classDepth {
+			if m := scriptMethod.FindStringSubmatch(line); m != nil && !scriptKeyword[m[1]] {
+				fs.Symbols = append(fs.Symbols, types.Symbol{Name: m[1], Kind: "method", Parent: class, Line: i + 1})
+			}
+		}
+
+		for _, re := range scriptImports {
+			if m := re.FindStringSubmatch(line); m != nil {
+				fs.Imports = append(fs.Imports, types.Import{Path: m[1], Line: i + 1})
+				break
+			}
+		}
+		// Only top-level and export declarations are matched, so indented
+		// code inside functions does not produce symbols.
+		for _, p := range scriptPatterns {
+			if m := p.re.FindStringSubmatch(line); m != nil {
+				fs.Symbols = append(fs.Symbols, types.Symbol{Name: m[1], Kind: p.kind, Line: i + 1})
+				if p.kind == "class" {
+					class, classDepth = m[1], depth+1
+				}
+				break
+			}
+		}
+		depth += strings.Count(line, "{") - strings.Count(line, "}")
+	}
+	return fs
+}
+
+func extractPython(file string, src []byte) types.FileSymbols {
+	module := strings.TrimSuffix(file, ".py")
+	module = strings.TrimSuffix(module, "/__init__")
+	fs := types.FileSymbols{Path: file, Language: "Python", Package: strings.ReplaceAll(module, "/", ".")}
+
+	// classes tracks the enclosing classes by indentation.
+	type scope struct {
+		indent int
+		name   string
+		// body is the indentation of the class body, once seen.
+		body int
+	}
+	var classes []scope
+	for i, line := range strings.Split(string(src), "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		for len(classes) > 0 && indent <= classes[len(classes)-1].indent {
+			classes = classes[:len(classes)-1]
+		}
+		if n := len(classes); n > 0 && classes[n-1].body < 0 {
+			classes[n-1].body = indent
+		}
+
+		if m := pythonImport.FindStringSubmatch(line); m != nil {
+			for _, name := range strings.Split(m[1], ",") {
+				fs.Imports = append(fs.Imports, types.Import{Path: strings.TrimSpace(name), Line: i + 1})
+			}
+			continue
+		}
+		if m := pythonFrom.FindStringSubmatch(line); m != nil {
+			fs.Imports = append(fs.Imports, types.Import{Path: m[1], Line: i + 1})
+			continue
+		}
+		if m := pythonClass.FindStringSubmatch(line); m != nil {
+			sym := types.Symbol{Name: m[2], Kind: "class", Line: i + 1}
+			if len(classes) > 0 {
+				sym.Parent = classes[len(classes)-1].name
+			}
+			fs.Symbols = append(fs.Symbols, sym)
+			classes = append(classes, scope{indent: indent, name: m[2], body: -1})
+			continue
+		}
+		if m := pythonDef.FindStringSubmatch(line); m != nil {
+			switch {
+			case len(classes) > 0 && indent == classes[len(classes)-1].body:
+				fs.Symbols = append(fs.Symbols, types.Symbol{Name: m[2], Kind: "method", Parent: classes[len(classes)-1].name, Line: i + 1})
+			case indent == 0:
+				fs.Symbols = append(fs.Symbols, types.Symbol{Name: m[2], Kind: "func", Line: i + 1})
+			}
+		}
+	}
+	return fs
+}
+
+func extractJava(file string, src []byte) types.FileSymbols {
+	fs := types.FileSymbols{Path: file, Language: "Java"}
+
+	class := ""
+	for i, line := range strings.Split(string(src), "\n") {
+		if m := javaPackage.FindStringSubmatch(line); m != nil {
+			fs.Package = m[1]
+			continue
+		}
+		if m := javaImport.FindStringSubmatch(line); m != nil {
+			fs.Imports = append(fs.Imports, types.Import{Path: m[1], Line: i + 1})
+			continue
+		}
+		if m := javaType.FindStringSubmatch(line); m != nil {
+			kind := m[1]
+			switch kind {
+			case "record":
+				kind = "class"
+			case "@interface":
+				kind = "interface"
+			}
+			sym := types.Symbol{Name: m[2], Kind: kind, Line: i + 1}
+			if class != "" && strings.HasPrefix(line, " ") {
+				sym.Parent = class
+			} else {
+				class = m[2]
+			}
+			fs.Symbols = append(fs.Symbols, sym)
+			continue
+		}
+		if m := javaMethod.FindStringSubmatch(line); m != nil && class != "" && !javaKeyword[m[1]] {
+			fs.Symbols = append(fs.Symbols, types.Symbol{Name: m[1], Kind: "method", Parent: class, Line: i + 1})
+		}
+	}
+	return fs
+}
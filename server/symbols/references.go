@@ -0,0 +1,177 @@
+package symbols
+
+import (
+	"go/scanner"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gastrader/repotalk/types"
+	"github.com/gastrader/repotalk/utils"
+)
+
+// ReferencesFileName is the reference index kept next to a repository's
+// bundle.
+const ReferencesFileName = "references.json"
+
+// MaxReferences caps the references kept per name, so common names such as
+// "String" or "Error" do not dominate the index.
+const MaxReferences = 500
+
+var (
+	identifier = regexp.MustCompile(`[A-Za-z_$][\w$]*`)
+	// literal matches single-line string literals, whose contents are not
+	// references.
+	literal = regexp.MustCompile("\"(?:[^\"\\\\]|\\\\.)*\"|'(?:[^'\\\\]|\\\\.)*'|`[^`]*`")
+)
+
+// References finds where the names defined in table are used in files under
+// root. Definitions themselves are not references. Go files are tokenized;
+// other languages have comments and string literals stripped line by line.
+func References(root string, files []string, table types.SymbolTable) types.ReferenceIndex {
+	defined := make(map[string]bool)
+	definedAt := make(map[string]bool)
+	for _, file := range table.Files {
+		for _, sym := range file.Symbols {
+			defined[sym.Name] = true
+			definedAt[location(file.Path, sym.Line, sym.Name)] = true
+		}
+	}
+
+	index := make(types.ReferenceIndex)
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			rel = file
+		}
+		rel = filepath.ToSlash(rel)
+		lines := strings.Split(string(src), "\n")
+
+		// One reference past the cap is kept so Lookup can report truncation.
+		add := func(name string, line, column int) {
+			if !defined[name] || definedAt[location(rel, line, name)] || len(index[name]) > MaxReferences {
+				return
+			}
+			index[name] = append(index[name], types.Reference{
+				Path:   rel,
+				Line:   line,
+				Column: column,
+				Text:   strings.TrimSpace(lines[line-1]),
+			})
+		}
+
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".go":
+			goIdentifiers(src, add)
+		case ".ts", ".tsx", ".js", ".jsx", ".java":
+			lineIdentifiers(lines, "//", add)
+		case ".py":
+			lineIdentifiers(lines, "#", add)
+		}
+	}
+
+	for name, refs := range index {
+		sort.Slice(refs, func(i, j int) bool {
+			if refs[i].Path != refs[j].Path {
+				return refs[i].Path < refs[j].Path
+			}
+			if refs[i].Line != refs[j].Line {
+				return refs[i].Line < refs[j].Line
+			}
+			return refs[i].Column < refs[j].Column
+		})
+		index[name] = refs
+	}
+	return index
+}
+
+func goIdentifiers(src []byte, add func(name string, line, column int)) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, 0)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return
+		}
+		if tok == token.IDENT {
+			p := fset.Position(pos)
+			add(lit, p.Line, p.Column)
+		}
+	}
+}
+
+func lineIdentifiers(lines []string, comment string, add func(name string, line, column int)) {
+	for i, line := range lines {
+		// Blank out literals and comments, keeping columns intact.
+		code := literal.ReplaceAllStringFunc(line, func(s string) string { return strings.Repeat(" ", len(s)) })
+		if at := strings.Index(code, comment); at >= 0 {
+			code = code[:at]
+		}
+		for _, m := range identifier.FindAllStringIndex(code, -1) {
+			add(code[m[0]:m[1]], i+1, m[0]+1)
+		}
+	}
+}
+
+func location(path string, line int, name string) string {
+	return path + ":" + strconv.Itoa(line) + ":" + name
+}
+
+func ReferencesPath(bundlePath string) string {
+	return filepath.Join(filepath.Dir(bundlePath), ReferencesFileName)
+}
+
+func SaveReferences(bundlePath string, index types.ReferenceIndex) error {
+	return utils.SaveToJSON(ReferencesPath(bundlePath), index)
+}
+
+func LoadReferences(bundlePath string) (types.ReferenceIndex, error) {
+	var index types.ReferenceIndex
+	if err := utils.LoadFromJSON(ReferencesPath(bundlePath), &index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// Lookup returns the definitions of name and its references. name may be
+// qualified with its parent type, as in "RepoHandler.CrawlHandler", to pick
+// among same-named methods; references are matched by the bare name.
+func Lookup(table types.SymbolTable, index types.ReferenceIndex, name string) types.SymbolLookup {
+	parent, bare := "", name
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		parent, bare = name[:i], name[i+1:]
+	}
+
+	lookup := types.SymbolLookup{Name: name, Definitions: []types.Definition{}, References: []types.Reference{}}
+	for _, file := range table.Files {
+		for _, sym := range file.Symbols {
+			if sym.Name != bare || (parent != "" && sym.Parent != parent) {
+				continue
+			}
+			lookup.Definitions = append(lookup.Definitions, types.Definition{
+				Path:     file.Path,
+				Language: file.Language,
+				Package:  file.Package,
+				Symbol:   sym,
+			})
+		}
+	}
+
+	refs := index[bare]
+	if len(refs) > MaxReferences {
+		refs = refs[:MaxReferences]
+		lookup.Truncated = true
+	}
+	lookup.References = append(lookup.References, refs...)
+	return lookup
+}
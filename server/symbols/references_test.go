@@ -0,0 +1,92 @@
+package symbols
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+func TestReferences(t *testing.T) {
+	root, paths := writeFiles(t, map[string]string{
+		"store/store.go": "package store\n\ntype Store struct{}\n\nfunc (s *Store) Save() {}\n\nfunc New() *Store { return &Store{} }\n",
+		"api/api.go":     "package api\n\n// New is not a reference in a comment.\nvar client = store.New() // nor New here\nvar msg = \"New\"\n",
+		"web/app.ts":     "const store = New() // New\nconst label = 'Save'\n",
+		"app/main.py":    "obj = New()  # New\n",
+		"README.md":      "New\n",
+	})
+	table := Extract(root, paths)
+	index := References(root, paths, table)
+
+	want := types.ReferenceIndex{
+		"New": {
+			{Path: "api/api.go", Line: 4, Column: 20, Text: "var client = store.New() // nor New here"},
+			{Path: "app/main.py", Line: 1, Column: 7, Text: "obj = New()  # New"},
+			{Path: "web/app.ts", Line: 1, Column: 15, Text: "const store = New() // New"},
+		},
+		"Store": {
+			{Path: "store/store.go", Line: 5, Column: 10, Text: "func (s *Store) Save() {}"},
+			{Path: "store/store.go", Line: 7, Column: 13, Text: "func New() *Store { return &Store{} }"},
+			{Path: "store/store.go", Line: 7, Column: 29, Text: "func New() *Store { return &Store{} }"},
+		},
+	}
+	if !reflect.DeepEqual(index, want) {
+		t.Errorf("References = %+v\nwant %+v", index, want)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	table := types.SymbolTable{Files: []types.FileSymbols{
+		{Path: "api/handler.go", Language: "Go", Package: "api", Symbols: []types.Symbol{
+			{Name: "Handler", Kind: "type", Line: 5},
+			{Name: "Close", Kind: "method", Parent: "Handler", Line: 10},
+		}},
+		{Path: "store/store.go", Language: "Go", Package: "store", Symbols: []types.Symbol{
+			{Name: "Close", Kind: "method", Parent: "Store", Line: 20},
+		}},
+	}}
+	closeRefs := []types.Reference{{Path: "main.go", Line: 3, Column: 2, Text: "h.Close()"}}
+	index := types.ReferenceIndex{"Close": closeRefs}
+
+	handlerClose := types.Definition{Path: "api/handler.go", Language: "Go", Package: "api", Symbol: table.Files[0].Symbols[1]}
+	storeClose := types.Definition{Path: "store/store.go", Language: "Go", Package: "store", Symbol: table.Files[1].Symbols[0]}
+
+	tests := []struct {
+		name     string
+		lookup   string
+		wantDefs []types.Definition
+		wantRefs []types.Reference
+	}{
+		{"bare name", "Close", []types.Definition{handlerClose, storeClose}, closeRefs},
+		{"qualified name", "Store.Close", []types.Definition{storeClose}, closeRefs},
+		{"unknown parent", "Conn.Close", []types.Definition{}, closeRefs},
+		{"no references", "Handler", []types.Definition{{Path: "api/handler.go", Language: "Go", Package: "api", Symbol: table.Files[0].Symbols[0]}}, []types.Reference{}},
+		{"unknown", "Open", []types.Definition{}, []types.Reference{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Lookup(table, index, tt.lookup)
+			if got.Name != tt.lookup || got.Truncated {
+				t.Errorf("lookup = %q truncated %v", got.Name, got.Truncated)
+			}
+			if !reflect.DeepEqual(got.Definitions, tt.wantDefs) {
+				t.Errorf("definitions = %+v, want %+v", got.Definitions, tt.wantDefs)
+			}
+			if !reflect.DeepEqual(got.References, tt.wantRefs) {
+				t.Errorf("references = %+v, want %+v", got.References, tt.wantRefs)
+			}
+		})
+	}
+}
+
+func TestLookupTruncated(t *testing.T) {
+	var refs []types.Reference
+	for i := 0; i <= MaxReferences; i++ {
+		refs = append(refs, types.Reference{Path: fmt.Sprintf("f%d.go", i), Line: 1})
+	}
+	got := Lookup(types.SymbolTable{}, types.ReferenceIndex{"String": refs}, "String")
+	if !got.Truncated || len(got.References) != MaxReferences {
+		t.Errorf("got %d references, truncated %v; want %d, truncated", len(got.References), got.Truncated, MaxReferences)
+	}
+}
@@ -0,0 +1,122 @@
+// Package symbols extracts a symbol table (packages, imports, types,
+// functions and methods with their locations) from crawled source files.
+// Go is parsed with go/parser; TypeScript, JavaScript, Python and Java use
+// line-based heuristics.
+package symbols
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gastrader/repotalk/types"
+	"github.com/gastrader/repotalk/utils"
+)
+
+// FileName is the symbol table kept next to a repository's bundle.
+const FileName = "symbols.json"
+
+// IndexName is the bundle section holding the compact symbol index.
+const IndexName = "REPOTALK_SYMBOLS.txt"
+
+// maxIndexLines bounds the index appended to bundles of large repositories.
+// The index has one line per file with symbols.
+const maxIndexLines = 5000
+
+// Extract builds the symbol table of files under root. Files that cannot be
+// read or parsed are skipped.
+func Extract(root string, files []string) types.SymbolTable {
+	table := types.SymbolTable{Files: []types.FileSymbols{}}
+	modules := make(map[string]string)
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			rel = file
+		}
+		rel = filepath.ToSlash(rel)
+
+		var fs types.FileSymbols
+		var ok bool
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".go":
+			fs, ok = extractGo(rel, src)
+			fs.ImportPath = goImportPath(root, filepath.Dir(file), modules)
+		case ".ts", ".tsx", ".js", ".jsx":
+			fs, ok = extractScript(rel, src), true
+		case ".py":
+			fs, ok = extractPython(rel, src), true
+		case ".java":
+			fs, ok = extractJava(rel, src), true
+		}
+		if ok {
+			table.Files = append(table.Files, fs)
+		}
+	}
+	sort.Slice(table.Files, func(i, j int) bool { return table.Files[i].Path < table.Files[j].Path })
+	return table
+}
+
+// Path returns where the symbol table of the repository bundled at
+// bundlePath is kept.
+func Path(bundlePath string) string {
+	return filepath.Join(filepath.Dir(bundlePath), FileName)
+}
+
+func Save(bundlePath string, table types.SymbolTable) error {
+	return utils.SaveToJSON(Path(bundlePath), table)
+}
+
+func Load(bundlePath string) (types.SymbolTable, error) {
+	var table types.SymbolTable
+	if err := utils.LoadFromJSON(Path(bundlePath), &table); err != nil {
+		return types.SymbolTable{}, err
+	}
+	return table, nil
+}
+
+// Index renders the table compactly, one line per file, for inclusion in
+// the bundle:
+//
+//	api/repo_handler.go [api]: type RepoHandler:30, func NewRepoHandler:59, method RepoHandler.CrawlHandler:79
+func Index(table types.SymbolTable) string {
+	var b strings.Builder
+	b.WriteString("Symbol index: where each type, function and method is defined, as kind name:line.\n")
+	lines, omitted := 0, 0
+	for _, file := range table.Files {
+		if len(file.Symbols) == 0 {
+			continue
+		}
+		if lines == maxIndexLines {
+			omitted++
+			continue
+		}
+		lines++
+
+		b.WriteString(file.Path)
+		if file.Package != "" {
+			fmt.Fprintf(&b, " [%s]", file.Package)
+		}
+		b.WriteString(":")
+		for i, sym := range file.Symbols {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			name := sym.Name
+			if sym.Parent != "" {
+				name = sym.Parent + "." + sym.Name
+			}
+			fmt.Fprintf(&b, " %s %s:%d", sym.Kind, name, sym.Line)
+		}
+		b.WriteString("\n")
+	}
+	if omitted > 0 {
+		fmt.Fprintf(&b, "(index truncated after %d lines; %d more files with symbols are not listed)\n", maxIndexLines, omitted)
+	}
+	return b.String()
+}
@@ -0,0 +1,288 @@
+package symbols
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+func TestExtractGo(t *testing.T) {
+	src := `package store
+
+import (
+	"fmt"
+	str "strings"
+)
+
+const MaxUsers = 10
+
+var _, cache = 1, map[string]int{}
+
+type Store struct{}
+
+type Loader interface{ Load() error }
+
+type Set[K comparable, V any] map[K]V
+
+func New() *Store { return &Store{} }
+
+func (s *Store) Save() error { return nil }
+
+func (s Set[K, V]) Len() int { return len(s) }
+`
+	got, ok := extractGo("store/store.go", []byte(src))
+	if !ok {
+		t.Fatal("extractGo failed")
+	}
+	want := types.FileSymbols{
+		Path:     "store/store.go",
+		Language: "Go",
+		Package:  "store",
+		Imports:  []types.Import{{Path: "fmt", Line: 4}, {Path: "strings", Line: 5}},
+		Symbols: []types.Symbol{
+			{Name: "MaxUsers", Kind: "const", Line: 8},
+			{Name: "cache", Kind: "var", Line: 10},
+			{Name: "Store", Kind: "type", Line: 12},
+			{Name: "Loader", Kind: "interface", Line: 14},
+			{Name: "Set", Kind: "type", Line: 16},
+			{Name: "New", Kind: "func", Line: 18},
+			{Name: "Save", Kind: "method", Parent: "Store", Line: 20},
+			{Name: "Len", Kind: "method", Parent: "Set", Line: 22},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("extractGo = %+v, want %+v", got, want)
+	}
+
+	if _, ok := extractGo("bad.go", []byte("package")); ok {
+		t.Error("extractGo accepted a file that does not parse")
+	}
+}
+
+func TestExtractHeuristic(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		src     string
+		extract func(string, []byte) types.FileSymbols
+		want    types.FileSymbols
+	}{
+		{
+			name: "typescript",
+			file: "web/app.ts",
+			src: `import { api } from "./api"
+const fs = require('fs')
+export interface Props { id: string }
+export type ID = string
+export enum Color { Red }
+export class Store {
+  private items = []
+  async load(id: string) {
+    if (id) {
+      return
+    }
+  }
+}
+export const handler = async (req) => {}
+export const VERSION = "1"
+function helper() {}
+`,
+			extract: extractScript,
+			want: types.FileSymbols{
+				Path:     "web/app.ts",
+				Language: "TypeScript",
+				Imports:  []types.Import{{Path: "./api", Line: 1}, {Path: "fs", Line: 2}},
+				Symbols: []types.Symbol{
+					{Name: "Props", Kind: "interface", Line: 3},
+					{Name: "ID", Kind: "type", Line: 4},
+					{Name: "Color", Kind: "enum", Line: 5},
+					{Name: "Store", Kind: "class", Line: 6},
+					{Name: "load", Kind: "method", Parent: "Store", Line: 8},
+					{Name: "handler", Kind: "func", Line: 14},
+					{Name: "VERSION", Kind: "const", Line: 15},
+					{Name: "helper", Kind: "func", Line: 16},
+				},
+			},
+		},
+		{
+			name:    "javascript",
+			file:    "web/index.JS",
+			src:     "export default function main() {}\n",
+			extract: extractScript,
+			want: types.FileSymbols{
+				Path:     "web/index.JS",
+				Language: "JavaScript",
+				Symbols:  []types.Symbol{{Name: "main", Kind: "func", Line: 1}},
+			},
+		},
+		{
+			name: "python",
+			file: "pkg/models/__init__.py",
+			src: `import os, sys
+from typing import List
+
+class User:
+    # a comment
+    def save(self):
+        def inner():
+            pass
+
+    class Meta:
+        def fields(self):
+            pass
+
+async def load():
+    pass
+`,
+			extract: extractPython,
+			want: types.FileSymbols{
+				Path:     "pkg/models/__init__.py",
+				Language: "Python",
+				Package:  "pkg.models",
+				Imports:  []types.Import{{Path: "os", Line: 1}, {Path: "sys", Line: 1}, {Path: "typing", Line: 2}},
+				Symbols: []types.Symbol{
+					{Name: "User", Kind: "class", Line: 4},
+					{Name: "save", Kind: "method", Parent: "User", Line: 6},
+					{Name: "Meta", Kind: "class", Parent: "User", Line: 10},
+					{Name: "fields", Kind: "method", Parent: "Meta", Line: 11},
+					{Name: "load", Kind: "func", Line: 14},
+				},
+			},
+		},
+		{
+			name: "java",
+			file: "src/Main.java",
+			src: `package com.acme.app;
+
+import java.util.List;
+import static java.lang.Math.max;
+
+public final class Main {
+    public static void main(String[] args) {
+        if (args.length > 0) {
+            return;
+        }
+    }
+
+    private List<String> names() {
+        return null;
+    }
+
+    public record Point(int x, int y) {}
+}
+`,
+			extract: extractJava,
+			want: types.FileSymbols{
+				Path:     "src/Main.java",
+				Language: "Java",
+				Package:  "com.acme.app",
+				Imports:  []types.Import{{Path: "java.util.List", Line: 3}, {Path: "java.lang.Math.max", Line: 4}},
+				Symbols: []types.Symbol{
+					{Name: "Main", Kind: "class", Line: 6},
+					{Name: "main", Kind: "method", Parent: "Main", Line: 7},
+					{Name: "names", Kind: "method", Parent: "Main", Line: 13},
+					{Name: "Point", Kind: "class", Parent: "Main", Line: 17},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.extract(tt.file, []byte(tt.src))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("symbols = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// writeFiles creates files, keyed by slash-separated path, under a new
+// directory and returns it with the absolute paths.
+func writeFiles(t *testing.T, files map[string]string) (string, []string) {
+	t.Helper()
+	root := t.TempDir()
+	var paths []string
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return root, paths
+}
+
+func TestExtract(t *testing.T) {
+	root, paths := writeFiles(t, map[string]string{
+		"go.mod":             "module example.com/app\n",
+		"main.go":            "package main\n\nfunc main() {}\n",
+		"internal/db/db.go":  "package db\n\ntype DB struct{}\n",
+		"tools/go.mod":       "module \"example.com/tools\"\n",
+		"tools/gen/gen.go":   "package gen\n",
+		"broken.go":          "package\n",
+		"web/app.tsx":        "export function App() {}\n",
+		"README.md":          "# App\n",
+		"scripts/build.py":   "def build():\n    pass\n",
+		"src/acme/Main.java": "package acme;\npublic class Main {}\n",
+	})
+
+	table := Extract(root, paths)
+	var got []string
+	for _, file := range table.Files {
+		got = append(got, fmt.Sprintf("%s %s %s", file.Path, file.Language, file.ImportPath))
+	}
+	want := []string{
+		"internal/db/db.go Go example.com/app/internal/db",
+		"main.go Go example.com/app",
+		"scripts/build.py Python ",
+		"src/acme/Main.java Java ",
+		"tools/gen/gen.go Go example.com/tools/gen",
+		"web/app.tsx TypeScript ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %q\nwant %q", got, want)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	table := types.SymbolTable{Files: []types.FileSymbols{
+		{Path: "api/handler.go", Package: "api", Symbols: []types.Symbol{
+			{Name: "Handler", Kind: "type", Line: 10},
+			{Name: "Serve", Kind: "method", Parent: "Handler", Line: 20},
+		}},
+		{Path: "api/doc.go", Package: "api"},
+		{Path: "web/app.ts", Symbols: []types.Symbol{{Name: "App", Kind: "func", Line: 1}}},
+	}}
+	want := "Symbol index: where each type, function and method is defined, as kind name:line.\n" +
+		"api/handler.go [api]: type Handler:10, method Handler.Serve:20\n" +
+		"web/app.ts: func App:1\n"
+	if got := Index(table); got != want {
+		t.Errorf("Index =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestIndexTruncated(t *testing.T) {
+	var table types.SymbolTable
+	for i := 0; i < maxIndexLines+3; i++ {
+		table.Files = append(table.Files, types.FileSymbols{
+			Path:    fmt.Sprintf("f%d.go", i),
+			Symbols: []types.Symbol{{Name: "F", Kind: "func", Line: 1}},
+		})
+	}
+	index := Index(table)
+	if n := strings.Count(index, "func F:1"); n != maxIndexLines {
+		t.Errorf("index lists %d files, want %d", n, maxIndexLines)
+	}
+	want := fmt.Sprintf("(index truncated after %d lines; 3 more files with symbols are not listed)\n", maxIndexLines)
+	if !strings.HasSuffix(index, want) {
+		t.Errorf("index does not end with %q", want)
+	}
+}
@@ -2,37 +2,242 @@ package types
 
 type CrawlRequest struct {
 	GithubURL string `json:"githubUrl"`
+	// LocalPath crawls a directory or git bundle on the server instead.
+	LocalPath string `json:"localPath"`
+	// Name is "owner/repo" for sources without a GitHub URL. For GitHub it
+	// overrides the name from the URL, so several branches of a repository
+	// can be crawled side by side.
+	Name string `json:"name"`
+	// Branch is the GitHub branch or tag to crawl instead of the default
+	// branch.
+	Branch string `json:"branch"`
+	// Paths limits a crawl of a monorepo to files under these directories,
+	// relative to its root.
+	Paths        []string `json:"paths,omitempty"`
+	Model        string   `json:"model"`
+	Instructions string   `json:"instructions"`
+	Persona      string   `json:"persona"`
+}
+
+// RepoOverview is a structured summary of a repository generated once per
+// commit at crawl time.
+type RepoOverview struct {
+	Purpose       string           `json:"purpose"`
+	Languages     []LanguageShare  `json:"languages"`
+	EntryPoints   []string         `json:"entryPoints"`
+	Packages      []PackageSummary `json:"packages"`
+	KeyTypes      []KeyTypeSummary `json:"keyTypes"`
+	BuildCommands []string         `json:"buildCommands"`
+	RunCommands   []string         `json:"runCommands"`
+	Commit        string           `json:"commit,omitempty"`
+	// Key identifies the crawled content: the commit, or a hash of the
+	// bundle for sources without one.
+	Key         string `json:"key"`
+	GeneratedAt int64  `json:"generatedAt"`
+	Usage       Usage  `json:"usage"`
+}
+
+type LanguageShare struct {
+	Language string `json:"language"`
+	Files    int    `json:"files"`
+	Lines    int    `json:"lines"`
+}
+
+type PackageSummary struct {
+	Path        string `json:"path"`
+	Description string `json:"description"`
+}
+
+type KeyTypeSummary struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Description string `json:"description"`
+}
+
+// SymbolTable is the static structure of a crawled repository.
+type SymbolTable struct {
+	Files []FileSymbols `json:"files"`
+}
+
+type FileSymbols struct {
+	// Path is relative to the repository root.
+	Path     string `json:"path"`
+	Language string `json:"language"`
+	// Package is the Go or Java package, or the Python module, the file
+	// belongs to.
+	Package string `json:"package,omitempty"`
+	// ImportPath is the full import path of a Go file's package, when its
+	// go.mod was found.
+	ImportPath string   `json:"importPath,omitempty"`
+	Imports    []Import `json:"imports,omitempty"`
+	Symbols    []Symbol `json:"symbols,omitempty"`
+}
+
+type Import struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+}
+
+type Symbol struct {
+	Name string `json:"name"`
+	// Kind is one of type, interface, class, enum, func, method, const or var.
+	Kind string `json:"kind"`
+	// Parent is the receiver or enclosing class of a method.
+	Parent string `json:"parent,omitempty"`
+	Line   int    `json:"line"`
+}
+
+// ReferenceIndex maps each name defined in a repository to the places it is
+// used.
+type ReferenceIndex map[string][]Reference
+
+type Reference struct {
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	// Text is the trimmed source line.
+	Text string `json:"text"`
+}
+
+type Definition struct {
+	Path     string `json:"path"`
+	Language string `json:"language"`
+	Package  string `json:"package,omitempty"`
+	Symbol
+}
+
+// SymbolLookup answers a go-to-definition and find-references query.
+type SymbolLookup struct {
+	Name        string       `json:"name"`
+	Definitions []Definition `json:"definitions"`
+	References  []Reference  `json:"references"`
+	// Truncated is set when references were capped at crawl time.
+	Truncated bool `json:"truncated"`
+}
+
+// DepGraph is the import graph between a repository's packages or modules.
+// External dependencies are included as nodes with Internal unset.
+type DepGraph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+type GraphNode struct {
+	ID       string `json:"id"`
+	Language string `json:"language"`
+	Internal bool   `json:"internal"`
+	Files    int    `json:"files"`
+	// Dependencies and Dependents are the nodes this one imports and the
+	// nodes that import it.
+	Dependencies []string `json:"dependencies"`
+	Dependents   []string `json:"dependents"`
+}
+
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Imports counts the import statements behind the edge.
+	Imports int `json:"imports"`
+}
+
+// RepoHistory is the git history extracted from a checkout at crawl time:
+// its most recent commits, newest first, and who last touched each file.
+type RepoHistory struct {
+	Commits []Commit      `json:"commits"`
+	Files   []FileHistory `json:"files"`
+}
+
+type Commit struct {
+	Hash    string       `json:"hash"`
+	Author  string       `json:"author"`
+	Email   string       `json:"email"`
+	Date    string       `json:"date"`
+	Subject string       `json:"subject"`
+	Body    string       `json:"body,omitempty"`
+	Files   []CommitFile `json:"files,omitempty"`
+}
+
+// CommitFile is a file changed by a commit. Added and Deleted are -1 for
+// binary files.
+type CommitFile struct {
+	Path    string `json:"path"`
+	Added   int    `json:"added"`
+	Deleted int    `json:"deleted"`
+}
+
+type FileHistory struct {
+	Path         string `json:"path"`
+	LastCommit   string `json:"lastCommit"`
+	LastAuthor   string `json:"lastAuthor"`
+	LastModified string `json:"lastModified"`
+	// Authors is the blame summary, when blame was enabled for the crawl.
+	Authors []AuthorLines `json:"authors,omitempty"`
+}
+
+type AuthorLines struct {
+	Author string `json:"author"`
+	Lines  int    `json:"lines"`
+}
+
+// CommitRange scopes a question to the commits after From up to and
+// including To. Either end may be a commit hash prefix or a date; an empty
+// end is open.
+type CommitRange struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
 }
 
 type CrawlResponse struct {
-	Message string `json:"message"`
-	URL     string `json:"url"`
+	Message  string `json:"message"`
+	URL      string `json:"url"`
 	Username string `json:"username"`
 	Reponame string `json:"reponame"`
 	ThreadID string `json:"threadID"`
-	FileID string `json:"fileID"`
+	FileID   string `json:"fileID"`
 	Response string `json:"response"`
+	Model    string `json:"model"`
+	Usage    Usage  `json:"usage"`
+
+	Instructions InstructionsVersion `json:"instructions"`
+	Overview     *RepoOverview       `json:"overview,omitempty"`
+	// OverviewCached is set when the overview was reused for an unchanged
+	// commit and no tokens were spent.
+	OverviewCached bool `json:"overviewCached"`
+	// Redactions reports the secrets replaced in the bundle.
+	Redactions *RedactionReport `json:"redactions,omitempty"`
+	// License is the SPDX identifier detected from the repository's license
+	// files, "unknown" or "none".
+	License string `json:"license,omitempty"`
 }
 
 type QueryResponse struct {
-	Message string `json:"message"`
-	Username string `json:"username"`
-	Reponame string `json:"reponame"`
-	ThreadID string `json:"threadID"`
-	Response string `json:"response"`
+	Message   string        `json:"message"`
+	Username  string        `json:"username"`
+	Reponame  string        `json:"reponame"`
+	ThreadID  string        `json:"threadID"`
+	Response  string        `json:"response"`
+	Parts     []ContentPart `json:"parts"`
+	Citations []Citation    `json:"citations"`
+	Model     string        `json:"model"`
+	Usage     Usage         `json:"usage"`
+
+	Instructions InstructionsVersion `json:"instructions"`
 }
 
 type AsstConfig struct {
-	Name        string
-	Model       string
-	FileBundles []FileBundle
+	Name         string
+	Model        string
+	Tools        []string
+	Instructions string
+	Metadata     map[string]string
+	FileBundles  []FileBundle
 }
 
 type FileBundle struct {
-	SrcDir     string  
-	SrcGlobs   []string 
-	BundleName string  
-	DstExt     string   
+	SrcDir     string
+	SrcGlobs   []string
+	BundleName string
+	DstExt     string
 }
 
 type AsstID string
@@ -40,8 +245,292 @@ type AsstID string
 type ThreadID string
 
 type ThreadRequest struct {
-	ThreadID string `json:"tid"`
-	Question  string `json:"question"`
+	ThreadID   string `json:"tid"`
+	Question   string `json:"question"`
+	GithubUser string `json:"githubUser"`
+	RepoName   string `json:"repoName"`
+	Model      string `json:"model"`
+	Persona    string `json:"persona"`
+	// Commits scopes the question to a range of the crawled history.
+	Commits *CommitRange `json:"commits,omitempty"`
+	// Corpora attaches several crawled repositories to a new thread, for
+	// questions that compare them. GithubUser and RepoName may then be left
+	// empty; the first corpus is the thread's repository.
+	Corpora []CorpusRef `json:"corpora,omitempty"`
+	// Paths restricts a new thread to the crawled files under these
+	// directories.
+	Paths []string `json:"paths,omitempty"`
+}
+
+type CorpusRef struct {
+	// Label names the corpus in answers and citations; it defaults to
+	// "owner/repo".
+	Label      string `json:"label"`
 	GithubUser string `json:"githubUser"`
-	RepoName string `json:"repoName"`
-}
\ No newline at end of file
+	RepoName   string `json:"repoName"`
+}
+
+// Corpus is a crawled repository attached to a thread under a label.
+type Corpus struct {
+	Label    string `json:"label"`
+	Username string `json:"username"`
+	Reponame string `json:"reponame"`
+	FileID   string `json:"fileID"`
+	// Root is the directory bundle paths of the corpus start with.
+	Root string `json:"root"`
+	// BundlePath is the bundle uploaded as FileID, which citations are
+	// resolved against.
+	BundlePath string `json:"bundlePath,omitempty"`
+}
+
+// RedactionReport lists the secrets replaced in a bundle before it was
+// uploaded. The secrets themselves are never recorded.
+type RedactionReport struct {
+	Policy string `json:"policy"`
+	// Files counts the files with at least one secret.
+	Files    int `json:"files"`
+	Redacted int `json:"redacted"`
+	// Rules counts the secrets found by each rule.
+	Rules    map[string]int `json:"rules"`
+	Findings []Redaction    `json:"findings"`
+	// Truncated is set when there were more findings than are listed.
+	Truncated bool `json:"truncated"`
+	// Blocked is set when the strict policy stopped the crawl.
+	Blocked bool `json:"blocked"`
+}
+
+type Redaction struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Rule string `json:"rule"`
+}
+
+// ReviewRequest asks for a review of the changes on Head since it branched
+// from Base, like a pull request. Either may be a branch, tag, commit or,
+// for GitHub repositories, a ref such as "pull/12/head".
+type ReviewRequest struct {
+	GithubUser string `json:"githubUser"`
+	RepoName   string `json:"repoName"`
+	Base       string `json:"base"`
+	Head       string `json:"head"`
+	Model      string `json:"model"`
+	Persona    string `json:"persona"`
+}
+
+type ReviewResponse struct {
+	Message  string `json:"message"`
+	Username string `json:"username"`
+	Reponame string `json:"reponame"`
+	// Base and Head are the commits the refs resolved to.
+	Base     string          `json:"base"`
+	Head     string          `json:"head"`
+	Files    []string        `json:"files"`
+	ThreadID string          `json:"threadID"`
+	Summary  string          `json:"summary"`
+	Findings []ReviewFinding `json:"findings"`
+	Model    string          `json:"model"`
+	Usage    Usage           `json:"usage"`
+
+	Instructions InstructionsVersion `json:"instructions"`
+	// Redactions reports the secrets replaced in the diff before it was
+	// sent; nil when redaction is off.
+	Redactions *RedactionReport `json:"redactions,omitempty"`
+}
+
+type ReviewFinding struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	// Severity is one of error, warning or info.
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+type RepoRecord struct {
+	TenantID   string `json:"tenantID"`
+	Username   string `json:"username"`
+	Reponame   string `json:"reponame"`
+	URL        string `json:"url"`
+	RepoDir    string `json:"repoDir"`
+	BundlePath string `json:"bundlePath"`
+	FileID     string `json:"fileID"`
+	Model      string `json:"model,omitempty"`
+	// InstructionFiles hold the repository's own instructions, if any.
+	InstructionFiles []string `json:"instructionFiles,omitempty"`
+	// Branch and Commit are the git checkout that was bundled, if any.
+	Branch    string `json:"branch,omitempty"`
+	Commit    string `json:"commit,omitempty"`
+	CrawledAt int64  `json:"crawledAt"`
+	// Paths is the part of a monorepo that was crawled; empty for all of it.
+	Paths []string `json:"paths,omitempty"`
+	// Scopes maps the comma-joined paths of scoped threads to the uploaded
+	// file holding that part of the bundle. It is cleared on every crawl.
+	Scopes map[string]string `json:"scopes,omitempty"`
+	// License is the license detected at the last crawl.
+	License string `json:"license,omitempty"`
+	// BundleHash is the SHA-256 of the bundle uploaded as FileID, so an
+	// unchanged bundle is not uploaded again.
+	BundleHash string `json:"bundleHash,omitempty"`
+}
+
+// GitPushEvent is the payload of the generic git webhook.
+type GitPushEvent struct {
+	URL    string `json:"url"`
+	Ref    string `json:"ref"`
+	Commit string `json:"commit"`
+	// DefaultBranch lets pushes refresh repositories whose branch was not
+	// recorded when they were crawled.
+	DefaultBranch string `json:"defaultBranch"`
+}
+
+type WebhookResponse struct {
+	Message   string `json:"message"`
+	Refreshes int    `json:"refreshes"`
+}
+
+type ThreadBinding struct {
+	ThreadID  ThreadID `json:"threadID"`
+	TenantID  string   `json:"tenantID"`
+	Username  string   `json:"username"`
+	Reponame  string   `json:"reponame"`
+	FileID    string   `json:"fileID"`
+	CreatedAt int64    `json:"createdAt"`
+	Persona   string   `json:"persona,omitempty"`
+	OutputIDs []string `json:"outputIDs,omitempty"`
+	// Corpora is set for threads comparing several repositories; Username
+	// and Reponame are then those of the first.
+	Corpora []Corpus `json:"corpora,omitempty"`
+	// Paths is set for threads restricted to part of the repository.
+	Paths []string `json:"paths,omitempty"`
+}
+
+type Annotation struct {
+	Type   string `json:"type"`
+	Text   string `json:"text"`
+	FileID string `json:"fileID"`
+	Quote  string `json:"quote"`
+	// Context is the answer text leading up to the annotation.
+	Context string `json:"context,omitempty"`
+}
+
+type ContentPart struct {
+	Type   string `json:"type"`
+	Text   string `json:"text,omitempty"`
+	FileID string `json:"fileID,omitempty"`
+	URL    string `json:"url,omitempty"`
+}
+
+type Reply struct {
+	Text        string
+	Parts       []ContentPart
+	Annotations []Annotation
+	Usage       Usage
+}
+
+type Citation struct {
+	FileID    string `json:"fileID"`
+	Path      string `json:"path"`
+	StartLine int    `json:"startLine"`
+	EndLine   int    `json:"endLine"`
+	Quote     string `json:"quote,omitempty"`
+	// Corpus is the label of the corpus quoted, in threads with several.
+	Corpus string `json:"corpus,omitempty"`
+	// Match says how the location was found, which is best-effort: "quote"
+	// when the quoted text occurs once in the bundle, "ambiguous" when it
+	// occurs several times and the likeliest was picked, "mention" when the
+	// file was named in the answer before the citation, and "none".
+	Match string `json:"match"`
+}
+
+type Usage struct {
+	Model            string  `json:"model"`
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	TotalTokens      int     `json:"totalTokens"`
+	Cost             float64 `json:"cost"`
+}
+
+type UsageTotals struct {
+	Queries          int            `json:"queries"`
+	PromptTokens     int            `json:"promptTokens"`
+	CompletionTokens int            `json:"completionTokens"`
+	TotalTokens      int            `json:"totalTokens"`
+	Cost             float64        `json:"cost"`
+	Models           map[string]int `json:"models,omitempty"`
+}
+
+type DailyUsage struct {
+	Day string `json:"day"`
+	UsageTotals
+}
+
+type RepoUsage struct {
+	Username string       `json:"username"`
+	Reponame string       `json:"reponame"`
+	Days     []DailyUsage `json:"days"`
+	Total    UsageTotals  `json:"total"`
+}
+
+type UsageReport struct {
+	Repos []RepoUsage `json:"repos"`
+	Total UsageTotals `json:"total"`
+}
+
+type LimitStatus struct {
+	Allowed                bool   `json:"-"`
+	Reason                 string `json:"reason,omitempty"`
+	RetryAfter             int    `json:"retryAfter,omitempty"`
+	RemainingRequests      int    `json:"remainingRequests"`
+	RemainingDailyTokens   int    `json:"remainingDailyTokens"`
+	RemainingMonthlyTokens int    `json:"remainingMonthlyTokens"`
+}
+
+type Tenant struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	OpenAIKey string `json:"openAIKey,omitempty"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+type APIKey struct {
+	ID        string `json:"id"`
+	TenantID  string `json:"tenantID"`
+	Hash      string `json:"hash"`
+	CreatedAt int64  `json:"createdAt"`
+	RevokedAt int64  `json:"revokedAt,omitempty"`
+}
+
+type CreateTenantRequest struct {
+	Name      string `json:"name"`
+	OpenAIKey string `json:"openAIKey"`
+}
+
+type TenantResponse struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+type IssueKeyResponse struct {
+	ID       string `json:"id"`
+	TenantID string `json:"tenantID"`
+	Key      string `json:"key"`
+}
+
+// InstructionsVersion identifies the instructions an answer was produced
+// with, by content hash.
+type InstructionsVersion struct {
+	Global  string   `json:"global"`
+	Repo    []string `json:"repo,omitempty"`
+	Persona string   `json:"persona,omitempty"`
+}
+
+type QueryLogEntry struct {
+	At           int64               `json:"at"`
+	TenantID     string              `json:"tenantID"`
+	Username     string              `json:"username"`
+	Reponame     string              `json:"reponame"`
+	ThreadID     ThreadID            `json:"threadID"`
+	Model        string              `json:"model"`
+	Instructions InstructionsVersion `json:"instructions"`
+	Usage        Usage               `json:"usage"`
+}
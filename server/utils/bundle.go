@@ -0,0 +1,232 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+const bundleHeaderPrefix = "// ==== file path: "
+
+type BundleSection struct {
+	Path       string
+	HeaderLine int
+	EndLine    int
+}
+
+// AppendBundleSection adds a generated section named name to the end of a
+// bundle, in the same format as the files BundleToFile writes.
+func AppendBundleSection(bundlePath, name, content string) error {
+	file, err := os.OpenFile(bundlePath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "\n%s%s\n%s\n\n", bundleHeaderPrefix, name, strings.TrimRight(content, "\n"))
+	return err
+}
+
+// ParseBundleIndex returns the files contained in a bundle written by
+// BundleToFile, with the bundle line numbers each file spans.
+func ParseBundleIndex(bundlePath string) ([]BundleSection, []string, error) {
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var sections []BundleSection
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		lines = append(lines, line)
+		if strings.HasPrefix(line, bundleHeaderPrefix) {
+			if n := len(sections); n > 0 {
+				sections[n-1].EndLine = len(lines) - 1
+			}
+			sections = append(sections, BundleSection{
+				Path:       strings.TrimPrefix(line, bundleHeaderPrefix),
+				HeaderLine: len(lines),
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if n := len(sections); n > 0 {
+		sections[n-1].EndLine = len(lines)
+	}
+	return sections, lines, nil
+}
+
+// ResolveCitations maps file_search annotations on a bundle back to the
+// original repository file and line range they cite. Annotations often
+// carry no quote, so the mapping is best-effort; each citation's Match says
+// how it was made.
+func ResolveCitations(bundlePath, repoDir string, annotations []types.Annotation) []types.Citation {
+	citations := []types.Citation{}
+	sections, lines, err := ParseBundleIndex(bundlePath)
+	if err != nil {
+		sections, lines = nil, nil
+	}
+
+	prefix := filepath.ToSlash(filepath.Clean(repoDir)) + "/"
+	paths := make([]string, len(sections))
+	for i, section := range sections {
+		paths[i] = strings.TrimPrefix(section.Path, prefix)
+	}
+
+	seen := make(map[string]bool)
+	for _, ann := range annotations {
+		if ann.Type != "file_citation" || seen[ann.Text+ann.Quote] {
+			continue
+		}
+		seen[ann.Text+ann.Quote] = true
+
+		citation := types.Citation{FileID: ann.FileID, Quote: ann.Quote, Match: "none"}
+		mentioned := mentionedSection(paths, ann.Context)
+		if starts := locateQuote(lines, ann.Quote); len(starts) > 0 {
+			start, match := starts[0], "ambiguous"
+			if len(starts) == 1 {
+				match = "quote"
+			} else if mentioned >= 0 {
+				// Prefer an occurrence in the file the answer names.
+				for _, s := range starts {
+					if s > sections[mentioned].HeaderLine && s <= sections[mentioned].EndLine {
+						start = s
+						break
+					}
+				}
+			}
+			end := start + len(strings.Split(strings.TrimSpace(ann.Quote), "\n")) - 1
+			for i, section := range sections {
+				if start > section.HeaderLine && start <= section.EndLine {
+					citation.Path = paths[i]
+					citation.StartLine = start - section.HeaderLine
+					citation.EndLine = end - section.HeaderLine
+					citation.Match = match
+					break
+				}
+			}
+		} else if mentioned >= 0 {
+			section := sections[mentioned]
+			// Sections end with the blank lines BundleToFile pads files with.
+			end := section.EndLine
+			for end > section.HeaderLine+1 && strings.TrimSpace(lines[end-1]) == "" {
+				end--
+			}
+			citation.Path = paths[mentioned]
+			citation.StartLine, citation.EndLine = 1, end-section.HeaderLine
+			if line := locateCode(lines[section.HeaderLine:end], ann.Context); line > 0 {
+				citation.StartLine, citation.EndLine = line, line
+			}
+			citation.Match = "mention"
+		}
+		citations = append(citations, citation)
+	}
+	return citations
+}
+
+// locateQuote returns the 1-based bundle lines where quote starts. Every
+// line of the quote must match, so a common first line does not decide.
+func locateQuote(lines []string, quote string) []int {
+	var quoteLines []string
+	for _, line := range strings.Split(strings.TrimSpace(quote), "\n") {
+		quoteLines = append(quoteLines, strings.TrimSpace(line))
+	}
+	if len(quoteLines) == 0 || quoteLines[0] == "" {
+		return nil
+	}
+
+	var starts []int
+	for i := range lines {
+		if i+len(quoteLines) > len(lines) {
+			break
+		}
+		match := true
+		for j, q := range quoteLines {
+			if !strings.Contains(lines[i+j], q) {
+				match = false
+				break
+			}
+		}
+		if match {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
+
+// mentionedSection returns the index of the section whose path context
+// names last, or -1. Paths are matched in full, or by base name when only
+// one file has it.
+func mentionedSection(paths []string, context string) int {
+	if context == "" {
+		return -1
+	}
+	bases := make(map[string]int)
+	for _, p := range paths {
+		bases[path.Base(p)]++
+	}
+
+	best, at := -1, -1
+	for i, p := range paths {
+		pos := strings.LastIndex(context, p)
+		if pos < 0 && bases[path.Base(p)] == 1 {
+			pos = lastWord(context, path.Base(p))
+		}
+		if pos > at {
+			best, at = i, pos
+		}
+	}
+	return best
+}
+
+// lastWord returns the last index of word in s where it is not part of a
+// longer name, or -1.
+func lastWord(s, word string) int {
+	for end := len(s); end > 0; {
+		i := strings.LastIndex(s[:end], word)
+		if i < 0 {
+			return -1
+		}
+		before := i == 0 || !isNameByte(s[i-1])
+		after := i+len(word) == len(s) || !isNameByte(s[i+len(word)])
+		if before && after {
+			return i
+		}
+		end = i
+	}
+	return -1
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c == '-' || c == '.' || c == '/' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// locateCode returns the 1-based line of lines that contains the last
+// code span (`...`) of context found there, or 0.
+func locateCode(lines []string, context string) int {
+	spans := strings.Split(context, "`")
+	// Code spans are the odd pieces.
+	for i := len(spans) - 2; i >= 1; i -= 2 {
+		code := strings.TrimSpace(spans[i])
+		if code == "" || strings.Contains(code, "\n") {
+			continue
+		}
+		for n, line := range lines {
+			if strings.Contains(line, code) {
+				return n + 1
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gastrader/repotalk/types"
+)
+
+// writeRepo creates files, keyed by slash-separated path, under a new
+// directory and returns it with the absolute paths in the given order.
+func writeRepo(t *testing.T, names []string, files map[string]string) (string, []string) {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(files[name]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return dir, paths
+}
+
+func writeBundle(t *testing.T, names []string, files map[string]string) (string, string) {
+	t.Helper()
+	repoDir, paths := writeRepo(t, names, files)
+	bundlePath := filepath.Join(t.TempDir(), "bundle.txt")
+	if err := BundleToFile(paths, bundlePath); err != nil {
+		t.Fatal(err)
+	}
+	return bundlePath, repoDir
+}
+
+func TestParseBundleIndex(t *testing.T) {
+	bundlePath, repoDir := writeBundle(t, []string{"a.go", "b.go"}, map[string]string{
+		"a.go": "package a\n\nfunc A() {}\n",
+		"b.go": "package b\n",
+	})
+
+	sections, lines, err := ParseBundleIndex(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sections) != 2 {
+		t.Fatalf("got %d sections, want 2", len(sections))
+	}
+	prefix := filepath.ToSlash(repoDir) + "/"
+	for i, want := range []struct {
+		path  string
+		first string
+	}{{"a.go", "package a"}, {"b.go", "package b"}} {
+		s := sections[i]
+		if s.Path != prefix+want.path {
+			t.Errorf("section %d path = %q, want %q", i, s.Path, prefix+want.path)
+		}
+		// HeaderLine is the 1-based header line, which is also the index of
+		// the file's first line.
+		if lines[s.HeaderLine] != want.first {
+			t.Errorf("section %d starts with %q, want %q", i, lines[s.HeaderLine], want.first)
+		}
+	}
+	if sections[0].EndLine >= sections[1].HeaderLine {
+		t.Errorf("sections overlap: %+v", sections)
+	}
+}
+
+func TestResolveCitations(t *testing.T) {
+	names := []string{"api/handler.go", "store/store.go", "store/util.go"}
+	bundlePath, repoDir := writeBundle(t, names, map[string]string{
+		"api/handler.go": "package api\n\nfunc Handle() {\n\tvalidate()\n\treturn\n}\n",
+		"store/store.go": "package store\n\nfunc Save() {\n\tvalidate()\n\treturn\n}\n",
+		"store/util.go":  "package store\n\nfunc helper() {}\n",
+	})
+
+	tests := []struct {
+		name string
+		ann  types.Annotation
+		want types.Citation
+	}{
+		{
+			name: "unique quote",
+			ann:  types.Annotation{Type: "file_citation", Text: "【1】", FileID: "f", Quote: "func helper() {}"},
+			want: types.Citation{FileID: "f", Quote: "func helper() {}", Path: "store/util.go", StartLine: 3, EndLine: 3, Match: "quote"},
+		},
+		{
+			name: "multi-line quote",
+			ann:  types.Annotation{Type: "file_citation", Text: "【2】", FileID: "f", Quote: "func Save() {\n\tvalidate()"},
+			want: types.Citation{FileID: "f", Quote: "func Save() {\n\tvalidate()", Path: "store/store.go", StartLine: 3, EndLine: 4, Match: "quote"},
+		},
+		{
+			name: "ambiguous quote takes the first",
+			ann:  types.Annotation{Type: "file_citation", Text: "【3】", FileID: "f", Quote: "validate()\n\treturn"},
+			want: types.Citation{FileID: "f", Quote: "validate()\n\treturn", Path: "api/handler.go", StartLine: 4, EndLine: 5, Match: "ambiguous"},
+		},
+		{
+			name: "ambiguous quote in the mentioned file",
+			ann:  types.Annotation{Type: "file_citation", Text: "【4】", FileID: "f", Quote: "validate()\n\treturn", Context: "Saving in store/store.go validates first"},
+			want: types.Citation{FileID: "f", Quote: "validate()\n\treturn", Path: "store/store.go", StartLine: 4, EndLine: 5, Match: "ambiguous"},
+		},
+		{
+			name: "mention by base name and code span",
+			ann:  types.Annotation{Type: "file_citation", Text: "【5】", FileID: "f", Context: "The handler in handler.go calls `validate()`"},
+			want: types.Citation{FileID: "f", Path: "api/handler.go", StartLine: 4, EndLine: 4, Match: "mention"},
+		},
+		{
+			name: "mention without code spans covers the file",
+			ann:  types.Annotation{Type: "file_citation", Text: "【6】", FileID: "f", Context: "See store/util.go for helpers"},
+			want: types.Citation{FileID: "f", Path: "store/util.go", StartLine: 1, EndLine: 3, Match: "mention"},
+		},
+		{
+			name: "last mention wins",
+			ann:  types.Annotation{Type: "file_citation", Text: "【7】", FileID: "f", Context: "Compare api/handler.go with store/store.go"},
+			want: types.Citation{FileID: "f", Path: "store/store.go", StartLine: 1, EndLine: 6, Match: "mention"},
+		},
+		{
+			name: "unknown quote and no mention",
+			ann:  types.Annotation{Type: "file_citation", Text: "【8】", FileID: "f", Quote: "not in the bundle"},
+			want: types.Citation{FileID: "f", Quote: "not in the bundle", Match: "none"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ResolveCitations(bundlePath, repoDir, []types.Annotation{tt.ann})
+			if len(got) != 1 {
+				t.Fatalf("got %d citations, want 1", len(got))
+			}
+			if got[0] != tt.want {
+				t.Errorf("citation = %+v, want %+v", got[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveCitationsSkipsDuplicatesAndOtherTypes(t *testing.T) {
+	bundlePath, repoDir := writeBundle(t, []string{"main.go"}, map[string]string{"main.go": "package main\n"})
+	annotations := []types.Annotation{
+		{Type: "file_citation", Text: "【1】", Quote: "package main"},
+		{Type: "file_citation", Text: "【1】", Quote: "package main"},
+		{Type: "file_path", Text: "sandbox:/out.png"},
+	}
+	if got := ResolveCitations(bundlePath, repoDir, annotations); len(got) != 1 {
+		t.Errorf("got %d citations, want 1: %+v", len(got), got)
+	}
+}
+
+func TestResolveCitationsWithoutBundle(t *testing.T) {
+	got := ResolveCitations(filepath.Join(t.TempDir(), "missing.txt"), "", []types.Annotation{
+		{Type: "file_citation", Text: "【1】", FileID: "f", Quote: "anything"},
+	})
+	want := types.Citation{FileID: "f", Quote: "anything", Match: "none"}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("citations = %+v, want [%+v]", got, want)
+	}
+}
+
+func TestLastWord(t *testing.T) {
+	tests := []struct {
+		s, word string
+		want    int
+	}{
+		{"see util.go here", "util.go", 4},
+		{"see store/util.go", "util.go", -1},
+		{"myutil.go and util.go", "util.go", 14},
+		{"util.go", "util.go", 0},
+		{"nothing", "util.go", -1},
+	}
+	for _, tt := range tests {
+		if got := lastWord(tt.s, tt.word); got != tt.want {
+			t.Errorf("lastWord(%q, %q) = %d, want %d", tt.s, tt.word, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CleanScope validates path prefixes relative to a repository root and
+// returns them cleaned, slash-separated, sorted and without duplicates.
+func CleanScope(paths []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var scope []string
+	for _, p := range paths {
+		p = strings.TrimSpace(filepath.ToSlash(p))
+		if p == "" {
+			continue
+		}
+		clean := path.Clean(strings.Trim(p, "/"))
+		if strings.HasPrefix(p, "/") || clean == ".." || strings.HasPrefix(clean, "../") {
+			return nil, fmt.Errorf("path '%s' must be relative to the repository root", p)
+		}
+		if clean == "." {
+			// The whole repository.
+			return nil, nil
+		}
+		if !seen[clean] {
+			seen[clean] = true
+			scope = append(scope, clean)
+		}
+	}
+	sort.Strings(scope)
+	return scope, nil
+}
+
+// InScope reports whether rel, a slash-separated path relative to the
+// repository root, is one of paths or under one of them. An empty scope
+// contains everything.
+func InScope(rel string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, p := range paths {
+		if rel == p || strings.HasPrefix(rel, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeFiles returns the files under root that are in scope.
+func ScopeFiles(root string, files []string, paths []string) []string {
+	if len(paths) == 0 {
+		return files
+	}
+	var scoped []string
+	for _, file := range files {
+		rel, err := filepath.Rel(root, file)
+		if err == nil && InScope(filepath.ToSlash(rel), paths) {
+			scoped = append(scoped, file)
+		}
+	}
+	return scoped
+}
+
+// ScopePath is where the part of a bundle under paths is written.
+func ScopePath(bundlePath string, paths []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(paths, "\n")))
+	return filepath.Join(filepath.Dir(bundlePath), "scopes", hex.EncodeToString(sum[:6])+".txt")
+}
+
+// ScopeBundle writes the files of a bundle that are in scope to
+// ScopePath, with repoDir the directory the bundle was made from, and
+// returns how many were written.
+func ScopeBundle(bundlePath, repoDir string, paths []string) (string, int, error) {
+	sections, lines, err := ParseBundleIndex(bundlePath)
+	if err != nil {
+		return "", 0, err
+	}
+
+	dst := ScopePath(bundlePath, paths)
+	if _, err := EnsureDir(filepath.Dir(dst)); err != nil {
+		return "", 0, err
+	}
+	file, err := os.Create(dst)
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot create file '%s': %v", dst, err)
+	}
+	defer file.Close()
+
+	prefix := filepath.ToSlash(filepath.Clean(repoDir)) + "/"
+	writer := bufio.NewWriter(file)
+	n := 0
+	for _, section := range sections {
+		if !strings.HasPrefix(section.Path, prefix) || !InScope(strings.TrimPrefix(section.Path, prefix), paths) {
+			continue
+		}
+		for _, line := range lines[section.HeaderLine-1 : section.EndLine] {
+			if _, err := fmt.Fprintf(writer, "%s\n", line); err != nil {
+				return "", 0, err
+			}
+		}
+		n++
+	}
+	return dst, n, writer.Flush()
+}
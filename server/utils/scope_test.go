@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCleanScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		paths   []string
+		want    []string
+		wantErr bool
+	}{
+		{"empty", nil, nil, false},
+		{"sorted and deduplicated", []string{"libs/auth", "services/billing", "libs/auth"}, []string{"libs/auth", "services/billing"}, false},
+		{"cleaned", []string{"services/billing/", "./libs//auth", " docs "}, []string{"docs", "libs/auth", "services/billing"}, false},
+		{"blank entries skipped", []string{"", "  ", "api"}, []string{"api"}, false},
+		{"root means everything", []string{"api", "."}, nil, false},
+		{"absolute", []string{"/etc"}, nil, true},
+		{"parent", []string{".."}, nil, true},
+		{"escaping", []string{"api/../../etc"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CleanScope(tt.paths)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CleanScope(%q) error = %v, want error %v", tt.paths, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CleanScope(%q) = %q, want %q", tt.paths, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInScope(t *testing.T) {
+	paths := []string{"libs/auth", "services/billing"}
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{"libs/auth", true},
+		{"libs/auth/token.go", true},
+		{"services/billing/api/handler.go", true},
+		{"libs/authz/policy.go", false},
+		{"services/shipping/main.go", false},
+		{"README.md", false},
+	}
+	for _, tt := range tests {
+		if got := InScope(tt.rel, paths); got != tt.want {
+			t.Errorf("InScope(%q) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+	if !InScope("anything.go", nil) {
+		t.Error("an empty scope does not contain everything")
+	}
+}
+
+func TestScopeFiles(t *testing.T) {
+	root := filepath.Join("/srv", "repo")
+	files := []string{
+		filepath.Join(root, "libs", "auth", "token.go"),
+		filepath.Join(root, "libs", "authz", "policy.go"),
+		filepath.Join(root, "main.go"),
+	}
+	got := ScopeFiles(root, files, []string{"libs/auth"})
+	if want := files[:1]; !reflect.DeepEqual(got, want) {
+		t.Errorf("ScopeFiles = %q, want %q", got, want)
+	}
+	if got := ScopeFiles(root, files, nil); !reflect.DeepEqual(got, files) {
+		t.Errorf("ScopeFiles without scope = %q, want every file", got)
+	}
+}
+
+func TestScopeBundle(t *testing.T) {
+	names := []string{"libs/auth/token.go", "libs/authz/policy.go", "services/billing/main.go", "README.md"}
+	bundlePath, repoDir := writeBundle(t, names, map[string]string{
+		"libs/auth/token.go":       "package auth\n",
+		"libs/authz/policy.go":     "package authz\n",
+		"services/billing/main.go": "package main\n\nfunc main() {}\n",
+		"README.md":                "# Repo\n",
+	})
+
+	paths := []string{"libs/auth", "services/billing"}
+	dst, n, err := ScopeBundle(bundlePath, repoDir, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst != ScopePath(bundlePath, paths) {
+		t.Errorf("written to %q, want %q", dst, ScopePath(bundlePath, paths))
+	}
+	if n != 2 {
+		t.Errorf("wrote %d files, want 2", n)
+	}
+
+	sections, lines, err := ParseBundleIndex(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range sections {
+		got = append(got, strings.TrimPrefix(s.Path, filepath.ToSlash(repoDir)+"/"))
+	}
+	if want := []string{"libs/auth/token.go", "services/billing/main.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("scoped bundle holds %q, want %q", got, want)
+	}
+	// Sections keep their lines, so citations resolve the same way.
+	if lines[sections[1].HeaderLine+2] != "func main() {}" {
+		t.Errorf("scoped section lines moved: %q", lines[sections[1].HeaderLine:])
+	}
+}
+
+func TestScopePath(t *testing.T) {
+	bundlePath := filepath.Join("/data", "bundles", "acme", "app", "bundle.txt")
+	a := ScopePath(bundlePath, []string{"libs/auth"})
+	b := ScopePath(bundlePath, []string{"libs/auth", "services/billing"})
+	if a == b {
+		t.Error("different scopes share a path")
+	}
+	if a != ScopePath(bundlePath, []string{"libs/auth"}) {
+		t.Error("scope path is not stable")
+	}
+	if filepath.Dir(a) != filepath.Join(filepath.Dir(bundlePath), "scopes") {
+		t.Errorf("scope path %q is not next to the bundle", a)
+	}
+}
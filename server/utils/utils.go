@@ -53,6 +53,11 @@ func ListFiles(dir string) ([]string, error) {
 			return nil
 		}
 
+		// Links could point outside the tree being bundled.
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+
 		if allowedExtensions[filepath.Ext(path)] {
 			files = append(files, path)
 		}
@@ -99,16 +104,28 @@ func LoadFromJSON(filePath string, v interface{}) error {
 	return json.NewDecoder(file).Decode(v)
 }
 
+// SaveToJSON writes v to a temporary file next to filePath and renames it
+// into place, so a crash mid-write never leaves a truncated file behind.
 func SaveToJSON(filePath string, v interface{}) error {
-	file, err := os.Create(filePath)
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*")
 	if err != nil {
 		return fmt.Errorf("cannot create file '%s': %v", filePath, err)
 	}
-	defer file.Close()
+	defer os.Remove(file.Name())
 
 	encoder := json.NewEncoder(file)
 	encoder.SetIndent("", "  ")
-	return encoder.Encode(v)
+	if err := encoder.Encode(v); err != nil {
+		file.Close()
+		return fmt.Errorf("cannot write file '%s': %v", filePath, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("cannot write file '%s': %v", filePath, err)
+	}
+	if err := os.Rename(file.Name(), filePath); err != nil {
+		return fmt.Errorf("cannot replace file '%s': %v", filePath, err)
+	}
+	return nil
 }
 
 func BundleToFile(files []string, dstFilePath string) error {
@@ -130,7 +147,7 @@ func BundleToFile(files []string, dstFilePath string) error {
 		}
 
 		scanner := bufio.NewScanner(reader)
-		_, err = fmt.Fprintf(writer, "\n// ==== file path: %s\n", filepath.ToSlash(file))
+		_, err = fmt.Fprintf(writer, "\n%s%s\n", bundleHeaderPrefix, filepath.ToSlash(file))
 		if err != nil {
 			reader.Close()
 			return err
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveToJSONReplacesAtomically(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	if err := SaveToJSON(path, map[string]int{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveToJSON(path, map[string]int{"b": 2}); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]int
+	if err := LoadFromJSON(path, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, map[string]int{"b": 2}) {
+		t.Errorf("loaded %v", got)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %d entries", len(entries))
+	}
+}